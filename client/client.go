@@ -2,11 +2,19 @@ package client
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/a-h/jsonapi"
 	"github.com/a-h/ragserver/models"
@@ -20,18 +28,242 @@ func New(baseURL, apiKey string) Client {
 }
 
 type Client struct {
-	baseURL string
-	apiKey  string
+	baseURL    string
+	apiKey     string
+	collection string
+}
+
+// WithCollection returns a client whose document operations act on the named
+// shared collection, instead of the user's personal collection.
+func (c Client) WithCollection(collection string) Client {
+	c.collection = collection
+	return c
+}
+
+// collectionQuery returns the query parameters that select the collection.
+func (c Client) collectionQuery() map[string]string {
+	if c.collection == "" {
+		return nil
+	}
+	return map[string]string{"collection": c.collection}
 }
 
 func (c Client) DocumentsPut(ctx context.Context, req models.DocumentsPostRequest) (resp models.DocumentsPostResponse, err error) {
-	url, err := jsonapi.URL(c.baseURL).Path("documents").String()
+	url, err := jsonapi.URL(c.baseURL).Path("documents").Query(c.collectionQuery()).String()
 	if err != nil {
 		return resp, err
 	}
 	return jsonapi.Post[models.DocumentsPostRequest, models.DocumentsPostResponse](ctx, url, req, jsonapi.WithRequestHeader("Authorization", c.apiKey))
 }
 
+// DocumentsBatchPut puts up to 100 documents. Documents that fail have an
+// Error set in their result, rather than failing the whole request.
+func (c Client) DocumentsBatchPut(ctx context.Context, req models.DocumentsBatchPostRequest) (resp models.DocumentsBatchPostResponse, err error) {
+	url, err := jsonapi.URL(c.baseURL).Path("documents:batch").Query(c.collectionQuery()).String()
+	if err != nil {
+		return resp, err
+	}
+	return jsonapi.Post[models.DocumentsBatchPostRequest, models.DocumentsBatchPostResponse](ctx, url, req, jsonapi.WithRequestHeader("Authorization", c.apiKey))
+}
+
+// UploadFile is a file to upload with DocumentsUpload.
+type UploadFile struct {
+	Name string
+	// ContentType of the file. If empty, the server uses the extension of the name.
+	ContentType string
+	Content     io.Reader
+}
+
+type DocumentsUploadRequest struct {
+	Files []UploadFile
+	// URL and Title override the URL and title of a single file, which
+	// otherwise default to the file name and the title found in the file.
+	URL   string
+	Title string
+}
+
+// DocumentsUpload uploads files, whose text is extracted by the server. Files
+// that fail have an Error set in their result, rather than failing the whole
+// request.
+func (c Client) DocumentsUpload(ctx context.Context, req DocumentsUploadRequest) (resp models.DocumentsUploadPostResponse, err error) {
+	url, err := jsonapi.URL(c.baseURL).Path("documents:upload").Query(c.collectionQuery()).String()
+	if err != nil {
+		return resp, err
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, f := range req.Files {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "file", "filename": f.Name}))
+		h.Set("Content-Type", cmp.Or(f.ContentType, "application/octet-stream"))
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return resp, fmt.Errorf("failed to create form part: %w", err)
+		}
+		if _, err = io.Copy(part, f.Content); err != nil {
+			return resp, fmt.Errorf("failed to read file %q: %w", f.Name, err)
+		}
+	}
+	for name, value := range map[string]string{"url": req.URL, "title": req.Title} {
+		if value == "" {
+			continue
+		}
+		if err = mw.WriteField(name, value); err != nil {
+			return resp, fmt.Errorf("failed to write form field: %w", err)
+		}
+	}
+	if err = mw.Close(); err != nil {
+		return resp, fmt.Errorf("failed to write form: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &body)
+	if err != nil {
+		return resp, fmt.Errorf("failed to create request: %w", err)
+	}
+	res, err := jsonapi.Raw(httpReq,
+		jsonapi.WithRequestHeader("Authorization", c.apiKey),
+		jsonapi.WithRequestHeader("Content-Type", mw.FormDataContentType()))
+	if err != nil {
+		return resp, fmt.Errorf("failed to perform HTTP request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(res.Body)
+		return resp, jsonapi.InvalidStatusError{
+			Status: res.StatusCode,
+			Body:   string(body),
+		}
+	}
+	if err = json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("failed to decode response body: %w", err)
+	}
+	return resp, nil
+}
+
+func (c Client) DocumentsGet(ctx context.Context, req models.DocumentsGetRequest) (resp models.DocumentsGetResponse, err error) {
+	query := map[string]string{}
+	if c.collection != "" {
+		query["collection"] = c.collection
+	}
+	if req.Prefix != "" {
+		query["prefix"] = req.Prefix
+	}
+	if !req.UpdatedSince.IsZero() {
+		query["updated_since"] = req.UpdatedSince.Format(time.RFC3339)
+	}
+	if req.Cursor != "" {
+		query["cursor"] = req.Cursor
+	}
+	if req.Limit > 0 {
+		query["limit"] = strconv.Itoa(req.Limit)
+	}
+	url, err := jsonapi.URL(c.baseURL).Path("documents").Query(query).String()
+	if err != nil {
+		return resp, err
+	}
+	resp, _, err = jsonapi.Get[models.DocumentsGetResponse](ctx, url, jsonapi.WithRequestHeader("Authorization", c.apiKey))
+	return resp, err
+}
+
+func (c Client) DocumentGet(ctx context.Context, documentURL string) (resp models.DocumentGetResponse, ok bool, err error) {
+	url, err := c.documentURL(documentURL)
+	if err != nil {
+		return resp, false, err
+	}
+	return jsonapi.Get[models.DocumentGetResponse](ctx, url, jsonapi.WithRequestHeader("Authorization", c.apiKey))
+}
+
+// DocumentDelete deletes a document. Returns ok=false if the document was not found.
+func (c Client) DocumentDelete(ctx context.Context, documentURL string) (ok bool, err error) {
+	url, err := c.documentURL(documentURL)
+	if err != nil {
+		return false, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	res, err := jsonapi.Raw(httpReq, jsonapi.WithRequestHeader("Authorization", c.apiKey))
+	if err != nil {
+		return false, fmt.Errorf("failed to perform HTTP request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(res.Body)
+		return false, jsonapi.InvalidStatusError{
+			Status: res.StatusCode,
+			Body:   string(body),
+		}
+	}
+	return true, nil
+}
+
+func (c Client) DocumentRevisionsGet(ctx context.Context, documentURL string) (resp models.DocumentRevisionsGetResponse, ok bool, err error) {
+	url, err := c.documentURL(documentURL, "revisions")
+	if err != nil {
+		return resp, false, err
+	}
+	return jsonapi.Get[models.DocumentRevisionsGetResponse](ctx, url, jsonapi.WithRequestHeader("Authorization", c.apiKey))
+}
+
+func (c Client) DocumentRevisionGet(ctx context.Context, documentURL string, revision int) (resp models.DocumentRevisionGetResponse, ok bool, err error) {
+	url, err := c.documentURL(documentURL, "revisions", strconv.Itoa(revision))
+	if err != nil {
+		return resp, false, err
+	}
+	return jsonapi.Get[models.DocumentRevisionGetResponse](ctx, url, jsonapi.WithRequestHeader("Authorization", c.apiKey))
+}
+
+// DocumentRevisionDiff returns the diff between two revisions. If to is zero,
+// the diff is against the latest revision.
+func (c Client) DocumentRevisionDiff(ctx context.Context, documentURL string, from, to int) (resp models.DocumentRevisionDiffResponse, ok bool, err error) {
+	query := url.Values{}
+	if to > 0 {
+		query.Set("to", strconv.Itoa(to))
+	}
+	url, err := c.documentURLWithQuery(documentURL, query, "revisions", strconv.Itoa(from), "diff")
+	if err != nil {
+		return resp, false, err
+	}
+	return jsonapi.Get[models.DocumentRevisionDiffResponse](ctx, url, jsonapi.WithRequestHeader("Authorization", c.apiKey))
+}
+
+func (c Client) DocumentRevisionRestore(ctx context.Context, documentURL string, revision int) (resp models.DocumentRevisionRestoreResponse, err error) {
+	url, err := c.documentURL(documentURL, "revisions", strconv.Itoa(revision)+":restore")
+	if err != nil {
+		return resp, err
+	}
+	return jsonapi.Post[any, models.DocumentRevisionRestoreResponse](ctx, url, nil, jsonapi.WithRequestHeader("Authorization", c.apiKey))
+}
+
+// documentURL returns the URL of a document resource. The document URL is
+// escaped into a single path segment, followed by any additional segments.
+func (c Client) documentURL(documentURL string, segments ...string) (string, error) {
+	return c.documentURLWithQuery(documentURL, url.Values{}, segments...)
+}
+
+func (c Client) documentURLWithQuery(documentURL string, query url.Values, segments ...string) (string, error) {
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse baseURL: %w", err)
+	}
+	u = u.JoinPath("documents")
+	rawPath := u.EscapedPath() + "/" + url.PathEscape(documentURL)
+	u.Path += "/" + documentURL
+	for _, segment := range segments {
+		rawPath += "/" + url.PathEscape(segment)
+		u.Path += "/" + segment
+	}
+	u.RawPath = rawPath
+	if c.collection != "" {
+		query.Set("collection", c.collection)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
 func (c Client) ContextPost(ctx context.Context, req models.ContextPostRequest) (resp models.ContextPostResponse, err error) {
 	url, err := jsonapi.URL(c.baseURL).Path("context").String()
 	if err != nil {
@@ -40,6 +272,14 @@ func (c Client) ContextPost(ctx context.Context, req models.ContextPostRequest)
 	return jsonapi.Post[models.ContextPostRequest, models.ContextPostResponse](ctx, url, req, jsonapi.WithRequestHeader("Authorization", c.apiKey))
 }
 
+func (c Client) SearchPost(ctx context.Context, req models.SearchPostRequest) (resp models.SearchPostResponse, err error) {
+	url, err := jsonapi.URL(c.baseURL).Path("search").String()
+	if err != nil {
+		return resp, err
+	}
+	return jsonapi.Post[models.SearchPostRequest, models.SearchPostResponse](ctx, url, req, jsonapi.WithRequestHeader("Authorization", c.apiKey))
+}
+
 func (c Client) ChatPost(ctx context.Context, request models.ChatPostRequest, f func(ctx context.Context, chunk []byte) error) (err error) {
 	url, err := jsonapi.URL(c.baseURL).Path("chat").String()
 	if err != nil {
@@ -48,6 +288,9 @@ func (c Client) ChatPost(ctx context.Context, request models.ChatPostRequest, f
 	return c.postStream(ctx, url, request, f)
 }
 
+// ErrNoRelevantDocuments is returned by QueryPost when a strict query finds no relevant context.
+var ErrNoRelevantDocuments = errors.New("no relevant documents found")
+
 func (c Client) QueryPost(ctx context.Context, request models.QueryPostRequest, f func(ctx context.Context, chunk []byte) error) (err error) {
 	url, err := jsonapi.URL(c.baseURL).Path("query").String()
 	if err != nil {
@@ -56,24 +299,60 @@ func (c Client) QueryPost(ctx context.Context, request models.QueryPostRequest,
 	return c.postStream(ctx, url, request, f)
 }
 
-func (c Client) postStream(ctx context.Context, url string, req any, f func(ctx context.Context, chunk []byte) error) (err error) {
-	buf, err := json.Marshal(req)
+// StreamError is returned by QueryPostWithSources when the server fails after
+// it has started to stream the answer.
+type StreamError struct {
+	Message string
+}
+
+func (e StreamError) Error() string {
+	return fmt.Sprintf("answer failed: %s", e.Message)
+}
+
+// QueryPostWithSources streams the answer to f, like QueryPost, but first
+// passes the sources that the answer can cite, e.g. [1], to sources. If the
+// server fails part way through the answer, a StreamError is returned.
+func (c Client) QueryPostWithSources(ctx context.Context, request models.QueryPostRequest, sources func(ctx context.Context, sources []models.QuerySource) error, f func(ctx context.Context, chunk []byte) error) (err error) {
+	url, err := jsonapi.URL(c.baseURL).Path("query").String()
 	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
+		return err
 	}
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
-	res, err := jsonapi.Raw(httpReq, jsonapi.WithRequestHeader("Authorization", c.apiKey))
+	request.Sources = true
+	res, err := c.postStreamResponse(ctx, url, request)
 	if err != nil {
-		return fmt.Errorf("failed to perform HTTP request: %w", err)
+		return err
 	}
 	defer res.Body.Close()
-	if res.StatusCode < 200 || res.StatusCode > 299 {
-		body, _ := io.ReadAll(res.Body)
-		return jsonapi.InvalidStatusError{
-			Status: res.StatusCode,
-			Body:   string(body),
+	dec := json.NewDecoder(res.Body)
+	for first := true; ; first = false {
+		var e models.QueryPostEvent
+		if err = dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		if e.Error != "" {
+			return StreamError{Message: e.Error}
+		}
+		if first {
+			if err = sources(ctx, e.Sources); err != nil {
+				return fmt.Errorf("failed to process sources: %w", err)
+			}
+			continue
+		}
+		if err = f(ctx, []byte(e.Text)); err != nil {
+			return fmt.Errorf("failed to process chunk: %w", err)
 		}
 	}
+}
+
+func (c Client) postStream(ctx context.Context, url string, req any, f func(ctx context.Context, chunk []byte) error) (err error) {
+	res, err := c.postStreamResponse(ctx, url, req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
 	for {
 		chunk := make([]byte, 1024)
 		n, err := res.Body.Read(chunk)
@@ -89,3 +368,41 @@ func (c Client) postStream(ctx context.Context, url string, req any, f func(ctx
 	}
 	return nil
 }
+
+// postStreamResponse posts the request, and returns the response if it's a
+// stream. The caller must close the response body.
+func (c Client) postStreamResponse(ctx context.Context, url string, req any) (res *http.Response, err error) {
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	res, err = jsonapi.Raw(httpReq, jsonapi.WithRequestHeader("Authorization", c.apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform HTTP request: %w", err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return nil, jsonapi.InvalidStatusError{
+			Status: res.StatusCode,
+			Body:   string(body),
+		}
+	}
+	// Answers are streamed, so JSON is a structured response instead of an answer.
+	if mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); mediaType == "application/json" {
+		defer res.Body.Close()
+		var nrd models.QueryPostNoRelevantDocumentsResponse
+		if err = json.NewDecoder(res.Body).Decode(&nrd); err != nil {
+			return nil, fmt.Errorf("failed to decode response body: %w", err)
+		}
+		if nrd.NoRelevantDocuments {
+			return nil, ErrNoRelevantDocuments
+		}
+		return nil, fmt.Errorf("unexpected JSON response: %q", nrd.Message)
+	}
+	return res, nil
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/a-h/ragserver/models"
+)
+
+func TestDocumentURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		baseURL     string
+		documentURL string
+		segments    []string
+		expected    string
+	}{
+		{
+			name:        "slashes in the document URL are escaped",
+			baseURL:     "http://localhost:9020",
+			documentURL: "https://example.com/article 1",
+			expected:    "http://localhost:9020/documents/https:%2F%2Fexample.com%2Farticle%201",
+		},
+		{
+			name:        "base URLs with paths are supported",
+			baseURL:     "http://localhost:9020/api/",
+			documentURL: "entities/abc",
+			expected:    "http://localhost:9020/api/documents/entities%2Fabc",
+		},
+		{
+			name:        "additional segments are appended",
+			baseURL:     "http://localhost:9020",
+			documentURL: "entities/abc",
+			segments:    []string{"revisions", "2"},
+			expected:    "http://localhost:9020/documents/entities%2Fabc/revisions/2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := New(test.baseURL, "")
+			actual, err := c.documentURL(test.documentURL, test.segments...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestQueryPostWithSources(t *testing.T) {
+	newServer := func(lines ...string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			for _, line := range lines {
+				io.WriteString(w, line+"\n")
+			}
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	query := func(server *httptest.Server) (sources []models.QuerySource, text string, err error) {
+		err = New(server.URL, "key").QueryPostWithSources(context.Background(), models.QueryPostRequest{Text: "query"},
+			func(ctx context.Context, s []models.QuerySource) error {
+				sources = s
+				return nil
+			},
+			func(ctx context.Context, chunk []byte) error {
+				text += string(chunk)
+				return nil
+			})
+		return sources, text, err
+	}
+
+	t.Run("sources and the answer are passed to the callbacks", func(t *testing.T) {
+		server := newServer(
+			`{"sources":[{"number":1,"url":"https://example.com"}]}`,
+			`{"text":"It sends "}`,
+			`{"text":"invoices [1]."}`,
+		)
+		sources, text, err := query(server)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(sources) != 1 || sources[0].URL != "https://example.com" {
+			t.Errorf("unexpected sources: %+v", sources)
+		}
+		if text != "It sends invoices [1]." {
+			t.Errorf("unexpected text: %q", text)
+		}
+	})
+	t.Run("error events are returned as a StreamError", func(t *testing.T) {
+		server := newServer(
+			`{"sources":[{"number":1,"url":"https://example.com"}]}`,
+			`{"text":"It sends "}`,
+			`{"error":"failed to generate content"}`,
+		)
+		_, text, err := query(server)
+		var streamErr StreamError
+		if !errors.As(err, &streamErr) {
+			t.Fatalf("expected a StreamError, got %v", err)
+		}
+		if streamErr.Message != "failed to generate content" {
+			t.Errorf("unexpected message: %q", streamErr.Message)
+		}
+		if text != "It sends " {
+			t.Errorf("expected the partial answer to be passed to the callback, got %q", text)
+		}
+	})
+}
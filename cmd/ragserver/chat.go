@@ -24,10 +24,15 @@ Answer the questions using information from the context you have been given.
 `
 
 type ChatCommand struct {
-	RAGServerURL     string `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
-	RAGServerAPIKey  string `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
-	SystemPromptFile string `help:"The system prompt to use." env:"SYSTEM_PROMPT" default:""`
-	LogLevel         string `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
+	RAGServerURL     string   `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
+	RAGServerAPIKey  string   `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
+	Collections      []string `help:"The collections to search, e.g. personal,team. Defaults to your personal collection."`
+	SystemPromptFile string   `help:"The system prompt to use." env:"SYSTEM_PROMPT" default:""`
+	NoContext        bool     `help:"Chat with the LLM directly, without retrieving context from your documents." env:"NO_CONTEXT" default:"false"`
+	Mode             string   `help:"The retrieval mode to use." enum:"vector,keyword,hybrid" default:"vector"`
+	Filter           string   `help:"A metadata filter expression, e.g. 'source = \"runbooks\"'."`
+	Transform        string   `help:"Rewrite the question before retrieval. multi-query retrieves with paraphrases of the question, hyde retrieves with a hypothetical answer." enum:",multi-query,hyde" default:""`
+	LogLevel         string   `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
 }
 
 func (c ChatCommand) Run(ctx context.Context) (err error) {
@@ -43,6 +48,14 @@ func (c ChatCommand) Run(ctx context.Context) (err error) {
 	}
 
 	var req models.ChatPostRequest
+	if !c.NoContext {
+		req.RAG = &models.ChatRAG{
+			Mode:        models.RetrievalMode(c.Mode),
+			Filter:      c.Filter,
+			Transform:   models.QueryTransform(c.Transform),
+			Collections: c.Collections,
+		}
+	}
 	req.Messages = append(req.Messages, models.ChatMessage{
 		Type:    models.ChatMessageTypeSystem,
 		Content: systemPrompt,
@@ -4,24 +4,60 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"time"
 
 	"github.com/a-h/ragserver/client"
 	"github.com/a-h/ragserver/models"
 )
 
 type ContextCommand struct {
-	RAGServerURL    string `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
-	RAGServerAPIKey string `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
-	Text            string `help:"The text to send."`
-	Pretty          bool   `help:"Pretty print the JSON output." default:"true"`
-	LogLevel        string `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
+	RAGServerURL        string    `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
+	RAGServerAPIKey     string    `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
+	Collections         []string  `help:"The collections to search, e.g. personal,team. Defaults to your personal collection."`
+	Text                string    `help:"The text to send."`
+	Mode                string    `help:"The retrieval mode to use." enum:"vector,keyword,hybrid" default:"vector"`
+	Filter              string    `help:"A metadata filter expression, e.g. 'source = \"runbooks\"'."`
+	MMR                 bool      `help:"Select diverse chunks with maximal marginal relevance."`
+	MMRLambda           float64   `help:"The MMR trade-off between relevance (1) and diversity (0)." default:"0.5"`
+	MaxChunksPerURL     int       `help:"The maximum number of chunks to use from each document, 0 for no limit." default:"0"`
+	Expand              int       `help:"The number of neighbouring chunks to add before and after each chunk." default:"0"`
+	MaxDistance         *float64  `help:"Drop chunks further than this from the text, overriding the server default. 0 disables the threshold."`
+	Transform           string    `help:"Rewrite the text before retrieval. multi-query retrieves with paraphrases of the text, hyde retrieves with a hypothetical answer." enum:",multi-query,hyde" default:""`
+	Since               time.Time `help:"Only use documents dated on or after this date, e.g. 2026-01-01." format:"2006-01-02"`
+	Until               time.Time `help:"Only use documents dated on or before this date, e.g. 2026-12-31." format:"2006-01-02"`
+	RecencyHalfLifeDays float64   `help:"Favour recent documents. A document's recency halves every this many days, 0 to ignore recency." default:"0"`
+	RecencyWeight       float64   `help:"The share of the score given to recency, between 0 and 1." default:"0.5"`
+	Pretty              bool      `help:"Pretty print the JSON output." default:"true"`
+	LogLevel            string    `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
 }
 
 func (c ContextCommand) Run(ctx context.Context) (err error) {
 	rsc := client.New(c.RAGServerURL, c.RAGServerAPIKey)
-	resp, err := rsc.ContextPost(ctx, models.ContextPostRequest{
-		Text: c.Text,
-	})
+	req := models.ContextPostRequest{
+		Text:            c.Text,
+		Mode:            models.RetrievalMode(c.Mode),
+		Filter:          c.Filter,
+		MaxChunksPerURL: c.MaxChunksPerURL,
+		MaxDistance:     c.MaxDistance,
+		Expand:          c.Expand,
+		Collections:     c.Collections,
+		Transform:       models.QueryTransform(c.Transform),
+	}
+	if c.MMR {
+		req.MMR = &models.MMR{Lambda: c.MMRLambda}
+	}
+	if !c.Since.IsZero() {
+		req.Since = &c.Since
+	}
+	if !c.Until.IsZero() {
+		// Include the whole day.
+		until := c.Until.Add(24*time.Hour - time.Nanosecond)
+		req.Until = &until
+	}
+	if c.RecencyHalfLifeDays > 0 {
+		req.Recency = &models.Recency{HalfLifeDays: c.RecencyHalfLifeDays, Weight: c.RecencyWeight}
+	}
+	resp, err := rsc.ContextPost(ctx, req)
 
 	enc := json.NewEncoder(os.Stdout)
 	if c.Pretty {
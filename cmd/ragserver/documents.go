@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/a-h/ragserver/client"
+	"github.com/a-h/ragserver/models"
+)
+
+type DocumentsCommand struct {
+	List      DocumentsListCommand      `cmd:"list" help:"List documents."`
+	Get       DocumentsGetCommand       `cmd:"get" help:"Get a document."`
+	Delete    DocumentsDeleteCommand    `cmd:"delete" help:"Delete a document."`
+	Upload    DocumentsUploadCommand    `cmd:"upload" help:"Upload PDF, HTML, CSV, markdown or text files as documents."`
+	Revisions DocumentsRevisionsCommand `cmd:"revisions" help:"List the revisions of a document."`
+	Diff      DocumentsDiffCommand      `cmd:"diff" help:"Show the difference between two revisions of a document."`
+	Restore   DocumentsRestoreCommand   `cmd:"restore" help:"Restore a previous revision of a document."`
+}
+
+type DocumentsListCommand struct {
+	RAGServerURL    string    `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
+	RAGServerAPIKey string    `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
+	Collection      string    `help:"The shared collection to use, instead of your personal collection." env:"RAG_SERVER_COLLECTION" default:""`
+	Prefix          string    `help:"Only list documents with URLs that start with the prefix."`
+	UpdatedSince    time.Time `help:"Only list documents updated since the time, in RFC3339 format."`
+	LogLevel        string    `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
+}
+
+func (c DocumentsListCommand) Run(ctx context.Context) (err error) {
+	rsc := client.New(c.RAGServerURL, c.RAGServerAPIKey).WithCollection(c.Collection)
+	enc := json.NewEncoder(os.Stdout)
+	req := models.DocumentsGetRequest{
+		Prefix:       c.Prefix,
+		UpdatedSince: c.UpdatedSince,
+	}
+	for {
+		resp, err := rsc.DocumentsGet(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to list documents: %w", err)
+		}
+		for _, doc := range resp.Documents {
+			if err = enc.Encode(doc); err != nil {
+				return err
+			}
+		}
+		if resp.Next == "" {
+			return nil
+		}
+		req.Cursor = resp.Next
+	}
+}
+
+type DocumentsGetCommand struct {
+	RAGServerURL    string `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
+	RAGServerAPIKey string `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
+	Collection      string `help:"The shared collection to use, instead of your personal collection." env:"RAG_SERVER_COLLECTION" default:""`
+	URL             string `arg:"" help:"The URL of the document."`
+	Pretty          bool   `help:"Pretty print the JSON output." default:"true"`
+	LogLevel        string `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
+}
+
+func (c DocumentsGetCommand) Run(ctx context.Context) (err error) {
+	rsc := client.New(c.RAGServerURL, c.RAGServerAPIKey).WithCollection(c.Collection)
+	resp, ok, err := rsc.DocumentGet(ctx, c.URL)
+	if err != nil {
+		return fmt.Errorf("failed to get document: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("document %q not found", c.URL)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	if c.Pretty {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(resp)
+}
+
+type DocumentsDeleteCommand struct {
+	RAGServerURL    string `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
+	RAGServerAPIKey string `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
+	Collection      string `help:"The shared collection to use, instead of your personal collection." env:"RAG_SERVER_COLLECTION" default:""`
+	URL             string `arg:"" help:"The URL of the document."`
+	LogLevel        string `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
+}
+
+func (c DocumentsDeleteCommand) Run(ctx context.Context) (err error) {
+	log := getLogger(c.LogLevel)
+	rsc := client.New(c.RAGServerURL, c.RAGServerAPIKey).WithCollection(c.Collection)
+	ok, err := rsc.DocumentDelete(ctx, c.URL)
+	if err != nil {
+		return fmt.Errorf("failed to delete document: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("document %q not found", c.URL)
+	}
+	log.Info("document deleted", slog.String("url", c.URL))
+	return nil
+}
+
+type DocumentsUploadCommand struct {
+	RAGServerURL    string   `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
+	RAGServerAPIKey string   `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
+	Collection      string   `help:"The shared collection to use, instead of your personal collection." env:"RAG_SERVER_COLLECTION" default:""`
+	Files           []string `arg:"" help:"The files to upload." type:"existingfile"`
+	URL             string   `help:"The URL of the document, if a single file is uploaded. Defaults to the file name."`
+	Title           string   `help:"The title of the document, if a single file is uploaded. Defaults to the title found in the file, or the file name."`
+	LogLevel        string   `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
+}
+
+func (c DocumentsUploadCommand) Run(ctx context.Context) (err error) {
+	log := getLogger(c.LogLevel)
+	rsc := client.New(c.RAGServerURL, c.RAGServerAPIKey).WithCollection(c.Collection)
+	req := client.DocumentsUploadRequest{
+		URL:   c.URL,
+		Title: c.Title,
+	}
+	for _, name := range c.Files {
+		f, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("failed to open file: %w", err)
+		}
+		defer f.Close()
+		req.Files = append(req.Files, client.UploadFile{
+			Name:    filepath.Base(name),
+			Content: f,
+		})
+	}
+	resp, err := rsc.DocumentsUpload(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to upload documents: %w", err)
+	}
+	var failed int
+	for _, result := range resp.Results {
+		if result.Error != "" {
+			log.Error("failed to upload file", slog.String("fileName", result.FileName), slog.String("error", result.Error))
+			failed++
+			continue
+		}
+		log.Info("document uploaded", slog.String("fileName", result.FileName), slog.String("url", result.URL), slog.String("title", result.Title), slog.String("status", string(result.Status)))
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to upload %d of %d files", failed, len(resp.Results))
+	}
+	return nil
+}
+
+type DocumentsRevisionsCommand struct {
+	RAGServerURL    string `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
+	RAGServerAPIKey string `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
+	Collection      string `help:"The shared collection to use, instead of your personal collection." env:"RAG_SERVER_COLLECTION" default:""`
+	URL             string `arg:"" help:"The URL of the document."`
+	LogLevel        string `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
+}
+
+func (c DocumentsRevisionsCommand) Run(ctx context.Context) (err error) {
+	rsc := client.New(c.RAGServerURL, c.RAGServerAPIKey).WithCollection(c.Collection)
+	resp, ok, err := rsc.DocumentRevisionsGet(ctx, c.URL)
+	if err != nil {
+		return fmt.Errorf("failed to list revisions: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("document %q not found", c.URL)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, rev := range resp.Revisions {
+		if err = enc.Encode(rev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type DocumentsDiffCommand struct {
+	RAGServerURL    string `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
+	RAGServerAPIKey string `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
+	Collection      string `help:"The shared collection to use, instead of your personal collection." env:"RAG_SERVER_COLLECTION" default:""`
+	URL             string `arg:"" help:"The URL of the document."`
+	From            int    `arg:"" help:"The revision to compare from."`
+	To              int    `help:"The revision to compare to, defaults to the latest revision." default:"0"`
+	LogLevel        string `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
+}
+
+func (c DocumentsDiffCommand) Run(ctx context.Context) (err error) {
+	rsc := client.New(c.RAGServerURL, c.RAGServerAPIKey).WithCollection(c.Collection)
+	resp, ok, err := rsc.DocumentRevisionDiff(ctx, c.URL, c.From, c.To)
+	if err != nil {
+		return fmt.Errorf("failed to diff revisions: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("revision of document %q not found", c.URL)
+	}
+	_, err = os.Stdout.WriteString(resp.Diff)
+	return err
+}
+
+type DocumentsRestoreCommand struct {
+	RAGServerURL    string `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
+	RAGServerAPIKey string `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
+	Collection      string `help:"The shared collection to use, instead of your personal collection." env:"RAG_SERVER_COLLECTION" default:""`
+	URL             string `arg:"" help:"The URL of the document."`
+	Revision        int    `arg:"" help:"The revision to restore."`
+	LogLevel        string `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
+}
+
+func (c DocumentsRestoreCommand) Run(ctx context.Context) (err error) {
+	log := getLogger(c.LogLevel)
+	rsc := client.New(c.RAGServerURL, c.RAGServerAPIKey).WithCollection(c.Collection)
+	resp, err := rsc.DocumentRevisionRestore(ctx, c.URL, c.Revision)
+	if err != nil {
+		return fmt.Errorf("failed to restore revision: %w", err)
+	}
+	log.Info("revision restored", slog.String("url", c.URL), slog.Int("revision", resp.Restored), slog.Int64("id", resp.ID))
+	return nil
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"iter"
@@ -11,32 +13,82 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/a-h/jsonapi"
 	"github.com/a-h/ragserver/client"
+	"github.com/a-h/ragserver/loaders"
 	"github.com/a-h/ragserver/models"
 	"github.com/pluja/pocketbase"
-	"github.com/tmc/langchaingo/documentloaders"
 	"gopkg.in/yaml.v3"
 )
 
 type ImportCommand struct {
-	RAGServerURL    string `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
-	RAGServerAPIKey string `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
-	PocketbaseURL   string `help:"The URL of the Pocketbase server." env:"POCKETBASE_URL" default:"http://localhost:8080"`
-	ID              string `help:"The ID of the document to import if you just want to import a single doc." env:"ID" default:""`
-	Collection      string `help:"The name of the collection to export from." env:"COLLECTION" default:"entities"`
-	Expand          string `help:"The fields to expand." env:"EXPAND" default:""`
-	Files           string `help:"Comma separated list of fields that contain Pocketbase file references." env:"FILES" default:""`
-	DryRun          bool   `help:"Do not actually import the documents." env:"DRY_RUN" default:"false"`
-	LogLevel        string `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
+	RAGServerURL        string `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
+	RAGServerAPIKey     string `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
+	RAGServerCollection string `help:"The shared collection on the RAG server to import into, instead of your personal collection." env:"RAG_SERVER_COLLECTION" default:""`
+	PocketbaseURL       string `help:"The URL of the Pocketbase server." env:"POCKETBASE_URL" default:"http://localhost:8080"`
+	ID                  string `help:"The ID of the document to import if you just want to import a single doc." env:"ID" default:""`
+	Collection          string `help:"The name of the collection to export from." env:"COLLECTION" default:"entities"`
+	Expand              string `help:"The fields to expand." env:"EXPAND" default:""`
+	Files               string `help:"Comma separated list of fields that contain Pocketbase file references." env:"FILES" default:""`
+	DryRun              bool   `help:"Do not actually import the documents." env:"DRY_RUN" default:"false"`
+	CheckpointFile      string `help:"A file that stores the latest update time of the imported records. If set, only records updated since the checkpoint are imported. Changes to expanded records aren't detected, because they don't update the record that expands them." env:"CHECKPOINT_FILE" default:""`
+	DeleteMissing       bool   `help:"Delete documents with URLs under the collection's URL prefix whose records no longer exist. Documents of records with their own url field aren't under the prefix, so they're only deleted while watching. When watching, documents are only checked when the watcher first connects." env:"DELETE_MISSING" negatable:"" default:"false"`
+	Watch               bool   `help:"Keep running, and import changes to records as they happen, using Pocketbase's realtime API. Deleted records are only removed while watching, or by --delete-missing." env:"WATCH" default:"false"`
+	BatchSize           int    `help:"The number of documents to send to the RAG server in each request." env:"BATCH_SIZE" default:"50"`
+	LogLevel            string `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
 }
 
 func (c ImportCommand) Run(ctx context.Context) (err error) {
 	log := getLogger(c.LogLevel)
 
-	rsc := client.New(c.RAGServerURL, c.RAGServerAPIKey)
+	rsc := client.New(c.RAGServerURL, c.RAGServerAPIKey).WithCollection(c.RAGServerCollection)
+
+	if c.BatchSize < 1 || c.BatchSize > models.DocumentsBatchMaxDocuments {
+		return fmt.Errorf("batch size must be between 1 and %d", models.DocumentsBatchMaxDocuments)
+	}
 
 	pbe := NewPocketbaseExporter(c.PocketbaseURL, pocketbase.NewClient(c.PocketbaseURL), c.Collection, c.Expand, c.Files)
+	var cp checkpoint
+	if c.CheckpointFile != "" {
+		if cp, err = loadCheckpoint(c.CheckpointFile, c.PocketbaseURL, c.Collection); err != nil {
+			return err
+		}
+		log.Info("importing records updated since checkpoint", slog.Time("updated", cp.Updated))
+	}
+	if !c.Watch {
+		return c.importRecords(ctx, log, rsc, pbe, &cp, c.DeleteMissing)
+	}
+
+	// Changes made while the watcher is disconnected aren't sent, so import
+	// the records updated since the checkpoint each time it connects. Listing
+	// every record to find deleted ones is slow, so it's only done until the
+	// first catch up succeeds, and delete events are relied on after that.
+	deleteMissing := c.DeleteMissing
+	w := NewPocketbaseWatcher(log, http.DefaultClient, c.PocketbaseURL, c.Collection)
+	return w.Watch(ctx, func(ctx context.Context) error {
+		if err := c.importRecords(ctx, log, rsc, pbe, &cp, deleteMissing); err != nil {
+			return err
+		}
+		deleteMissing = false
+		return nil
+	}, func(ctx context.Context, event PocketbaseEvent) error {
+		return c.importEvent(ctx, log, rsc, pbe, &cp, event)
+	})
+}
+
+// importRecords imports the records updated since the checkpoint, and moves
+// the checkpoint to the latest update time of the records if all of them were
+// imported. The checkpoint only uses Pocketbase's update times, so that it
+// isn't affected by differences between the clocks of the two machines.
+// If deleteMissing is set, the documents of records that no longer exist are
+// deleted.
+func (c ImportCommand) importRecords(ctx context.Context, log *slog.Logger, rsc client.Client, pbe *PocketbaseExporter, cp *checkpoint, deleteMissing bool) (err error) {
+	putter := newBatchPutter(log, rsc, c.BatchSize)
+	pbe.Error = nil
+	pbe.UpdatedSince = cp.Updated
+	pbe.LastUpdated = time.Time{}
 	for doc := range pbe.Export(ctx) {
 		if c.ID != "" && doc.ID != c.ID {
 			continue
@@ -49,15 +101,273 @@ func (c ImportCommand) Run(ctx context.Context) (err error) {
 			log.Info("skipping document import in dry run mode", slog.String("url", doc.Document.URL))
 			continue
 		}
-		resp, err := rsc.DocumentsPut(ctx, models.DocumentsPostRequest{
-			Document: doc.Document,
-		})
+		if err = putter.Put(ctx, doc.Document); err != nil {
+			return err
+		}
+	}
+	if pbe.Error != nil {
+		return pbe.Error
+	}
+	if err = putter.Close(ctx); err != nil {
+		return err
+	}
+	if deleteMissing {
+		urls, err := pbe.URLs(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list records: %w", err)
+		}
+		if err = deleteMissingDocuments(ctx, log, rsc, pbe.URLPrefix(), urls, c.DryRun); err != nil {
+			return err
+		}
+	}
+	return c.saveCheckpoint(log, cp, pbe.LastUpdated)
+}
+
+// importEvent puts or deletes the document of a record that changed. If it
+// fails, the watcher reconnects, and the change is imported when it catches
+// up, because the checkpoint hasn't moved. Documents that the RAG server
+// rejects as invalid are logged and skipped, since retrying them can't succeed.
+func (c ImportCommand) importEvent(ctx context.Context, log *slog.Logger, rsc client.Client, pbe *PocketbaseExporter, cp *checkpoint, event PocketbaseEvent) (err error) {
+	id := useItemOrDefault(event.Record, []string{"id"}, "")
+	if c.ID != "" && id != c.ID {
+		return nil
+	}
+	switch event.Action {
+	case "create", "update":
+		// Events don't include expanded fields, so get the record again.
+		pbe.Error = nil
+		doc, ok, err := pbe.Get(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to get record %q: %w", id, err)
+		}
+		if !ok {
+			log.Info("skipping record that no longer exists", slog.String("id", id))
+			return nil
+		}
+		if pbe.Error != nil {
+			return pbe.Error
+		}
+		log.Info("importing document", slog.String("url", doc.Document.URL), slog.String("action", event.Action))
+		if c.DryRun {
+			log.Info("skipping document import in dry run mode", slog.String("url", doc.Document.URL))
+		} else {
+			resp, err := rsc.DocumentsPut(ctx, models.DocumentsPostRequest{Document: doc.Document})
+			var statusErr jsonapi.InvalidStatusError
+			switch {
+			case errors.As(err, &statusErr) && isClientError(statusErr.Status):
+				log.Error("document rejected, skipping", slog.String("url", doc.Document.URL), slog.Int("statusCode", statusErr.Status), slog.String("error", statusErr.Body))
+			case err != nil:
+				return fmt.Errorf("failed to put document %q: %w", doc.Document.URL, err)
+			default:
+				log.Info("document imported", slog.String("url", doc.Document.URL), slog.Int64("id", resp.ID), slog.String("status", string(resp.Status)))
+			}
+		}
+		updated, err := time.Parse(pocketbaseTimeFormat, useItemOrDefault(event.Record, []string{"updated"}, ""))
 		if err != nil {
-			return fmt.Errorf("failed to put document: %w", err)
+			return nil
+		}
+		return c.saveCheckpoint(log, cp, updated)
+	case "delete":
+		url := pbe.documentURL(event.Record)
+		if c.DryRun {
+			log.Info("skipping document delete in dry run mode", slog.String("url", url))
+			return nil
+		}
+		if _, err = rsc.DocumentDelete(ctx, url); err != nil {
+			return fmt.Errorf("failed to delete document %q: %w", url, err)
 		}
-		log.Info("document imported", slog.String("url", doc.Document.URL), slog.Int64("id", resp.ID))
+		log.Info("document deleted", slog.String("url", url))
+		return nil
+	default:
+		log.Warn("skipping unknown action", slog.String("action", event.Action), slog.String("id", id))
+		return nil
+	}
+}
+
+// saveCheckpoint moves the checkpoint forward to the update time. The file
+// isn't written in dry run mode, or when importing a single record.
+func (c ImportCommand) saveCheckpoint(log *slog.Logger, cp *checkpoint, updated time.Time) (err error) {
+	if !updated.After(cp.Updated) {
+		return nil
+	}
+	cp.Updated = updated
+	if c.CheckpointFile == "" || c.DryRun || c.ID != "" {
+		return nil
+	}
+	if err = cp.Save(c.CheckpointFile); err != nil {
+		return err
+	}
+	log.Info("checkpoint saved", slog.Time("updated", cp.Updated))
+	return nil
+}
+
+// checkpoint records the progress of incremental imports from a collection.
+type checkpoint struct {
+	PocketbaseURL string `json:"pocketbaseURL"`
+	Collection    string `json:"collection"`
+	// Updated is the latest update time of the imported records.
+	Updated time.Time `json:"updated"`
+}
+
+// loadCheckpoint reads the checkpoint file. If it doesn't exist, the returned
+// checkpoint has a zero update time, so that all records are imported.
+func loadCheckpoint(name, pocketbaseURL, collection string) (cp checkpoint, err error) {
+	cp = checkpoint{
+		PocketbaseURL: pocketbaseURL,
+		Collection:    collection,
+	}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	var saved checkpoint
+	if err = json.Unmarshal(data, &saved); err != nil {
+		return cp, fmt.Errorf("failed to decode checkpoint: %w", err)
+	}
+	if saved.PocketbaseURL != pocketbaseURL || saved.Collection != collection {
+		return cp, fmt.Errorf("checkpoint %q is for collection %q of %s", name, saved.Collection, saved.PocketbaseURL)
+	}
+	return saved, nil
+}
+
+// Save writes the checkpoint to a temporary file, and renames it, so that the
+// checkpoint isn't lost if writing fails.
+func (cp checkpoint) Save(name string) (err error) {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err = os.Rename(f.Name(), name); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+func newBatchPutter(log *slog.Logger, rsc client.Client, size int) *batchPutter {
+	return &batchPutter{
+		log:  log,
+		rsc:  rsc,
+		size: size,
+	}
+}
+
+// batchPutter puts documents to the RAG server in batches, and logs the
+// results. Documents that the RAG server rejects as invalid are logged and
+// skipped, since retrying them can't succeed.
+type batchPutter struct {
+	log     *slog.Logger
+	rsc     client.Client
+	size    int
+	batch   []models.Document
+	failed  int
+	skipped int
+}
+
+// Put adds the document to the batch, and puts the batch when it's full.
+func (b *batchPutter) Put(ctx context.Context, doc models.Document) error {
+	b.batch = append(b.batch, doc)
+	if len(b.batch) < b.size {
+		return nil
 	}
-	return pbe.Error
+	return b.flush(ctx)
+}
+
+// Close puts the remaining documents, and returns an error if any documents
+// failed to import for a reason other than being invalid.
+func (b *batchPutter) Close(ctx context.Context) error {
+	if err := b.flush(ctx); err != nil {
+		return err
+	}
+	if b.skipped > 0 {
+		b.log.Warn("invalid documents were skipped", slog.Int("count", b.skipped))
+	}
+	if b.failed > 0 {
+		return fmt.Errorf("%d documents failed to import", b.failed)
+	}
+	return nil
+}
+
+func (b *batchPutter) flush(ctx context.Context) error {
+	if len(b.batch) == 0 {
+		return nil
+	}
+	resp, err := b.rsc.DocumentsBatchPut(ctx, models.DocumentsBatchPostRequest{
+		Documents: b.batch,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put documents: %w", err)
+	}
+	for _, result := range resp.Results {
+		if result.Error != "" && isClientError(result.StatusCode) {
+			b.skipped++
+			b.log.Error("document rejected, skipping", slog.String("url", result.URL), slog.Int("statusCode", result.StatusCode), slog.String("error", result.Error))
+			continue
+		}
+		if result.Error != "" {
+			b.failed++
+			b.log.Error("document import failed", slog.String("url", result.URL), slog.Int("statusCode", result.StatusCode), slog.String("error", result.Error))
+			continue
+		}
+		b.log.Info("document imported", slog.String("url", result.URL), slog.Int64("id", result.ID), slog.String("status", string(result.Status)))
+	}
+	b.batch = b.batch[:0]
+	return nil
+}
+
+// isClientError reports whether the status code is a 4xx status, which means
+// that the request is invalid, and retrying it won't succeed.
+func isClientError(statusCode int) bool {
+	return statusCode >= 400 && statusCode <= 499
+}
+
+// deleteMissingDocuments deletes the documents with URLs that start with the
+// prefix, and weren't found in the source.
+func deleteMissingDocuments(ctx context.Context, log *slog.Logger, rsc client.Client, prefix string, found map[string]bool, dryRun bool) (err error) {
+	var missing []string
+	req := models.DocumentsGetRequest{
+		Prefix: prefix,
+	}
+	for {
+		resp, err := rsc.DocumentsGet(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to list documents: %w", err)
+		}
+		for _, doc := range resp.Documents {
+			if !found[doc.URL] {
+				missing = append(missing, doc.URL)
+			}
+		}
+		if resp.Next == "" {
+			break
+		}
+		req.Cursor = resp.Next
+	}
+	for _, url := range missing {
+		if dryRun {
+			log.Info("skipping document delete in dry run mode", slog.String("url", url))
+			continue
+		}
+		if _, err = rsc.DocumentDelete(ctx, url); err != nil {
+			return fmt.Errorf("failed to delete document %q: %w", url, err)
+		}
+		log.Info("document deleted", slog.String("url", url))
+	}
+	return nil
 }
 
 func NewPocketbaseExporter(baseURL string, client *pocketbase.Client, collection, expand, files string) *PocketbaseExporter {
@@ -80,11 +390,18 @@ type PocketbaseExporter struct {
 	expand     string
 	files      []string
 	PageSize   int
-	Error      error
+	// UpdatedSince limits the export to records updated at or after the time, if set.
+	UpdatedSince time.Time
+	// LastUpdated is the latest update time of the exported records.
+	LastUpdated time.Time
+	Error       error
 }
 
+// pocketbaseTimeFormat is the format of Pocketbase's created and updated fields.
+const pocketbaseTimeFormat = "2006-01-02 15:04:05.000Z07:00"
+
 func (p *PocketbaseExporter) Export(ctx context.Context) iter.Seq[ExportedDocument] {
-	var page int
+	var lastUpdated, lastID string
 	return func(yield func(ExportedDocument) bool) {
 		for {
 			if ctx.Err() != nil {
@@ -93,13 +410,18 @@ func (p *PocketbaseExporter) Export(ctx context.Context) iter.Seq[ExportedDocume
 			if p.Error != nil {
 				return
 			}
-			page++
-			response, err := p.client.List(p.collection, pocketbase.ParamsList{
-				Page:   page,
-				Size:   p.PageSize,
-				Sort:   "-created",
-				Expand: p.expand,
-			})
+			// Records move to the end of the sort order when they're updated,
+			// which would shift the later pages, so each page starts after the
+			// last record of the previous page instead. Records updated during
+			// the export are exported again when they're reached.
+			params := pocketbase.ParamsList{
+				Page:    1,
+				Size:    p.PageSize,
+				Filters: p.updatedFilter(lastUpdated, lastID),
+				Sort:    "updated,id",
+				Expand:  p.expand,
+			}
+			response, err := p.client.List(p.collection, params)
 			if err != nil {
 				p.Error = err
 				return
@@ -108,14 +430,88 @@ func (p *PocketbaseExporter) Export(ctx context.Context) iter.Seq[ExportedDocume
 				return
 			}
 			for _, item := range response.Items {
+				lastUpdated = useItemOrDefault(item, []string{"updated"}, "")
+				lastID = useItemOrDefault(item, []string{"id"}, "")
+				if updated, err := time.Parse(pocketbaseTimeFormat, lastUpdated); err == nil && updated.After(p.LastUpdated) {
+					p.LastUpdated = updated
+				}
 				if !yield(p.createDocument(ctx, item)) {
 					return
 				}
 			}
+			if len(response.Items) < p.PageSize {
+				return
+			}
 		}
 	}
 }
 
+// updatedFilter returns a filter for the records updated since UpdatedSince
+// that come after the given record, when sorted by update time and ID.
+func (p *PocketbaseExporter) updatedFilter(afterUpdated, afterID string) string {
+	var conditions []string
+	if !p.UpdatedSince.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("updated >= %q", p.UpdatedSince.UTC().Format(pocketbaseTimeFormat)))
+	}
+	if afterID != "" {
+		conditions = append(conditions, fmt.Sprintf("(updated > %q || (updated = %q && id > %q))", afterUpdated, afterUpdated, afterID))
+	}
+	return strings.Join(conditions, " && ")
+}
+
+// Get returns the document of a record, with its fields expanded, or false if
+// the record doesn't exist.
+func (p *PocketbaseExporter) Get(ctx context.Context, id string) (ed ExportedDocument, ok bool, err error) {
+	response, err := p.client.List(p.collection, pocketbase.ParamsList{
+		Page:    1,
+		Size:    1,
+		Filters: fmt.Sprintf("id = %q", id),
+		Expand:  p.expand,
+	})
+	if err != nil {
+		return ed, false, err
+	}
+	if len(response.Items) == 0 {
+		return ed, false, nil
+	}
+	return p.createDocument(ctx, response.Items[0]), true, nil
+}
+
+// URLs returns the URLs of the documents of all of the records in the collection.
+func (p *PocketbaseExporter) URLs(ctx context.Context) (urls map[string]bool, err error) {
+	urls = map[string]bool{}
+	for page := 1; ; page++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		response, err := p.client.List(p.collection, pocketbase.ParamsList{
+			Page:   page,
+			Size:   500,
+			Sort:   "created",
+			Fields: "id,url",
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range response.Items {
+			urls[p.documentURL(item)] = true
+		}
+		if page >= response.TotalPages {
+			return urls, nil
+		}
+	}
+}
+
+// URLPrefix is the prefix of the URLs of the collection's documents, unless
+// the records have their own url field.
+func (p *PocketbaseExporter) URLPrefix() string {
+	return url.PathEscape(p.collection) + "/"
+}
+
+func (p *PocketbaseExporter) documentURL(item map[string]any) string {
+	return useItemOrDefault(item, []string{"url"}, p.URLPrefix()+url.PathEscape(useItemOrDefault(item, []string{"id"}, "")))
+}
+
 func useItemOrDefault(item map[string]any, keys []string, defaultValue string) string {
 	for _, key := range keys {
 		if value, ok := item[key].(string); ok {
@@ -132,7 +528,7 @@ type ExportedDocument struct {
 
 func (p *PocketbaseExporter) createDocument(ctx context.Context, item map[string]any) (ed ExportedDocument) {
 	ed.ID = item["id"].(string)
-	ed.Document.URL = useItemOrDefault(item, []string{"url"}, fmt.Sprintf("%s/%s", url.PathEscape(p.collection), url.PathEscape(item["id"].(string))))
+	ed.Document.URL = p.documentURL(item)
 	ed.Document.Title = useItemOrDefault(item, []string{"title", "name"}, "Untitled")
 	recursivelyApplyExpandedFields(item)
 	recursivelyRemoveKeys(item, []string{"id", "collectionId", "collectionName", "created", "updated"})
@@ -201,19 +597,7 @@ func (p *PocketbaseExporter) getPDFText(ctx context.Context, collection, id, fil
 	}
 
 	// Read the PDF text.
-	pdf := documentloaders.NewPDF(pdfFile, fileSize)
-	docs, err := pdf.Load(ctx)
-	if err != nil {
-		return "", fmt.Errorf("failed to load PDF: %w", err)
-	}
-
-	// Create the output.
-	var sb strings.Builder
-	for _, doc := range docs {
-		sb.WriteString(doc.PageContent)
-		sb.WriteString("\n")
-	}
-	return sb.String(), nil
+	return loaders.PDF(ctx, pdfFile, fileSize)
 }
 
 func createURL(baseURL string, pathSegments ...string) (string, error) {
@@ -1,6 +1,22 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/a-h/ragserver/client"
+	"github.com/a-h/ragserver/models"
+	"github.com/pluja/pocketbase"
+)
 
 func TestCreateURL(t *testing.T) {
 	tests := []struct {
@@ -55,3 +71,275 @@ func TestCreateURLError(t *testing.T) {
 		t.Errorf("expected error, got nil, %q", actual)
 	}
 }
+
+func newTestPocketbase(t *testing.T, items []map[string]any, params *[]map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/collections/entities/records" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		*params = append(*params, map[string]string{"filter": q.Get("filter"), "sort": q.Get("sort"), "fields": q.Get("fields"), "page": q.Get("page")})
+		resp := pocketbase.ResponseList[map[string]any]{Page: 1, TotalPages: 1}
+		if q.Get("page") == "1" {
+			resp.Items = items
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPocketbaseExporterIncremental(t *testing.T) {
+	items := []map[string]any{
+		{"id": "a", "title": "A", "updated": "2024-03-01 10:00:00.000Z"},
+		{"id": "b", "title": "B", "updated": "2024-03-02 09:30:15.250Z"},
+		{"id": "c", "url": "https://example.com/c", "title": "C", "updated": "2024-03-01 12:00:00.000Z"},
+	}
+	var params []map[string]string
+	server := newTestPocketbase(t, items, &params)
+
+	pbe := NewPocketbaseExporter(server.URL, pocketbase.NewClient(server.URL), "entities", "", "")
+	pbe.UpdatedSince = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	var urls []string
+	for doc := range pbe.Export(context.Background()) {
+		urls = append(urls, doc.Document.URL)
+	}
+	if pbe.Error != nil {
+		t.Fatalf("unexpected error: %v", pbe.Error)
+	}
+	if expected := []string{"entities/a", "entities/b", "https://example.com/c"}; !slices.Equal(urls, expected) {
+		t.Errorf("expected URLs %v, got %v", expected, urls)
+	}
+	if params[0]["filter"] != `updated >= "2024-03-01 00:00:00.000Z"` {
+		t.Errorf("expected records to be filtered by update time, got %q", params[0]["filter"])
+	}
+	if params[0]["sort"] != "updated,id" {
+		t.Errorf("expected records to be sorted by update time, got %q", params[0]["sort"])
+	}
+	if expected := time.Date(2024, 3, 2, 9, 30, 15, 250_000_000, time.UTC); !pbe.LastUpdated.Equal(expected) {
+		t.Errorf("expected the last update to be %v, got %v", expected, pbe.LastUpdated)
+	}
+
+	found, err := pbe.URLs(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error listing URLs: %v", err)
+	}
+	if len(found) != 3 || !found["entities/a"] || !found["https://example.com/c"] {
+		t.Errorf("expected the URLs of all records, got %v", found)
+	}
+	if last := params[len(params)-1]; last["fields"] != "id,url" || last["filter"] != "" {
+		t.Errorf("expected all records to be listed with only the id and url fields, got %v", last)
+	}
+}
+
+func TestPocketbaseExporterIncrementalPages(t *testing.T) {
+	pages := [][]map[string]any{
+		{
+			{"id": "a", "title": "A", "updated": "2024-03-01 10:00:00.000Z"},
+			{"id": "b", "title": "B", "updated": "2024-03-01 10:00:00.000Z"},
+		},
+		{
+			{"id": "c", "title": "C", "updated": "2024-03-02 10:00:00.000Z"},
+		},
+	}
+	var params []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		params = append(params, map[string]string{"filter": q.Get("filter"), "page": q.Get("page")})
+		var resp pocketbase.ResponseList[map[string]any]
+		if len(params) <= len(pages) {
+			resp.Items = pages[len(params)-1]
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	pbe := NewPocketbaseExporter(server.URL, pocketbase.NewClient(server.URL), "entities", "", "")
+	pbe.PageSize = 2
+	var urls []string
+	for doc := range pbe.Export(context.Background()) {
+		urls = append(urls, doc.Document.URL)
+	}
+	if pbe.Error != nil {
+		t.Fatalf("unexpected error: %v", pbe.Error)
+	}
+	if expected := []string{"entities/a", "entities/b", "entities/c"}; !slices.Equal(urls, expected) {
+		t.Errorf("expected URLs %v, got %v", expected, urls)
+	}
+	if len(params) != 2 {
+		t.Fatalf("expected the export to stop after a partial page, got %d requests", len(params))
+	}
+	expected := `(updated > "2024-03-01 10:00:00.000Z" || (updated = "2024-03-01 10:00:00.000Z" && id > "b"))`
+	if params[1]["filter"] != expected || params[1]["page"] != "1" {
+		t.Errorf("expected the next page to start after the last record, got %v", params[1])
+	}
+}
+
+func TestPocketbaseExporterGet(t *testing.T) {
+	items := []map[string]any{
+		{"id": "a", "title": "A", "author": "u1", "expand": map[string]any{"author": map[string]any{"name": "Alice"}}},
+	}
+	var params []map[string]string
+	server := newTestPocketbase(t, items, &params)
+
+	pbe := NewPocketbaseExporter(server.URL, pocketbase.NewClient(server.URL), "entities", "author", "")
+	doc, ok, err := pbe.Get(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok {
+		t.Fatal("expected the record to be found")
+	}
+	if doc.ID != "a" || doc.Document.URL != "entities/a" || doc.Document.Title != "A" {
+		t.Errorf("unexpected document: %+v", doc)
+	}
+	if expected := "author:\n    name: Alice\ntitle: A\n"; doc.Document.Text != expected {
+		t.Errorf("expected the expanded fields to be used, got %q", doc.Document.Text)
+	}
+	if params[0]["filter"] != `id = "a"` {
+		t.Errorf("expected the record to be filtered by ID, got %q", params[0]["filter"])
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	cp, err := loadCheckpoint(name, "http://pocketbase", "entities")
+	if err != nil {
+		t.Fatalf("unexpected error loading a missing checkpoint: %v", err)
+	}
+	if !cp.Updated.IsZero() {
+		t.Errorf("expected a missing checkpoint to import everything, got %v", cp.Updated)
+	}
+
+	cp.Updated = time.Date(2024, 3, 2, 9, 30, 15, 250_000_000, time.UTC)
+	if err = cp.Save(name); err != nil {
+		t.Fatalf("unexpected error saving checkpoint: %v", err)
+	}
+	loaded, err := loadCheckpoint(name, "http://pocketbase", "entities")
+	if err != nil {
+		t.Fatalf("unexpected error loading checkpoint: %v", err)
+	}
+	if !loaded.Updated.Equal(cp.Updated) {
+		t.Errorf("expected %v, got %v", cp.Updated, loaded.Updated)
+	}
+	if _, err = loadCheckpoint(name, "http://pocketbase", "other"); err == nil {
+		t.Error("expected an error loading the checkpoint of another collection")
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(name)); len(entries) != 1 {
+		t.Errorf("expected temporary files to be removed, got %d files", len(entries))
+	}
+}
+
+func TestImportRecordsSkipsInvalidDocuments(t *testing.T) {
+	items := []map[string]any{
+		{"id": "a", "title": "A", "updated": "2024-03-01 10:00:00.000Z"},
+		{"id": "invalid", "title": "Invalid", "updated": "2024-03-02 10:00:00.000Z"},
+	}
+	var params []map[string]string
+	pb := newTestPocketbase(t, items, &params)
+	// The RAG server always rejects the invalid record, and can be made to
+	// fail to store the others.
+	var storeFails bool
+	var puts int
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /documents:batch", func(w http.ResponseWriter, r *http.Request) {
+		var req models.DocumentsBatchPostRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		var resp models.DocumentsBatchPostResponse
+		for _, doc := range req.Documents {
+			result := models.DocumentsBatchPostResult{URL: doc.URL, ID: 1, Status: models.DocumentStatusCreated}
+			switch {
+			case doc.URL == "entities/invalid":
+				result = models.DocumentsBatchPostResult{URL: doc.URL, Error: "invalid metadata", StatusCode: http.StatusBadRequest}
+			case storeFails:
+				result = models.DocumentsBatchPostResult{URL: doc.URL, Error: "document put failed", StatusCode: http.StatusInternalServerError}
+			}
+			resp.Results = append(resp.Results, result)
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("POST /documents", func(w http.ResponseWriter, r *http.Request) {
+		puts++
+		http.Error(w, `{"message":"invalid metadata"}`, http.StatusBadRequest)
+	})
+	rag := httptest.NewServer(mux)
+	defer rag.Close()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c := ImportCommand{BatchSize: 10}
+	rsc := client.New(rag.URL, "")
+	pbe := NewPocketbaseExporter(pb.URL, pocketbase.NewClient(pb.URL), "entities", "", "")
+
+	t.Run("store failures are returned, and the checkpoint isn't moved", func(t *testing.T) {
+		storeFails = true
+		defer func() { storeFails = false }()
+		var cp checkpoint
+		if err := c.importRecords(context.Background(), log, rsc, pbe, &cp, false); err == nil {
+			t.Error("expected an error")
+		}
+		if !cp.Updated.IsZero() {
+			t.Errorf("expected the checkpoint not to move, got %v", cp.Updated)
+		}
+	})
+	t.Run("invalid documents are skipped, and the checkpoint is moved past them", func(t *testing.T) {
+		var cp checkpoint
+		if err := c.importRecords(context.Background(), log, rsc, pbe, &cp, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected := time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC); !cp.Updated.Equal(expected) {
+			t.Errorf("expected the checkpoint to be %v, got %v", expected, cp.Updated)
+		}
+	})
+	t.Run("invalid documents in events are skipped", func(t *testing.T) {
+		var cp checkpoint
+		event := PocketbaseEvent{Action: "update", Record: map[string]any{"id": "a", "updated": "2024-03-03 10:00:00.000Z"}}
+		if err := c.importEvent(context.Background(), log, rsc, pbe, &cp, event); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if puts != 1 {
+			t.Errorf("expected the document to be put once, got %d", puts)
+		}
+		if expected := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC); !cp.Updated.Equal(expected) {
+			t.Errorf("expected the checkpoint to be %v, got %v", expected, cp.Updated)
+		}
+	})
+}
+
+func TestDeleteMissingDocuments(t *testing.T) {
+	var prefix string
+	var deleted []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /documents", func(w http.ResponseWriter, r *http.Request) {
+		prefix = r.URL.Query().Get("prefix")
+		json.NewEncoder(w).Encode(models.DocumentsGetResponse{
+			Documents: []models.DocumentMetadata{{URL: "entities/a"}, {URL: "entities/deleted"}},
+		})
+	})
+	mux.HandleFunc("DELETE /documents/{url}", func(w http.ResponseWriter, r *http.Request) {
+		deleted = append(deleted, r.PathValue("url"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	rsc := client.New(server.URL, "")
+	found := map[string]bool{"entities/a": true}
+	if err := deleteMissingDocuments(context.Background(), log, rsc, "entities/", found, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("expected nothing to be deleted in dry run mode, got %v", deleted)
+	}
+	if err := deleteMissingDocuments(context.Background(), log, rsc, "entities/", found, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prefix != "entities/" {
+		t.Errorf("expected documents to be listed by prefix, got %q", prefix)
+	}
+	if expected := []string{"entities/deleted"}; !slices.Equal(deleted, expected) {
+		t.Errorf("expected %v to be deleted, got %v", expected, deleted)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"iter"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/a-h/ragserver/client"
+	"github.com/a-h/ragserver/loaders"
+	"github.com/a-h/ragserver/models"
+)
+
+type ImportDirCommand struct {
+	RAGServerURL    string   `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
+	RAGServerAPIKey string   `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
+	Collection      string   `help:"The shared collection to import into, instead of your personal collection." env:"RAG_SERVER_COLLECTION" default:""`
+	Dir             string   `arg:"" help:"The directory to import." type:"existingdir"`
+	Include         []string `help:"Glob patterns of the files to import. Patterns without a slash match file names, others match paths relative to the directory, where ** matches any number of directories." default:"*.md,*.txt,*.html,*.pdf"`
+	Exclude         []string `help:"Glob patterns of the files and directories to skip." default:".*"`
+	BaseURL         string   `help:"The base URL of the documents, e.g. https://github.com/org/handbook/blob/main. The URL of each document is the base URL joined with the relative path of the file. If empty, the URL is the relative path."`
+	DeleteMissing   bool     `help:"Delete documents with URLs under the base URL whose files no longer exist. Requires --base-url, so that documents from other sources aren't deleted." negatable:"" default:"false"`
+	DryRun          bool     `help:"Log the documents that would be imported and deleted, without changing the RAG server." env:"DRY_RUN" default:"false"`
+	BatchSize       int      `help:"The number of documents to send to the RAG server in each request." env:"BATCH_SIZE" default:"50"`
+	LogLevel        string   `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
+}
+
+func (c ImportDirCommand) Run(ctx context.Context) (err error) {
+	log := getLogger(c.LogLevel)
+
+	rsc := client.New(c.RAGServerURL, c.RAGServerAPIKey).WithCollection(c.Collection)
+
+	if c.BatchSize < 1 || c.BatchSize > models.DocumentsBatchMaxDocuments {
+		return fmt.Errorf("batch size must be between 1 and %d", models.DocumentsBatchMaxDocuments)
+	}
+	// Without a base URL, every document in the collection would be checked,
+	// including the documents of other importers.
+	if c.DeleteMissing && c.BaseURL == "" {
+		return fmt.Errorf("--delete-missing requires --base-url, so that only the documents under the base URL are deleted")
+	}
+	putter := newBatchPutter(log, rsc, c.BatchSize)
+
+	de := NewDirectoryExporter(os.DirFS(c.Dir), c.Include, c.Exclude, c.BaseURL)
+	for doc := range de.Export(ctx) {
+		if doc.Error != nil {
+			// Keep going, so that the rest of the directory is imported.
+			log.Error("failed to load file", slog.String("path", doc.ID), slog.Any("error", doc.Error))
+			continue
+		}
+		log.Info("importing document", slog.String("path", doc.ID), slog.String("url", doc.Document.URL), slog.String("title", doc.Document.Title))
+		if c.DryRun {
+			log.Info("skipping document import in dry run mode", slog.String("url", doc.Document.URL))
+			continue
+		}
+		if err = putter.Put(ctx, doc.Document); err != nil {
+			return err
+		}
+	}
+	if de.Error != nil {
+		return de.Error
+	}
+	if err = putter.Close(ctx); err != nil {
+		return err
+	}
+	if !c.DeleteMissing {
+		return nil
+	}
+	prefix := strings.TrimSuffix(c.BaseURL, "/") + "/"
+	return deleteMissingDocuments(ctx, log, rsc, prefix, de.URLs, c.DryRun)
+}
+
+func NewDirectoryExporter(fsys fs.FS, include, exclude []string, baseURL string) *DirectoryExporter {
+	return &DirectoryExporter{
+		fsys:    fsys,
+		include: include,
+		exclude: exclude,
+		baseURL: baseURL,
+		URLs:    map[string]bool{},
+	}
+}
+
+// DirectoryExporter exports the files in a directory tree as documents.
+type DirectoryExporter struct {
+	fsys    fs.FS
+	include []string
+	exclude []string
+	baseURL string
+	// URLs of the files that were found, including the ones that failed to load.
+	URLs  map[string]bool
+	Error error
+}
+
+// ExportedFile is a file that was exported from a directory. The ID is the
+// path of the file, relative to the directory.
+type ExportedFile struct {
+	ExportedDocument
+	// Error is set if the file couldn't be loaded.
+	Error error
+}
+
+func (d *DirectoryExporter) Export(ctx context.Context) iter.Seq[ExportedFile] {
+	return func(yield func(ExportedFile) bool) {
+		stop := errors.New("stop")
+		err := fs.WalkDir(d.fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if name == "." {
+				return nil
+			}
+			if matchAny(d.exclude, name) {
+				if entry.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if entry.IsDir() || !entry.Type().IsRegular() || !matchAny(d.include, name) {
+				return nil
+			}
+			if loaders.ContentType("", name) == "" {
+				return nil
+			}
+			if !yield(d.createDocument(ctx, name)) {
+				return stop
+			}
+			return nil
+		})
+		if err != nil && err != stop {
+			d.Error = err
+		}
+	}
+}
+
+func (d *DirectoryExporter) createDocument(ctx context.Context, name string) (ef ExportedFile) {
+	ef.ID = name
+	ef.Document.URL = name
+	if d.baseURL != "" {
+		ef.Document.URL = strings.TrimSuffix(d.baseURL, "/") + "/" + name
+	}
+	d.URLs[ef.Document.URL] = true
+
+	data, err := fs.ReadFile(d.fsys, name)
+	if err != nil {
+		ef.Error = fmt.Errorf("failed to read file: %w", err)
+		return ef
+	}
+	loaded, err := loaders.Load(ctx, "", name, data)
+	if err != nil {
+		ef.Error = err
+		return ef
+	}
+	if strings.TrimSpace(loaded.Text) == "" {
+		ef.Error = errors.New("no text found in file")
+		return ef
+	}
+	ef.Document.Title = cmp.Or(loaded.Title, path.Base(name))
+	ef.Document.Summary = loaded.Summary
+	ef.Document.PublishedAt = loaded.PublishedAt
+	ef.Document.Text = loaded.Text
+	return ef
+}
+
+// matchAny returns true if any of the glob patterns match the slash separated
+// path. Patterns without a slash match the last element of the path.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(name)); ok {
+				return true
+			}
+			continue
+		}
+		if matchGlob(strings.Split(pattern, "/"), strings.Split(name, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches path elements against pattern elements, where a ** element
+// matches any number of path elements.
+func matchGlob(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchGlob(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+	return matchGlob(pattern[1:], name[1:])
+}
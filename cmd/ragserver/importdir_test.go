@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMatchAny(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		expected bool
+	}{
+		{
+			name:     "patterns without a slash match the file name",
+			patterns: []string{"*.md"},
+			path:     "docs/guides/setup.md",
+			expected: true,
+		},
+		{
+			name:     "patterns with a slash match the relative path",
+			patterns: []string{"docs/*.md"},
+			path:     "docs/guides/setup.md",
+			expected: false,
+		},
+		{
+			name:     "** matches any number of directories",
+			patterns: []string{"docs/**/*.md"},
+			path:     "docs/guides/setup.md",
+			expected: true,
+		},
+		{
+			name:     "** matches no directories",
+			patterns: []string{"docs/**/*.md"},
+			path:     "docs/setup.md",
+			expected: true,
+		},
+		{
+			name:     "trailing ** matches the directory",
+			patterns: []string{"drafts/**"},
+			path:     "drafts",
+			expected: true,
+		},
+		{
+			name:     "any pattern can match",
+			patterns: []string{"*.txt", "*.pdf"},
+			path:     "report.pdf",
+			expected: true,
+		},
+		{
+			name:     "no patterns match nothing",
+			path:     "report.pdf",
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := matchAny(tt.patterns, tt.path); actual != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDirectoryExporter(t *testing.T) {
+	fsys := fstest.MapFS{
+		"README.md":             {Data: []byte("# Handbook\n\nWelcome.")},
+		"guides/setup.md":       {Data: []byte("---\ntitle: Setting up\nsummary: How to set up a laptop.\ndate: 2024-03-01\n---\n# Setup\n\nInstall Go.")},
+		"guides/notes.txt":      {Data: []byte("Some notes.")},
+		"guides/empty.txt":      {Data: []byte(" \n")},
+		"guides/image.png":      {Data: []byte("not text")},
+		"drafts/wip.md":         {Data: []byte("# Work in progress")},
+		".git/description.txt":  {Data: []byte("Unnamed repository.")},
+		"guides/index.html":     {Data: []byte("<html><head><title>Guides</title></head><body>All of the guides.</body></html>")},
+		"guides/ignored.md.bak": {Data: []byte("# Backup")},
+	}
+	de := NewDirectoryExporter(fsys, []string{"*.md", "*.txt", "*.html"}, []string{".*", "drafts/**"}, "https://example.com/handbook/")
+
+	type result struct {
+		url, title, summary string
+		publishedAt         time.Time
+		failed              bool
+	}
+	var results []result
+	for doc := range de.Export(context.Background()) {
+		r := result{
+			url:     doc.Document.URL,
+			title:   doc.Document.Title,
+			summary: doc.Document.Summary,
+			failed:  doc.Error != nil,
+		}
+		if doc.Document.PublishedAt != nil {
+			r.publishedAt = *doc.Document.PublishedAt
+		}
+		results = append(results, r)
+	}
+	if de.Error != nil {
+		t.Fatalf("unexpected error: %v", de.Error)
+	}
+
+	expected := []result{
+		{url: "https://example.com/handbook/README.md", title: "Handbook"},
+		{url: "https://example.com/handbook/guides/empty.txt", failed: true},
+		{url: "https://example.com/handbook/guides/index.html", title: "Guides"},
+		{url: "https://example.com/handbook/guides/notes.txt", title: "notes.txt"},
+		{url: "https://example.com/handbook/guides/setup.md", title: "Setting up", summary: "How to set up a laptop.", publishedAt: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d documents, got %d: %+v", len(expected), len(results), results)
+	}
+	for i := range expected {
+		if results[i] != expected[i] {
+			t.Errorf("document %d: expected %+v, got %+v", i, expected[i], results[i])
+		}
+	}
+	for _, r := range expected {
+		if !de.URLs[r.url] {
+			t.Errorf("expected %q to be in the URLs that were found", r.url)
+		}
+	}
+}
+
+func TestImportDirDeleteMissingRequiresBaseURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("# Handbook"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	c := ImportDirCommand{
+		RAGServerURL:  server.URL,
+		Dir:           dir,
+		Include:       []string{"*.md"},
+		DeleteMissing: true,
+		BatchSize:     50,
+		LogLevel:      "error",
+	}
+	if err := c.Run(context.Background()); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/donovanhide/eventsource"
+)
+
+// PocketbaseEvent is a change to a record, received from Pocketbase's realtime API.
+type PocketbaseEvent struct {
+	// Action is create, update or delete.
+	Action string         `json:"action"`
+	Record map[string]any `json:"record"`
+}
+
+func NewPocketbaseWatcher(log *slog.Logger, client *http.Client, baseURL, collection string) *PocketbaseWatcher {
+	return &PocketbaseWatcher{
+		log:        log,
+		client:     client,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		collection: collection,
+		Backoff: backoff.NewExponentialBackOff(
+			backoff.WithInitialInterval(time.Second),
+			backoff.WithMaxInterval(time.Minute),
+			backoff.WithMaxElapsedTime(0),
+		),
+	}
+}
+
+// PocketbaseWatcher subscribes to the changes to the records of a collection
+// with Pocketbase's realtime API, which uses server-sent events.
+type PocketbaseWatcher struct {
+	log        *slog.Logger
+	client     *http.Client
+	baseURL    string
+	collection string
+	// Backoff is the delay between reconnection attempts. It's reset after
+	// catching up.
+	Backoff backoff.BackOff
+}
+
+// Watch calls onEvent for each change to a record, in order, until the
+// context is cancelled. Changes aren't sent while disconnected, so onConnect
+// is called after each subscription is established, to catch up. If the
+// connection is lost, or either function fails, Watch reconnects with backoff.
+func (w *PocketbaseWatcher) Watch(ctx context.Context, onConnect func(ctx context.Context) error, onEvent func(ctx context.Context, event PocketbaseEvent) error) (err error) {
+	b := backoff.WithContext(w.Backoff, ctx)
+	err = backoff.RetryNotify(func() error {
+		return w.watch(ctx, b, onConnect, onEvent)
+	}, b, func(err error, delay time.Duration) {
+		w.log.Warn("realtime subscription failed, reconnecting", slog.Any("error", err), slog.Duration("delay", delay))
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func (w *PocketbaseWatcher) watch(ctx context.Context, b backoff.BackOff, onConnect func(ctx context.Context) error, onEvent func(ctx context.Context, event PocketbaseEvent) error) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.baseURL+"/api/realtime", nil)
+	if err != nil {
+		return backoff.Permanent(fmt.Errorf("failed to create request: %w", err))
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to connect: unexpected status %d", resp.StatusCode)
+	}
+
+	// The first event contains the ID of the client, which is used to
+	// subscribe to the collection.
+	dec := eventsource.NewDecoder(resp.Body)
+	ev, err := dec.Decode()
+	if err != nil {
+		return fmt.Errorf("failed to read connect event: %w", err)
+	}
+	if ev.Event() != "PB_CONNECT" {
+		return fmt.Errorf("expected the first event to be PB_CONNECT, got %q", ev.Event())
+	}
+	var connect struct {
+		ClientID string `json:"clientId"`
+	}
+	if err = json.Unmarshal([]byte(ev.Data()), &connect); err != nil {
+		return fmt.Errorf("failed to decode connect event: %w", err)
+	}
+	if err = w.subscribe(ctx, connect.ClientID); err != nil {
+		return err
+	}
+	w.log.Info("subscribed to realtime changes", slog.String("collection", w.collection))
+	if err = onConnect(ctx); err != nil {
+		return fmt.Errorf("failed to catch up: %w", err)
+	}
+	b.Reset()
+	for {
+		ev, err := dec.Decode()
+		if err != nil {
+			return fmt.Errorf("failed to read event: %w", err)
+		}
+		var event PocketbaseEvent
+		if err = json.Unmarshal([]byte(ev.Data()), &event); err != nil {
+			w.log.Warn("skipping invalid event", slog.String("event", ev.Event()), slog.Any("error", err))
+			continue
+		}
+		if err = onEvent(ctx, event); err != nil {
+			return fmt.Errorf("failed to handle %s event: %w", event.Action, err)
+		}
+	}
+}
+
+func (w *PocketbaseWatcher) subscribe(ctx context.Context, clientID string) (err error) {
+	body, err := json.Marshal(map[string]any{
+		"clientId":      clientID,
+		"subscriptions": []string{w.collection + "/*"},
+	})
+	if err != nil {
+		return backoff.Permanent(fmt.Errorf("failed to encode subscription: %w", err))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.baseURL+"/api/realtime", bytes.NewReader(body))
+	if err != nil {
+		return backoff.Permanent(fmt.Errorf("failed to create request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to subscribe: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
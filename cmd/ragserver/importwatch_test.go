@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// newTestRealtime returns a stand-in for Pocketbase's realtime API. Each
+// connection sends the events of the next stream, and then closes, except for
+// the last one, which stays open. A nil stream fails to connect.
+func newTestRealtime(t *testing.T, streams [][]PocketbaseEvent, subscriptions *[]string) *httptest.Server {
+	t.Helper()
+	var m sync.Mutex
+	var connections int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/realtime" {
+			http.NotFound(w, r)
+			return
+		}
+		m.Lock()
+		defer m.Unlock()
+		if r.Method == http.MethodPost {
+			var req struct {
+				ClientID      string   `json:"clientId"`
+				Subscriptions []string `json:"subscriptions"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for _, s := range req.Subscriptions {
+				*subscriptions = append(*subscriptions, req.ClientID+":"+s)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		connections++
+		stream := streams[min(connections, len(streams))-1]
+		if stream == nil {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "id:client-%d\nevent:PB_CONNECT\ndata:{\"clientId\":\"client-%d\"}\n\n", connections, connections)
+		for _, event := range stream {
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "event:entities/*\ndata:%s\n\n", data)
+		}
+		w.(http.Flusher).Flush()
+		if connections < len(streams) {
+			return
+		}
+		m.Unlock()
+		<-r.Context().Done()
+		m.Lock()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPocketbaseWatcher(t *testing.T) {
+	streams := [][]PocketbaseEvent{
+		{
+			{Action: "create", Record: map[string]any{"id": "a"}},
+			{Action: "update", Record: map[string]any{"id": "a"}},
+		},
+		nil,
+		{
+			{Action: "delete", Record: map[string]any{"id": "a"}},
+		},
+	}
+	var subscriptions []string
+	server := newTestRealtime(t, streams, &subscriptions)
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	w := NewPocketbaseWatcher(log, server.Client(), server.URL+"/", "entities")
+	w.Backoff = backoff.NewConstantBackOff(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var connects int
+	var actions []string
+	err := w.Watch(ctx, func(ctx context.Context) error {
+		connects++
+		return nil
+	}, func(ctx context.Context, event PocketbaseEvent) error {
+		actions = append(actions, event.Action+" "+event.Record["id"].(string))
+		if len(actions) == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := []string{"create a", "update a", "delete a"}; !slices.Equal(actions, expected) {
+		t.Errorf("expected events %v, got %v", expected, actions)
+	}
+	if connects != 2 {
+		t.Errorf("expected to catch up after each of the 2 connections, got %d", connects)
+	}
+	if expected := []string{"client-1:entities/*", "client-3:entities/*"}; !slices.Equal(subscriptions, expected) {
+		t.Errorf("expected subscriptions %v, got %v", expected, subscriptions)
+	}
+}
+
+func TestPocketbaseWatcherRetriesFailedEvents(t *testing.T) {
+	streams := [][]PocketbaseEvent{
+		{
+			{Action: "create", Record: map[string]any{"id": "a"}},
+		},
+	}
+	var subscriptions []string
+	server := newTestRealtime(t, streams, &subscriptions)
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	w := NewPocketbaseWatcher(log, server.Client(), server.URL, "entities")
+	w.Backoff = backoff.NewConstantBackOff(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var connects, events int
+	err := w.Watch(ctx, func(ctx context.Context) error {
+		connects++
+		if connects == 2 {
+			cancel()
+			return ctx.Err()
+		}
+		return nil
+	}, func(ctx context.Context, event PocketbaseEvent) error {
+		events++
+		return fmt.Errorf("failed to import")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if events != 1 {
+		t.Errorf("expected 1 event, got %d", events)
+	}
+	if connects != 2 {
+		t.Errorf("expected a failed event to cause a reconnect to catch up, got %d connections", connects)
+	}
+}
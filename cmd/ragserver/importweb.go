@@ -0,0 +1,394 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/a-h/ragserver/client"
+	"github.com/a-h/ragserver/loaders"
+	"github.com/a-h/ragserver/models"
+)
+
+type ImportWebCommand struct {
+	RAGServerURL    string        `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
+	RAGServerAPIKey string        `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
+	Collection      string        `help:"The shared collection to import into, instead of your personal collection." env:"RAG_SERVER_COLLECTION" default:""`
+	URLs            []string      `arg:"" optional:"" help:"The URLs to start crawling from."`
+	Sitemap         []string      `help:"The URLs of sitemaps to start crawling from."`
+	Allow           []string      `help:"URL prefixes that crawled pages must start with, e.g. https://docs.example.com/guides/. Defaults to the directories of the start URLs, and the sites of the sitemaps."`
+	MaxDepth        int           `help:"The maximum number of links to follow from the start URLs." default:"3"`
+	MaxPages        int           `help:"The maximum number of pages to import." default:"1000"`
+	Delay           time.Duration `help:"The time to wait between requests. A longer crawl delay in robots.txt takes precedence." default:"1s"`
+	UserAgent       string        `help:"The user agent of the crawler, which is matched against robots.txt rules." default:"ragserver"`
+	DryRun          bool          `help:"Log the pages that would be imported, without changing the RAG server." env:"DRY_RUN" default:"false"`
+	LogLevel        string        `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
+}
+
+func (c ImportWebCommand) Run(ctx context.Context) (err error) {
+	log := getLogger(c.LogLevel)
+
+	if len(c.URLs) == 0 && len(c.Sitemap) == 0 {
+		return errors.New("at least one start URL or sitemap is required")
+	}
+	rsc := client.New(c.RAGServerURL, c.RAGServerAPIKey).WithCollection(c.Collection)
+
+	wc := NewWebCrawler(log, &http.Client{Timeout: 30 * time.Second}, c.UserAgent, c.Allow)
+	wc.MaxDepth = c.MaxDepth
+	wc.MaxPages = c.MaxPages
+	wc.Delay = c.Delay
+
+	var failed int
+	for page := range wc.Crawl(ctx, c.URLs, c.Sitemap) {
+		log.Info("importing page", slog.String("url", page.Document.URL), slog.String("title", page.Document.Title))
+		if c.DryRun {
+			log.Info("skipping page import in dry run mode", slog.String("url", page.Document.URL))
+			continue
+		}
+		resp, err := rsc.DocumentsPut(ctx, models.DocumentsPostRequest{
+			Document: page.Document,
+		})
+		if err != nil {
+			failed++
+			log.Error("page import failed", slog.String("url", page.Document.URL), slog.Any("error", err))
+			continue
+		}
+		log.Info("page imported", slog.String("url", page.Document.URL), slog.Int64("id", resp.ID), slog.String("status", string(resp.Status)))
+	}
+	if wc.Error != nil {
+		return wc.Error
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d pages failed to import", failed)
+	}
+	return nil
+}
+
+func NewWebCrawler(log *slog.Logger, client *http.Client, userAgent string, allow []string) *WebCrawler {
+	return &WebCrawler{
+		log:       log,
+		client:    client,
+		userAgent: userAgent,
+		allow:     allow,
+		robots:    map[string]robots{},
+		MaxDepth:  3,
+		MaxPages:  1000,
+		Delay:     time.Second,
+	}
+}
+
+// WebCrawler exports the pages of a website as documents. It follows links
+// within the allowed URL prefixes, and respects robots.txt.
+type WebCrawler struct {
+	log       *slog.Logger
+	client    *http.Client
+	userAgent string
+	// allow is the list of URL prefixes that pages must start with. If empty,
+	// it's populated from the start URLs.
+	allow []string
+	// robots caches the robots.txt rules of each site.
+	robots      map[string]robots
+	lastRequest time.Time
+	MaxDepth    int
+	MaxPages    int
+	// Delay is the minimum time between requests.
+	Delay time.Duration
+	Error error
+}
+
+type crawlTarget struct {
+	url   string
+	depth int
+}
+
+// Crawl crawls the start URLs, and the URLs in the sitemaps, breadth first.
+// The ID of each exported document is the URL that was fetched, and the URL
+// of the document is the canonical URL of the page.
+func (w *WebCrawler) Crawl(ctx context.Context, urls, sitemaps []string) iter.Seq[ExportedDocument] {
+	return func(yield func(ExportedDocument) bool) {
+		var queue []crawlTarget
+		for _, u := range urls {
+			queue = append(queue, crawlTarget{url: u})
+		}
+		for _, sitemap := range sitemaps {
+			pages, err := w.getSitemap(ctx, sitemap)
+			if err != nil {
+				w.Error = fmt.Errorf("failed to get sitemap %q: %w", sitemap, err)
+				return
+			}
+			for _, page := range pages {
+				queue = append(queue, crawlTarget{url: page})
+			}
+		}
+		if len(w.allow) == 0 {
+			w.allow = defaultAllowPrefixes(urls, sitemaps)
+		}
+
+		seen := map[string]bool{}
+		imported := map[string]bool{}
+		for len(queue) > 0 && len(imported) < w.MaxPages {
+			if ctx.Err() != nil {
+				w.Error = ctx.Err()
+				return
+			}
+			target := queue[0]
+			queue = queue[1:]
+			u, err := url.Parse(target.url)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				w.log.Warn("skipping invalid URL", slog.String("url", target.url))
+				continue
+			}
+			u.Fragment = ""
+			if u.Path == "" {
+				u.Path = "/"
+			}
+			if seen[u.String()] || !w.allowed(u.String()) {
+				continue
+			}
+			seen[u.String()] = true
+			if !w.getRobots(ctx, u).allowed(u.RequestURI()) {
+				w.log.Debug("skipping URL disallowed by robots.txt", slog.String("url", u.String()))
+				continue
+			}
+
+			page, ok, err := w.getPage(ctx, u)
+			if err != nil {
+				w.log.Warn("failed to get page", slog.String("url", u.String()), slog.Any("error", err))
+				continue
+			}
+			if !ok {
+				continue
+			}
+			if target.depth < w.MaxDepth && !page.noFollow {
+				for _, link := range page.links {
+					queue = append(queue, crawlTarget{url: link, depth: target.depth + 1})
+				}
+			}
+			if page.noIndex || imported[page.document.URL] || strings.TrimSpace(page.document.Text) == "" {
+				continue
+			}
+			imported[page.document.URL] = true
+			if !yield(ExportedDocument{ID: u.String(), Document: page.document}) {
+				return
+			}
+		}
+	}
+}
+
+// defaultAllowPrefixes allows the directories of the start URLs, and the
+// sites of the sitemaps.
+func defaultAllowPrefixes(urls, sitemaps []string) (prefixes []string) {
+	for _, s := range urls {
+		if u, err := url.Parse(s); err == nil {
+			u.Path = cmp.Or(u.Path[:strings.LastIndex(u.Path, "/")+1], "/")
+			u.RawQuery, u.Fragment = "", ""
+			prefixes = append(prefixes, u.String())
+		}
+	}
+	for _, s := range sitemaps {
+		if u, err := url.Parse(s); err == nil {
+			prefixes = append(prefixes, (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}).String())
+		}
+	}
+	return prefixes
+}
+
+func (w *WebCrawler) allowed(u string) bool {
+	for _, prefix := range w.allow {
+		if strings.HasPrefix(u, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// get waits for the politeness delay, and makes a GET request.
+func (w *WebCrawler) get(ctx context.Context, u *url.URL) (resp *http.Response, err error) {
+	delay := w.Delay
+	if r, ok := w.robots[u.Host]; ok {
+		delay = max(delay, r.crawlDelay)
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(time.Until(w.lastRequest.Add(delay))):
+	}
+	w.lastRequest = time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", w.userAgent)
+	return w.client.Do(req)
+}
+
+// getRobots returns the robots.txt rules of the URL's site. If the site has
+// no robots.txt, everything is allowed. If it can't be read, nothing is.
+func (w *WebCrawler) getRobots(ctx context.Context, u *url.URL) robots {
+	if r, ok := w.robots[u.Host]; ok {
+		return r
+	}
+	r := robotsDisallowAll
+	resp, err := w.get(ctx, &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"})
+	switch {
+	case err != nil:
+		w.log.Warn("failed to get robots.txt", slog.String("host", u.Host), slog.Any("error", err))
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		r = parseRobots(io.LimitReader(resp.Body, 512<<10), w.userAgent)
+	case resp.StatusCode >= 400 && resp.StatusCode <= 499:
+		r = robotsAllowAll
+	default:
+		w.log.Warn("failed to get robots.txt", slog.String("host", u.Host), slog.Int("status", resp.StatusCode))
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	w.robots[u.Host] = r
+	return r
+}
+
+type urlSet struct {
+	URLs     []string `xml:"url>loc"`
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+// getSitemap returns the page URLs in a sitemap, or sitemap index.
+func (w *WebCrawler) getSitemap(ctx context.Context, sitemap string) (urls []string, err error) {
+	u, err := url.Parse(sitemap)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := w.get(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	// Sitemaps and sitemap indexes have different root elements, but the
+	// same structure.
+	var set urlSet
+	if err = xml.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode sitemap: %w", err)
+	}
+	urls = set.URLs
+	for _, child := range set.Sitemaps {
+		childURLs, err := w.getSitemap(ctx, strings.TrimSpace(child))
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, childURLs...)
+	}
+	for i := range urls {
+		urls[i] = strings.TrimSpace(urls[i])
+	}
+	return urls, nil
+}
+
+type webPage struct {
+	document models.Document
+	links    []string
+	noIndex  bool
+	noFollow bool
+}
+
+// getPage fetches and parses an HTML page. If the URL isn't an HTML page, ok is false.
+func (w *WebCrawler) getPage(ctx context.Context, u *url.URL) (page webPage, ok bool, err error) {
+	resp, err := w.get(ctx, u)
+	if err != nil {
+		return page, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return page, false, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return page, false, nil
+	}
+	// Redirects may have moved to a page outside the allowed prefixes.
+	if !w.allowed(resp.Request.URL.String()) {
+		return page, false, nil
+	}
+	page, err = parsePage(ctx, resp.Request.URL, io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return page, false, err
+	}
+	// A canonical URL outside the allowed prefixes could replace any document
+	// in the collection, so the page is stored under its own URL instead.
+	if !w.allowed(page.document.URL) {
+		page.document.URL = resp.Request.URL.String()
+	}
+	return page, true, nil
+}
+
+// maxPageSize is the maximum number of bytes of a page that are parsed.
+const maxPageSize = 10 << 20
+
+// boilerplateSelector matches the parts of a page that aren't its content.
+const boilerplateSelector = "nav, header, footer, aside, form"
+
+// parsePage extracts the main content of an HTML page, and the links on it.
+func parsePage(ctx context.Context, pageURL *url.URL, r io.Reader) (page webPage, err error) {
+	html, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return page, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	base := pageURL
+	if href, ok := html.Find("base[href]").Attr("href"); ok {
+		if u, err := pageURL.Parse(href); err == nil {
+			base = u
+		}
+	}
+	html.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+		href, _ := a.Attr("href")
+		if strings.Contains(strings.ToLower(a.AttrOr("rel", "")), "nofollow") {
+			return
+		}
+		if u, err := base.Parse(strings.TrimSpace(href)); err == nil {
+			u.Fragment = ""
+			page.links = append(page.links, u.String())
+		}
+	})
+	html.Find(`meta[name="robots"]`).Each(func(_ int, meta *goquery.Selection) {
+		content := strings.ToLower(meta.AttrOr("content", ""))
+		page.noIndex = page.noIndex || strings.Contains(content, "noindex") || strings.Contains(content, "none")
+		page.noFollow = page.noFollow || strings.Contains(content, "nofollow") || strings.Contains(content, "none")
+	})
+
+	page.document.URL = pageURL.String()
+	if href, ok := html.Find(`link[rel="canonical"]`).Attr("href"); ok {
+		if u, err := base.Parse(strings.TrimSpace(href)); err == nil {
+			u.Fragment = ""
+			page.document.URL = u.String()
+		}
+	}
+	page.document.Title = cmp.Or(
+		strings.TrimSpace(html.Find("title").First().Text()),
+		strings.TrimSpace(html.Find("h1").First().Text()),
+		pageURL.String(),
+	)
+	page.document.Summary = strings.TrimSpace(html.Find(`meta[name="description"]`).AttrOr("content", ""))
+
+	content := html.Find("main, article, [role=main]").First()
+	if content.Length() == 0 {
+		content = html.Find("body")
+	}
+	content.Find(boilerplateSelector).Remove()
+	if page.document.Text, err = loaders.HTMLText(ctx, content); err != nil {
+		return page, fmt.Errorf("failed to load content: %w", err)
+	}
+	return page, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRobots(t *testing.T) {
+	const robotsTxt = `# Comments are ignored.
+User-agent: *
+Disallow: /private/
+Crawl-delay: 2
+
+User-agent: ragserver
+User-agent: other
+Disallow: /drafts/
+Allow: /drafts/published/
+Disallow: /*.pdf$
+Crawl-delay: 0.5
+
+Sitemap: https://example.com/sitemap.xml
+`
+	tests := []struct {
+		name      string
+		userAgent string
+		path      string
+		expected  bool
+	}{
+		{
+			name:      "the user agent's group is used",
+			userAgent: "ragserver",
+			path:      "/private/a",
+			expected:  true,
+		},
+		{
+			name:      "disallowed paths can't be crawled",
+			userAgent: "ragserver",
+			path:      "/drafts/a",
+			expected:  false,
+		},
+		{
+			name:      "the longest rule wins",
+			userAgent: "ragserver",
+			path:      "/drafts/published/a",
+			expected:  true,
+		},
+		{
+			name:      "wildcards and anchors are supported",
+			userAgent: "ragserver",
+			path:      "/files/a.pdf",
+			expected:  false,
+		},
+		{
+			name:      "anchored patterns only match the end",
+			userAgent: "ragserver",
+			path:      "/files/a.pdf?download=1",
+			expected:  true,
+		},
+		{
+			name:      "other user agents use the wildcard group",
+			userAgent: "another-bot",
+			path:      "/private/a",
+			expected:  false,
+		},
+		{
+			name:      "paths without rules are allowed",
+			userAgent: "another-bot",
+			path:      "/drafts/a",
+			expected:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := parseRobots(strings.NewReader(robotsTxt), tt.userAgent)
+			if actual := r.allowed(tt.path); actual != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+	t.Run("crawl delays are read", func(t *testing.T) {
+		if r := parseRobots(strings.NewReader(robotsTxt), "ragserver"); r.crawlDelay != 500*time.Millisecond {
+			t.Errorf("expected a crawl delay of 500ms, got %v", r.crawlDelay)
+		}
+		if r := parseRobots(strings.NewReader(robotsTxt), "another-bot"); r.crawlDelay != 2*time.Second {
+			t.Errorf("expected a crawl delay of 2s, got %v", r.crawlDelay)
+		}
+	})
+}
+
+func newTestSite(t *testing.T) *httptest.Server {
+	t.Helper()
+	page := func(title, body string) string {
+		return fmt.Sprintf(`<html><head><title>%s</title></head><body><nav><a href="/docs/">Home</a> Navigation</nav><main>%s</main><footer>Footer</footer></body></html>`, title, body)
+	}
+	pages := map[string]string{
+		"/robots.txt": "User-agent: *\nDisallow: /docs/secret\n",
+		"/sitemap.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>SITE/docs/sitemap-only</loc></url>
+</urlset>`,
+		"/docs/":             page("Home", `<p>Welcome to the docs.</p><a href="guide">Guide</a> <a href="secret">Secret</a> <a href="/blog/">Blog</a> <a href="https://elsewhere.example.com/">Elsewhere</a>`),
+		"/docs/guide":        page("Guide", `<link rel="canonical" href="/docs/guide/"><p>The guide&#39;s text &amp; more.</p><a href="guide#section">Section</a> <a href="deeper">Deeper</a>`),
+		"/docs/deeper":       page("Deeper", `<link rel="canonical" href="https://elsewhere.example.com/docs/guide/"><p>Deeper.</p><a href="deepest">Deepest</a>`),
+		"/docs/deepest":      page("Deepest", `<p>Too deep.</p>`),
+		"/docs/secret":       page("Secret", `<p>Disallowed by robots.txt.</p>`),
+		"/docs/sitemap-only": `<html><head><title>Sitemap only</title><meta name="robots" content="nofollow"></head><body><p>Only linked from the sitemap.</p><a href="/docs/unlinked">Unlinked</a></body></html>`,
+		"/docs/unlinked":     page("Unlinked", `<p>Not followed.</p>`),
+		"/blog/":             page("Blog", `<p>Outside the allowed prefix.</p>`),
+	}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, ".txt"):
+			w.Header().Set("Content-Type", "text/plain")
+		case strings.HasSuffix(r.URL.Path, ".xml"):
+			w.Header().Set("Content-Type", "application/xml")
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		io.WriteString(w, strings.ReplaceAll(body, "SITE", server.URL))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWebCrawler(t *testing.T) {
+	site := newTestSite(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	wc := NewWebCrawler(log, site.Client(), "ragserver", []string{site.URL + "/docs/"})
+	wc.MaxDepth = 2
+	wc.Delay = 0
+
+	var urls []string
+	var guideText string
+	for page := range wc.Crawl(context.Background(), []string{site.URL + "/docs/"}, []string{site.URL + "/sitemap.xml"}) {
+		urls = append(urls, strings.TrimPrefix(page.Document.URL, site.URL))
+		if page.Document.Title == "Guide" {
+			guideText = page.Document.Text
+		}
+	}
+	if wc.Error != nil {
+		t.Fatalf("unexpected error: %v", wc.Error)
+	}
+
+	expected := []string{"/docs/", "/docs/sitemap-only", "/docs/guide/", "/docs/deeper"}
+	if !slices.Equal(urls, expected) {
+		t.Errorf("expected pages %v, got %v", expected, urls)
+	}
+	if !strings.Contains(guideText, "The guide's text & more.\nSection Deeper") {
+		t.Errorf("expected the main content to be extracted, got %q", guideText)
+	}
+	if strings.Contains(guideText, "Navigation") || strings.Contains(guideText, "Footer") {
+		t.Errorf("expected navigation and footers to be removed, got %q", guideText)
+	}
+}
+
+func TestDefaultAllowPrefixes(t *testing.T) {
+	actual := defaultAllowPrefixes([]string{"https://example.com/docs/guide?page=1", "https://example.com"}, []string{"https://other.example.com/sitemaps/sitemap.xml"})
+	expected := []string{"https://example.com/docs/", "https://example.com/", "https://other.example.com/"}
+	if !slices.Equal(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestWebCrawlerMaxPages(t *testing.T) {
+	site := newTestSite(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	wc := NewWebCrawler(log, site.Client(), "ragserver", nil)
+	wc.MaxPages = 2
+	wc.Delay = 0
+
+	var count int
+	for range wc.Crawl(context.Background(), []string{site.URL + "/docs/"}, nil) {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("expected 2 pages, got %d", count)
+	}
+}
@@ -12,12 +12,16 @@ import (
 )
 
 type CLI struct {
-	Serve   ServeCommand   `cmd:"serve" help:"Start the RAG server."`
-	Import  ImportCommand  `cmd:"import" help:"Import documents into a RAG server."`
-	Context ContextCommand `cmd:"context" help:"Get similar documents for a piece of text."`
-	Chat    ChatCommand    `cmd:"chat" help:"Chat with the RAG server."`
-	Query   QueryCommand   `cmd:"query" help:"Query the RAG store and LLM."`
-	Version VersionCommand `cmd:"version" help:"Print the version of the RAG server."`
+	Serve     ServeCommand     `cmd:"serve" help:"Start the RAG server."`
+	Import    ImportCommand    `cmd:"import" help:"Import documents into a RAG server."`
+	ImportDir ImportDirCommand `cmd:"import-dir" help:"Import the files in a directory into a RAG server."`
+	ImportWeb ImportWebCommand `cmd:"import-web" help:"Crawl a website, and import its pages into a RAG server."`
+	Documents DocumentsCommand `cmd:"documents" help:"List, get and delete documents in a RAG server."`
+	Context   ContextCommand   `cmd:"context" help:"Get similar documents for a piece of text."`
+	Chat      ChatCommand      `cmd:"chat" help:"Chat with the RAG server."`
+	Query     QueryCommand     `cmd:"query" help:"Query the RAG store and LLM."`
+	Search    SearchCommand    `cmd:"search" help:"Search documents by keyword."`
+	Version   VersionCommand   `cmd:"version" help:"Print the version of the RAG server."`
 }
 
 func main() {
@@ -2,18 +2,36 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/a-h/ragserver/client"
 	"github.com/a-h/ragserver/models"
 )
 
 type QueryCommand struct {
-	RAGServerURL    string `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
-	RAGServerAPIKey string `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
-	NoContext       bool   `help:"Do not use context." env:"NO_CONTEXT" default:"false"`
-	Query           string `help:"The query to send." short:"q"`
-	LogLevel        string `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
+	RAGServerURL        string    `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
+	RAGServerAPIKey     string    `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
+	Collections         []string  `help:"The collections to search, e.g. personal,team. Defaults to your personal collection."`
+	NoContext           bool      `help:"Do not use context." env:"NO_CONTEXT" default:"false"`
+	Mode                string    `help:"The retrieval mode to use." enum:"vector,keyword,hybrid" default:"vector"`
+	Filter              string    `help:"A metadata filter expression, e.g. 'source = \"runbooks\"'."`
+	MMR                 bool      `help:"Select diverse chunks with maximal marginal relevance."`
+	MMRLambda           float64   `help:"The MMR trade-off between relevance (1) and diversity (0)." default:"0.5"`
+	MaxChunksPerURL     int       `help:"The maximum number of chunks to use from each document, 0 for no limit." default:"0"`
+	Expand              int       `help:"The number of neighbouring chunks to add before and after each chunk." default:"0"`
+	MaxDistance         *float64  `help:"Drop chunks further than this from the text, overriding the server default. 0 disables the threshold."`
+	Transform           string    `help:"Rewrite the text before retrieval. multi-query retrieves with paraphrases of the text, hyde retrieves with a hypothetical answer." enum:",multi-query,hyde" default:""`
+	Since               time.Time `help:"Only use documents dated on or after this date, e.g. 2026-01-01." format:"2006-01-02"`
+	Until               time.Time `help:"Only use documents dated on or before this date, e.g. 2026-12-31." format:"2006-01-02"`
+	RecencyHalfLifeDays float64   `help:"Favour recent documents. A document's recency halves every this many days, 0 to ignore recency." default:"0"`
+	RecencyWeight       float64   `help:"The share of the score given to recency, between 0 and 1." default:"0.5"`
+	Strict              bool      `help:"Don't ask the LLM if no relevant context is found. Requires --max-distance, or a server default max distance."`
+	Sources             bool      `help:"List the sources that the answer can cite after the answer."`
+	Query               string    `help:"The query to send." short:"q"`
+	LogLevel            string    `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
 }
 
 func (c QueryCommand) Run(ctx context.Context) (err error) {
@@ -27,8 +45,58 @@ func (c QueryCommand) Run(ctx context.Context) (err error) {
 		_, err := os.Stdout.Write(chunk)
 		return err
 	}
-	return rsc.QueryPost(ctx, models.QueryPostRequest{
-		Text:      c.Query,
-		NoContext: c.NoContext,
-	}, f)
+	req := models.QueryPostRequest{
+		Text:            c.Query,
+		NoContext:       c.NoContext,
+		Mode:            models.RetrievalMode(c.Mode),
+		Filter:          c.Filter,
+		MaxChunksPerURL: c.MaxChunksPerURL,
+		MaxDistance:     c.MaxDistance,
+		Expand:          c.Expand,
+		Collections:     c.Collections,
+		Transform:       models.QueryTransform(c.Transform),
+		Strict:          c.Strict,
+	}
+	if c.MMR {
+		req.MMR = &models.MMR{Lambda: c.MMRLambda}
+	}
+	if !c.Since.IsZero() {
+		req.Since = &c.Since
+	}
+	if !c.Until.IsZero() {
+		// Include the whole day.
+		until := c.Until.Add(24*time.Hour - time.Nanosecond)
+		req.Until = &until
+	}
+	if c.RecencyHalfLifeDays > 0 {
+		req.Recency = &models.Recency{HalfLifeDays: c.RecencyHalfLifeDays, Weight: c.RecencyWeight}
+	}
+	if c.Sources {
+		err = queryWithSources(ctx, rsc, req, f)
+	} else {
+		err = rsc.QueryPost(ctx, req, f)
+	}
+	if errors.Is(err, client.ErrNoRelevantDocuments) {
+		fmt.Println("No relevant documents found.")
+		return nil
+	}
+	return err
+}
+
+func queryWithSources(ctx context.Context, rsc client.Client, req models.QueryPostRequest, f func(ctx context.Context, chunk []byte) error) (err error) {
+	var sources []models.QuerySource
+	receiveSources := func(ctx context.Context, s []models.QuerySource) error {
+		sources = s
+		return nil
+	}
+	if err = rsc.QueryPostWithSources(ctx, req, receiveSources, f); err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Println()
+	fmt.Println("Sources:")
+	for _, source := range sources {
+		fmt.Printf("[%d] %s (%s/%s, chunk %d, distance %.4f)\n", source.Number, source.Title, source.Collection, source.URL, source.Index, source.Distance)
+	}
+	return nil
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// robots are the rules of a robots.txt file that apply to a user agent.
+type robots struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+// robotsAllowAll is used when a site has no robots.txt file.
+var robotsAllowAll = robots{}
+
+// robotsDisallowAll is used when a site's robots.txt file can't be read.
+var robotsDisallowAll = robots{rules: []robotsRule{newRobotsRule(false, "/")}}
+
+func newRobotsRule(allow bool, pattern string) robotsRule {
+	// * matches any characters, and a trailing $ anchors the end of the path.
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	if strings.HasSuffix(expr, `\$`) {
+		expr = strings.TrimSuffix(expr, `\$`) + "$"
+	}
+	return robotsRule{
+		allow:   allow,
+		pattern: pattern,
+		re:      regexp.MustCompile(expr),
+	}
+}
+
+// parseRobots parses a robots.txt file, and returns the rules of the group for
+// the user agent, or the rules for all user agents if there's no such group.
+func parseRobots(r io.Reader, userAgent string) robots {
+	var matched, wildcard robots
+	var foundMatch bool
+	// Consecutive user-agent lines start a group, which the rules that follow
+	// apply to.
+	var inMatch, inWildcard, inAgents bool
+	apply := func(f func(r *robots)) {
+		if inMatch {
+			f(&matched)
+		}
+		if inWildcard {
+			f(&wildcard)
+		}
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		if key == "user-agent" {
+			if !inAgents {
+				inMatch, inWildcard = false, false
+			}
+			inAgents = true
+			agent := strings.ToLower(value)
+			if agent == "*" {
+				inWildcard = true
+			} else if agent != "" && strings.HasPrefix(strings.ToLower(userAgent), agent) {
+				inMatch, foundMatch = true, true
+			}
+			continue
+		}
+		inAgents = false
+		switch key {
+		case "allow", "disallow":
+			// An empty disallow allows everything.
+			if value == "" {
+				continue
+			}
+			rule := newRobotsRule(key == "allow", value)
+			apply(func(r *robots) { r.rules = append(r.rules, rule) })
+		case "crawl-delay":
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil || seconds < 0 {
+				continue
+			}
+			apply(func(r *robots) { r.crawlDelay = time.Duration(seconds * float64(time.Second)) })
+		}
+	}
+	if foundMatch {
+		return matched
+	}
+	return wildcard
+}
+
+// allowed returns true if the path, including any query, can be crawled. The
+// longest matching rule wins, and allow rules win ties.
+func (r robots) allowed(path string) bool {
+	allowed, longest := true, -1
+	for _, rule := range r.rules {
+		if !rule.re.MatchString(path) {
+			continue
+		}
+		if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+			allowed, longest = rule.allow, len(rule.pattern)
+		}
+	}
+	return allowed
+}
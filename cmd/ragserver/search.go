@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/a-h/ragserver/client"
+	"github.com/a-h/ragserver/models"
+)
+
+type SearchCommand struct {
+	RAGServerURL    string   `help:"The URL of the RAG server." env:"RAG_SERVER_URL" default:"http://localhost:9020"`
+	RAGServerAPIKey string   `help:"The API key for the RAG server." env:"RAG_SERVER_API_KEY" default:""`
+	Collections     []string `help:"The collections to search, e.g. personal,team. Defaults to your personal collection."`
+	Query           string   `help:"The full-text search query, e.g. 'title:\"death star\" AND plan*'." short:"q"`
+	Limit           int      `help:"The maximum number of results to return." default:"10"`
+	Offset          int      `help:"The number of results to skip." default:"0"`
+	Pretty          bool     `help:"Pretty print the JSON output." default:"true"`
+	LogLevel        string   `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
+}
+
+func (c SearchCommand) Run(ctx context.Context) (err error) {
+	rsc := client.New(c.RAGServerURL, c.RAGServerAPIKey)
+	resp, err := rsc.SearchPost(ctx, models.SearchPostRequest{
+		Query:       c.Query,
+		Limit:       c.Limit,
+		Offset:      c.Offset,
+		Collections: c.Collections,
+	})
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	if c.Pretty {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(resp)
+}
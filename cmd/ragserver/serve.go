@@ -3,36 +3,77 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/a-h/ragserver/auth"
+	"github.com/a-h/ragserver/collections"
 	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/db/memory"
 	chatpost "github.com/a-h/ragserver/handlers/chat/post"
 	contextpost "github.com/a-h/ragserver/handlers/context/post"
+	documentdelete "github.com/a-h/ragserver/handlers/document/delete"
+	documentget "github.com/a-h/ragserver/handlers/document/get"
+	revisiondiffget "github.com/a-h/ragserver/handlers/document/revision/diff/get"
+	revisionget "github.com/a-h/ragserver/handlers/document/revision/get"
+	revisionpost "github.com/a-h/ragserver/handlers/document/revision/post"
+	revisionsget "github.com/a-h/ragserver/handlers/document/revisions/get"
+	documentsbatchpost "github.com/a-h/ragserver/handlers/documents/batch/post"
+	documentsget "github.com/a-h/ragserver/handlers/documents/get"
 	documentspost "github.com/a-h/ragserver/handlers/documents/post"
+	documentsuploadpost "github.com/a-h/ragserver/handlers/documents/upload/post"
 	querypost "github.com/a-h/ragserver/handlers/query/post"
+	searchpost "github.com/a-h/ragserver/handlers/search/post"
+	"github.com/a-h/ragserver/ingest"
+	"github.com/a-h/ragserver/rerank"
+	"github.com/a-h/ragserver/retrieval"
+	"github.com/a-h/ragserver/tokens"
 	"github.com/rqlite/gorqlite"
 	"github.com/rs/cors"
 	"github.com/tmc/langchaingo/embeddings"
 	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/tmc/langchaingo/textsplitter"
 )
 
 type ServeCommand struct {
-	RqliteURL      string `help:"The URL of the rqlite server." env:"RQLITE_URL" default:"http://localhost:4001"`
-	OllamaURL      string `help:"The URL of the Ollama server." env:"OLLAMA_URL" default:"http://127.0.0.1:11434/"`
-	EmbeddingModel string `help:"The model to use for embeddings." env:"EMBEDDING_MODEL" default:"nomic-embed-text"`
-	ChatModel      string `help:"The model to chat with." env:"CHAT_MODEL" default:"mistral-nemo"`
-	SystemPrompt   string `help:"The system prompt to use." env:"SYSTEM_PROMPT" default:""`
-	UserPrompt     string `help:"The user prompt to use." env:"USER_PROMPT" default:""`
-	MaxContextDocs int    `help:"The maximum number of context documents to use." env:"MAX_CONTEXT_DOCS" default:"5"`
-	ListenAddr     string `help:"The address to listen on." env:"LISTEN_ADDR" default:"localhost:9020"`
-	TLSCertFile    string `help:"The TLS certificate file." env:"TLS_CERT_FILE" default:""`
-	TLSKeyFile     string `help:"The TLS key file." env:"TLS_KEY_FILE" default:""`
-	APIKeysFile    string `help:"The file containing a JSON map of API keys to usernames." env:"API_KEYS_FILE" default:"apikeys.json"`
-	LogLevel       string `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
+	Store                 string        `help:"The store to use. The memory store doesn't require rqlite." enum:"rqlite,memory" env:"STORE" default:"rqlite"`
+	StoreFile             string        `help:"The file that the memory store is loaded from and snapshotted to. If empty, the memory store is not persisted." env:"STORE_FILE" default:""`
+	StoreSnapshotInterval time.Duration `help:"How often the memory store is snapshotted to the store file." env:"STORE_SNAPSHOT_INTERVAL" default:"1m"`
+	RqliteURL             string        `help:"The URL of the rqlite server." env:"RQLITE_URL" default:"http://localhost:4001"`
+	OllamaURL             string        `help:"The URL of the Ollama server." env:"OLLAMA_URL" default:"http://127.0.0.1:11434/"`
+	EmbeddingModel        string        `help:"The model to use for embeddings." env:"EMBEDDING_MODEL" default:"nomic-embed-text"`
+	ChatModel             string        `help:"The model to chat with." env:"CHAT_MODEL" default:"mistral-nemo"`
+	SystemPrompt          string        `help:"The system prompt to use." env:"SYSTEM_PROMPT" default:""`
+	UserPrompt            string        `help:"The user prompt to use." env:"USER_PROMPT" default:""`
+	Chunking              string        `help:"How documents are split. chunk embeds chunks and uses them as context, parent embeds small chunks and uses the sections that contain them as context." enum:"chunk,parent" env:"CHUNKING" default:"chunk"`
+	ChildChunkSize        int           `help:"The size of the chunks that are embedded when chunking is parent." env:"CHILD_CHUNK_SIZE" default:"200"`
+	ParentSectionSize     int           `help:"The maximum size of parent sections. Shorter documents are a single section." env:"PARENT_SECTION_SIZE" default:"2000"`
+	MaxContextDocs        int           `help:"The maximum number of context documents to use." env:"MAX_CONTEXT_DOCS" default:"5"`
+	ContextBudget         int           `help:"The maximum number of tokens in query and chat prompts, including the system prompt, the user prompt and the query. Retrieved chunks are packed into the remaining space. 0 disables the budget." env:"CONTEXT_BUDGET" default:"0"`
+	Tokenizer             string        `help:"How tokens are counted. approximate assumes 4 characters per token, tiktoken uses the tiktoken encoding of the tokenizer model, which is loaded at startup." enum:"approximate,tiktoken" env:"TOKENIZER" default:"approximate"`
+	TokenizerModel        string        `help:"The model whose tiktoken encoding is used to count tokens, e.g. gpt-4. Unknown models use the gpt2 encoding." env:"TOKENIZER_MODEL" default:""`
+	MaxDistance           float64       `help:"Context chunks further than this from the query are dropped. The distance is the cosine distance, from 0 to 2. 0 disables the threshold." env:"MAX_DISTANCE" default:"0"`
+	HybridVectorWeight    float64       `help:"The weight of vector results in hybrid retrieval." env:"HYBRID_VECTOR_WEIGHT" default:"1"`
+	HybridKeywordWeight   float64       `help:"The weight of keyword results in hybrid retrieval." env:"HYBRID_KEYWORD_WEIGHT" default:"1"`
+	HybridRRFK            int           `help:"The reciprocal rank fusion constant used in hybrid retrieval." env:"HYBRID_RRF_K" default:"60"`
+	Reranker              string        `help:"Reranks retrieved chunks. llm uses the chat model to score chunks, http uses a cross-encoder endpoint." enum:"none,llm,http" env:"RERANKER" default:"none"`
+	RerankerURL           string        `help:"The URL of the cross-encoder endpoint used by the http reranker, e.g. http://localhost:8080/rerank." env:"RERANKER_URL" default:""`
+	RerankerCandidates    int           `help:"The number of chunks to retrieve for reranking." env:"RERANKER_CANDIDATES" default:"20"`
+	RerankerConcurrency   int           `help:"The number of chunks that the llm reranker scores at the same time." env:"RERANKER_CONCURRENCY" default:"4"`
+	QueryTransforms       bool          `help:"Allow requests to rewrite queries with the chat model before retrieval, using multi-query or HyDE." env:"QUERY_TRANSFORMS" default:"true" negatable:""`
+	MultiQueryCount       int           `help:"The number of paraphrases that multi-query retrieval generates for each query." env:"MULTI_QUERY_COUNT" default:"3"`
+	ListenAddr            string        `help:"The address to listen on." env:"LISTEN_ADDR" default:"localhost:9020"`
+	TLSCertFile           string        `help:"The TLS certificate file." env:"TLS_CERT_FILE" default:""`
+	TLSKeyFile            string        `help:"The TLS key file." env:"TLS_KEY_FILE" default:""`
+	APIKeysFile           string        `help:"The file containing a JSON map of API keys to usernames." env:"API_KEYS_FILE" default:"apikeys.json"`
+	CollectionsFile       string        `help:"The file containing a JSON map of shared collection names to the users that can read and write them, e.g. {\"team\": {\"read\": [\"*\"], \"write\": [\"alice\"]}}." env:"COLLECTIONS_FILE" default:""`
+	LogLevel              string        `help:"The log level to use." env:"LOG_LEVEL" default:"info"`
 }
 
 const systemPrompt = `You are a trusted advisor that doesn't make up answers. You are provided with context and a question. You always use the context to answer the question. If you don't know the answer, you say that you don't know, and don't try to make up an answer.
@@ -45,6 +86,11 @@ const userPrompt = `Here is the context you need to answer the question:
 
 Please provide a succint response to: %s`
 
+// citationPrompt is added to the system prompt of queries, which return the
+// numbered sources of the context. Chat responses don't include sources, so
+// chat isn't asked to cite them.
+const citationPrompt = `Cite the context that you use by its number, e.g. [1].`
+
 func readFileOrDefault(filename, defaultContent string) (string, error) {
 	if filename == "" {
 		return defaultContent, nil
@@ -57,6 +103,8 @@ func readFileOrDefault(filename, defaultContent string) (string, error) {
 }
 
 func (c ServeCommand) Run(ctx context.Context) (err error) {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	log := getLogger(c.LogLevel)
 	systemPrompt, err := readFileOrDefault(c.SystemPrompt, systemPrompt)
 	if err != nil {
@@ -73,24 +121,6 @@ func (c ServeCommand) Run(ctx context.Context) (err error) {
 		return fmt.Errorf("invalid prompt template: %w", err)
 	}
 
-	log.Info("connecting to database", slog.String("url", c.RqliteURL))
-	databaseURL, err := db.ParseRqliteURL(c.RqliteURL)
-	if err != nil {
-		return fmt.Errorf("failed to parse rqlite URL: %w", err)
-	}
-	log.Info("opening database connection", slog.String("url", databaseURL.DataSourceName()))
-	conn, err := gorqlite.Open(databaseURL.DataSourceName())
-	if err != nil {
-		return fmt.Errorf("failed to open connection: %w", err)
-	}
-	defer conn.Close()
-	queries := db.New(conn)
-
-	log.Info("migrating database schema", slog.String("url", databaseURL.MigrateDatabaseURL()))
-	if err = db.Migrate(databaseURL); err != nil {
-		return fmt.Errorf("failed to migrate database: %w", err)
-	}
-
 	log.Info("creating LLM clients")
 	httpClient := &http.Client{}
 	ec, err := ollama.New(
@@ -105,6 +135,40 @@ func (c ServeCommand) Run(ctx context.Context) (err error) {
 		return fmt.Errorf("failed to create embedder: %w", err)
 	}
 
+	// Check that the embedding model matches the dimensions of the store.
+	log.Info("checking embedding dimensions", slog.String("model", c.EmbeddingModel))
+	probe, err := emb.EmbedQuery(ctx, "ragserver embedding dimension check")
+	if err != nil {
+		return fmt.Errorf("failed to embed dimension check: %w", err)
+	}
+
+	var store db.Store
+	switch c.Store {
+	case "memory":
+		log.Info("opening memory store", slog.String("file", c.StoreFile))
+		var ms *memory.Store
+		ms, err = memory.New(c.StoreFile)
+		if err != nil {
+			return fmt.Errorf("failed to open memory store: %w", err)
+		}
+		defer func() {
+			if saveErr := ms.Save(); saveErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to save memory store: %w", saveErr))
+			}
+		}()
+		if c.StoreFile != "" {
+			go c.snapshot(ctx, log, ms)
+		}
+		store = ms
+	default:
+		conn, queries, err := c.openRqlite(ctx, log, len(probe))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		store = queries
+	}
+
 	llmc, err := ollama.New(
 		ollama.WithModel(c.ChatModel),
 		ollama.WithHTTPClient(httpClient),
@@ -113,20 +177,117 @@ func (c ServeCommand) Run(ctx context.Context) (err error) {
 		return fmt.Errorf("failed to create LLM: %w", err)
 	}
 
+	if c.ContextBudget < 0 {
+		return fmt.Errorf("context budget must not be negative")
+	}
+	var counter tokens.Counter = tokens.Approximate{CharsPerToken: 4}
+	if c.Tokenizer == "tiktoken" {
+		log.Info("loading tokenizer", slog.String("model", c.TokenizerModel))
+		if counter, err = tokens.NewTiktoken(c.TokenizerModel); err != nil {
+			return fmt.Errorf("failed to create tokenizer: %w", err)
+		}
+	}
+
+	if c.MaxDistance < 0 {
+		return fmt.Errorf("max distance must not be negative")
+	}
+	retriever := retrieval.New(emb, store, db.HybridWeights{
+		Vector:  c.HybridVectorWeight,
+		Keyword: c.HybridKeywordWeight,
+		K:       c.HybridRRFK,
+	}).WithMaxDistance(c.MaxDistance)
+
+	switch c.Reranker {
+	case "llm":
+		log.Info("using LLM reranker", slog.String("model", c.ChatModel))
+		retriever = retriever.WithReranker(rerank.NewLLM(llmc, c.RerankerConcurrency), c.RerankerCandidates)
+	case "http":
+		if c.RerankerURL == "" {
+			return fmt.Errorf("the http reranker requires a reranker URL")
+		}
+		log.Info("using HTTP reranker", slog.String("url", c.RerankerURL))
+		retriever = retriever.WithReranker(rerank.NewHTTP(c.RerankerURL), c.RerankerCandidates)
+	}
+
+	if c.QueryTransforms {
+		if c.MultiQueryCount < 1 {
+			return fmt.Errorf("the multi-query count must be positive")
+		}
+		retriever = retriever.WithQueryTransforms(log, llmc, c.MultiQueryCount)
+	}
+
+	ingester := ingest.New(textsplitter.NewMarkdownTextSplitter(), emb, store)
+	if c.Chunking == "parent" {
+		if c.ChildChunkSize < 1 || c.ParentSectionSize < c.ChildChunkSize {
+			return fmt.Errorf("the parent section size must be at least the child chunk size, which must be positive")
+		}
+		log.Info("using parent sections", slog.Int("childChunkSize", c.ChildChunkSize), slog.Int("parentSectionSize", c.ParentSectionSize))
+		childSplitter := textsplitter.NewMarkdownTextSplitter(
+			textsplitter.WithChunkSize(c.ChildChunkSize),
+			textsplitter.WithChunkOverlap(0),
+		)
+		ingester = ingest.New(childSplitter, emb, store).WithParentSections(c.ParentSectionSize)
+		retriever = retriever.WithParentSections()
+	}
+
+	var cols collections.Collections
+	if c.CollectionsFile != "" {
+		if cols, err = collections.LoadFromFile(c.CollectionsFile); err != nil {
+			return fmt.Errorf("failed to load collections: %w", err)
+		}
+		log.Info("loaded shared collections", slog.Int("count", len(cols)))
+	}
+	// Document routes act on the collection in the collection query parameter.
+	inCollection := func(h http.Handler) http.Handler {
+		return collections.New(cols, h)
+	}
+
 	mux := http.NewServeMux()
 
-	dah := documentspost.New(log, emb, queries)
-	mux.Handle("POST /documents", dah)
+	dah := documentspost.New(log, ingester)
+	mux.Handle("POST /documents", inCollection(dah))
+
+	dbp := documentsbatchpost.New(log, ingester)
+	mux.Handle("POST /documents:batch", inCollection(dbp))
+
+	dup := documentsuploadpost.New(log, ingester)
+	mux.Handle("POST /documents:upload", inCollection(dup))
+
+	dgh := documentsget.New(log, store)
+	mux.Handle("GET /documents", inCollection(dgh))
+
+	// Document URLs are path escaped into a single segment.
+	dg := documentget.New(log, store)
+	mux.Handle("GET /documents/{url}", inCollection(dg))
+
+	dd := documentdelete.New(log, store)
+	mux.Handle("DELETE /documents/{url}", inCollection(dd))
+
+	rsg := revisionsget.New(log, store)
+	mux.Handle("GET /documents/{url}/revisions", inCollection(rsg))
 
-	ctxh := contextpost.New(log, emb, llmc, queries, c.MaxContextDocs)
+	rg := revisionget.New(log, store)
+	mux.Handle("GET /documents/{url}/revisions/{revision}", inCollection(rg))
+
+	rdg := revisiondiffget.New(log, store)
+	mux.Handle("GET /documents/{url}/revisions/{revision}/diff", inCollection(rdg))
+
+	// Custom methods, e.g. POST /documents/{url}/revisions/{n}:restore.
+	rp := revisionpost.New(log, store, ingester)
+	mux.Handle("POST /documents/{url}/revisions/{revision}", inCollection(rp))
+
+	ctxh := contextpost.New(log, retriever, c.MaxContextDocs).WithCollections(cols)
 	mux.Handle("POST /context", ctxh)
 
-	chp := chatpost.New(log, llmc)
+	chp := chatpost.New(log, retriever, llmc, c.MaxContextDocs, pf).WithContextBudget(counter, c.ContextBudget).WithCollections(cols)
 	mux.Handle("POST /chat", chp)
 
-	qph := querypost.New(log, emb, llmc, queries, c.MaxContextDocs, systemPrompt, pf)
+	qph := querypost.New(log, retriever, llmc, c.MaxContextDocs, systemPrompt+"\n\n"+citationPrompt, pf).WithContextBudget(counter, c.ContextBudget).WithCollections(cols)
 	mux.Handle("POST /query", qph)
 
+	sph := searchpost.New(log, store).WithCollections(cols)
+	mux.Handle("POST /search", sph)
+
 	apiKeyToUserName, err := auth.LoadFromFile(c.APIKeysFile)
 	if err != nil {
 		return fmt.Errorf("failed to load API keys: %w", err)
@@ -150,7 +311,72 @@ func (c ServeCommand) Run(ctx context.Context) (err error) {
 			MinVersion:   tls.VersionTLS12,
 			Certificates: []tls.Certificate{cert},
 		}
-		return s.ListenAndServeTLS(c.TLSCertFile, c.TLSKeyFile)
 	}
-	return s.ListenAndServe()
+	go func() {
+		<-ctx.Done()
+		log.Info("shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			log.Error("failed to shut down server", slog.Any("error", err))
+		}
+	}()
+	if s.TLSConfig != nil {
+		err = s.ListenAndServeTLS(c.TLSCertFile, c.TLSKeyFile)
+	} else {
+		err = s.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (c ServeCommand) openRqlite(ctx context.Context, log *slog.Logger, dimensions int) (conn *gorqlite.Connection, queries *db.Queries, err error) {
+	log.Info("connecting to database", slog.String("url", c.RqliteURL))
+	databaseURL, err := db.ParseRqliteURL(c.RqliteURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse rqlite URL: %w", err)
+	}
+	log.Info("opening database connection", slog.String("url", databaseURL.DataSourceName()))
+	conn, err = gorqlite.Open(databaseURL.DataSourceName())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open connection: %w", err)
+	}
+	// Execute writes in a transaction, so that document batches are atomic.
+	if err = conn.SetExecutionWithTransaction(true); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to configure connection: %w", err)
+	}
+	queries = db.New(conn)
+
+	log.Info("migrating database schema", slog.String("url", databaseURL.MigrateDatabaseURL()))
+	if err = db.Migrate(databaseURL); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	space, err := queries.EmbeddingSpaceEnsure(ctx, c.EmbeddingModel, dimensions)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to get embedding space: %w", err)
+	}
+	log.Info("using embedding space", slog.String("model", space.Model), slog.Int("dimensions", space.Dimensions), slog.String("table", space.Table))
+	return conn, queries.WithEmbeddingSpace(space), nil
+}
+
+// snapshot periodically saves the memory store until the context is cancelled.
+func (c ServeCommand) snapshot(ctx context.Context, log *slog.Logger, ms *memory.Store) {
+	ticker := time.NewTicker(c.StoreSnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ms.Save(); err != nil {
+				log.Error("failed to snapshot memory store", slog.Any("error", err))
+			}
+		}
+	}
 }
@@ -0,0 +1,190 @@
+package collections
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/a-h/ragserver/auth"
+	"github.com/a-h/respond"
+)
+
+// Personal is the name of each user's own collection. Its documents are
+// stored in a partition named after the user.
+const Personal = "personal"
+
+// partitionPrefix is prepended to the names of shared collections to get
+// their partitions, so that they can't clash with users' partitions.
+const partitionPrefix = "collection:"
+
+// Everyone grants access to all users.
+const Everyone = "*"
+
+var (
+	ErrNotFound  = errors.New("collection not found")
+	ErrForbidden = errors.New("collection is read-only")
+)
+
+type Access int
+
+const (
+	Read Access = iota
+	Write
+)
+
+// Grants lists the users that can access a collection. Users that can write
+// can also read.
+type Grants struct {
+	Read  []string `json:"read"`
+	Write []string `json:"write"`
+}
+
+func (g Grants) allows(user string, access Access) bool {
+	writer := slices.Contains(g.Write, user) || slices.Contains(g.Write, Everyone)
+	if access == Write {
+		return writer
+	}
+	return writer || slices.Contains(g.Read, user) || slices.Contains(g.Read, Everyone)
+}
+
+// Collections maps the names of shared collections to their grants.
+type Collections map[string]Grants
+
+// LoadFromFile loads a JSON map of collection names to grants, e.g.
+// {"team": {"read": ["*"], "write": ["alice"]}}.
+func LoadFromFile(name string) (c Collections, err error) {
+	f, err := os.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err = json.NewDecoder(f).Decode(&c); err != nil {
+		return nil, err
+	}
+	for name := range c {
+		if name == "" || name == Personal {
+			return nil, fmt.Errorf("invalid collection name %q", name)
+		}
+	}
+	return c, nil
+}
+
+// Collection is a collection that a user has access to.
+type Collection struct {
+	Name      string
+	Partition string
+}
+
+// Get returns the named collection if the user has the access. An empty name
+// is the user's personal collection. If the user can't read the collection,
+// ErrNotFound is returned, so that the names of collections aren't revealed.
+func (c Collections) Get(user, name string, access Access) (collection Collection, err error) {
+	if name == "" || name == Personal {
+		return Collection{Name: Personal, Partition: user}, nil
+	}
+	grants, ok := c[name]
+	if !ok || !grants.allows(user, Read) {
+		return collection, fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+	if !grants.allows(user, access) {
+		return collection, fmt.Errorf("%w: %q", ErrForbidden, name)
+	}
+	return Collection{Name: name, Partition: partitionPrefix + name}, nil
+}
+
+// GetAll returns the named collections that the user can read, without
+// duplicates. If no names are given, the user's personal collection is returned.
+func (c Collections) GetAll(user string, names []string) (collections []Collection, err error) {
+	if len(names) == 0 {
+		names = []string{Personal}
+	}
+	for _, name := range names {
+		collection, err := c.Get(user, name, Read)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(collections, collection) {
+			collections = append(collections, collection)
+		}
+	}
+	return collections, nil
+}
+
+// Partitions returns the partitions of the collections.
+func Partitions(collections []Collection) (partitions []string) {
+	partitions = make([]string, len(collections))
+	for i, c := range collections {
+		partitions[i] = c.Partition
+	}
+	return partitions
+}
+
+// Name returns the name of the collection that is stored in the partition.
+func Name(collections []Collection, partition string) string {
+	for _, c := range collections {
+		if c.Partition == partition {
+			return c.Name
+		}
+	}
+	return strings.TrimPrefix(partition, partitionPrefix)
+}
+
+// StatusCode returns the HTTP status code for an error returned by Get.
+func StatusCode(err error) int {
+	if errors.Is(err, ErrForbidden) {
+		return http.StatusForbidden
+	}
+	if errors.Is(err, ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// New returns middleware that selects the collection named by the collection
+// query parameter, or the user's personal collection. GET requests need read
+// access, and other requests need write access.
+func New(collections Collections, next http.Handler) *Handler {
+	return &Handler{
+		Next:        next,
+		Collections: collections,
+	}
+}
+
+type Handler struct {
+	Next        http.Handler
+	Collections Collections
+}
+
+type partitionContextKey int
+
+const partitionKey partitionContextKey = 0
+
+// GetPartition returns the partition of the collection selected by the middleware.
+func GetPartition(r *http.Request) (partition string, ok bool) {
+	partition, ok = r.Context().Value(partitionKey).(string)
+	return
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUser(r)
+	if !ok {
+		http.Error(w, "authentication not provided", http.StatusUnauthorized)
+		return
+	}
+	access := Write
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		access = Read
+	}
+	collection, err := h.Collections.Get(user, r.URL.Query().Get("collection"), access)
+	if err != nil {
+		respond.WithError(w, err.Error(), StatusCode(err))
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), partitionKey, collection.Partition))
+	h.Next.ServeHTTP(w, r)
+}
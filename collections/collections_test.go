@@ -0,0 +1,177 @@
+package collections
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/a-h/ragserver/auth"
+)
+
+var testCollections = Collections{
+	"team":     {Read: []string{Everyone}, Write: []string{"alice"}},
+	"private":  {Read: []string{"alice"}},
+	"handbook": {Write: []string{"bob"}},
+}
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name              string
+		user              string
+		collection        string
+		access            Access
+		expectedPartition string
+		expectedErr       error
+	}{
+		{
+			name:              "the personal collection is the user's partition",
+			user:              "alice",
+			collection:        Personal,
+			access:            Write,
+			expectedPartition: "alice",
+		},
+		{
+			name:              "an empty name is the personal collection",
+			user:              "bob",
+			access:            Write,
+			expectedPartition: "bob",
+		},
+		{
+			name:              "everyone can read",
+			user:              "bob",
+			collection:        "team",
+			access:            Read,
+			expectedPartition: "collection:team",
+		},
+		{
+			name:        "readers can't write",
+			user:        "bob",
+			collection:  "team",
+			access:      Write,
+			expectedErr: ErrForbidden,
+		},
+		{
+			name:              "writers can write",
+			user:              "alice",
+			collection:        "team",
+			access:            Write,
+			expectedPartition: "collection:team",
+		},
+		{
+			name:              "writers can read",
+			user:              "bob",
+			collection:        "handbook",
+			access:            Read,
+			expectedPartition: "collection:handbook",
+		},
+		{
+			name:        "collections that can't be read are not found",
+			user:        "bob",
+			collection:  "private",
+			access:      Read,
+			expectedErr: ErrNotFound,
+		},
+		{
+			name:        "unknown collections are not found",
+			user:        "alice",
+			collection:  "unknown",
+			access:      Read,
+			expectedErr: ErrNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collection, err := testCollections.Get(tt.user, tt.collection, tt.access)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if collection.Partition != tt.expectedPartition {
+				t.Errorf("expected partition %q, got %q", tt.expectedPartition, collection.Partition)
+			}
+		})
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	collections, err := testCollections.GetAll("alice", []string{"team", Personal, "team"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Collection{
+		{Name: "team", Partition: "collection:team"},
+		{Name: Personal, Partition: "alice"},
+	}
+	if len(collections) != len(expected) || collections[0] != expected[0] || collections[1] != expected[1] {
+		t.Errorf("expected %v, got %v", expected, collections)
+	}
+	if name := Name(collections, "alice"); name != Personal {
+		t.Errorf("expected the partition to be named %q, got %q", Personal, name)
+	}
+	if _, err = testCollections.GetAll("bob", []string{"team", "private"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name              string
+		method            string
+		url               string
+		expectedStatus    int
+		expectedPartition string
+	}{
+		{
+			name:              "no collection uses the personal collection",
+			method:            http.MethodPost,
+			url:               "/documents",
+			expectedStatus:    http.StatusOK,
+			expectedPartition: "user-1",
+		},
+		{
+			name:              "GET requests need read access",
+			method:            http.MethodGet,
+			url:               "/documents?collection=team",
+			expectedStatus:    http.StatusOK,
+			expectedPartition: "collection:team",
+		},
+		{
+			name:           "other requests need write access",
+			method:         http.MethodDelete,
+			url:            "/documents/a?collection=team",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "unknown collections return 404",
+			method:         http.MethodGet,
+			url:            "/documents?collection=unknown",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var partition string
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var ok bool
+				partition, ok = GetPartition(r)
+				if !ok {
+					t.Error("expected partition to be set")
+				}
+				w.WriteHeader(http.StatusOK)
+			})
+			handler := auth.New(map[string]string{"test-api-key": "user-1"}, New(testCollections, h))
+
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			req.Header.Set("Authorization", "Bearer test-api-key")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if partition != tt.expectedPartition {
+				t.Errorf("expected partition %q, got %q", tt.expectedPartition, partition)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rqlite/gorqlite"
+)
+
+// DocumentChunks returns the chunks of the document stored in the embedding
+// space, in index order. If the document doesn't exist, no chunks are returned.
+func (q *Queries) DocumentChunks(ctx context.Context, args DocumentID) (chunks []Chunk, err error) {
+	result, err := q.conn.QueryOneParameterizedContext(ctx, gorqlite.ParameterizedStatement{
+		Query: `select c.text, vec_to_json(c.embedding)
+from ` + q.space.Table + ` c
+inner join document d on d.rowid = c.document_rowid
+where c.partition = ? and d.partition = ? and d.url = ?
+order by c.idx`,
+		Arguments: []any{args.Partition, args.Partition, args.URL},
+	})
+	if err != nil {
+		return chunks, err
+	}
+	for result.Next() {
+		var chunk Chunk
+		var embeddingJSON string
+		if err = result.Scan(&chunk.Text, &embeddingJSON); err != nil {
+			return chunks, err
+		}
+		if err = json.Unmarshal([]byte(embeddingJSON), &chunk.Embedding); err != nil {
+			return chunks, fmt.Errorf("failed to unmarshal embedding: %w", err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// ChunkHash returns the hash of the text of a chunk, which is stored with the
+// chunk so that unchanged chunks can be found without reading their embeddings.
+func ChunkHash(text string) string {
+	h := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(h[:])
+}
+
+// DocumentHashes are the hashes of a stored document, used to find changes.
+type DocumentHashes struct {
+	// RowID of the document.
+	RowID       int64
+	ContentHash string
+	// ChunkHashes are the hashes of the text of the document's chunks stored
+	// in the embedding space, in index order, see ChunkHash.
+	ChunkHashes []string
+}
+
+// DocumentHashes returns the hashes of the documents that exist, keyed by
+// document ID. The documents and their chunks are read in a single request.
+func (q *Queries) DocumentHashes(ctx context.Context, ids []DocumentID) (hashes map[DocumentID]DocumentHashes, err error) {
+	hashes = make(map[DocumentID]DocumentHashes, len(ids))
+	if len(ids) == 0 {
+		return hashes, nil
+	}
+	args := make([]any, len(ids))
+	idsByPartition := map[string][]any{}
+	var partitions []string
+	for i, id := range ids {
+		args[i] = id.String()
+		if _, ok := idsByPartition[id.Partition]; !ok {
+			partitions = append(partitions, id.Partition)
+		}
+		idsByPartition[id.Partition] = append(idsByPartition[id.Partition], id.String())
+	}
+	statements := []gorqlite.ParameterizedStatement{
+		{
+			Query:     `select rowid, partition, url, content_hash from document where id in ` + inSQL(len(args)),
+			Arguments: args,
+		},
+	}
+	// The chunks are read a partition at a time, so that only the chunks in
+	// the partition are scanned.
+	for _, partition := range partitions {
+		partitionIDs := idsByPartition[partition]
+		statements = append(statements, gorqlite.ParameterizedStatement{
+			// Chunks written before hashes were stored have an empty hash, so
+			// their text is read instead.
+			Query: `select document_rowid, chunk_hash, case when chunk_hash = '' then text else '' end
+from ` + q.space.Table + `
+where partition = ? and document_rowid in (select rowid from document where id in ` + inSQL(len(partitionIDs)) + `)
+order by document_rowid, idx`,
+			Arguments: append([]any{partition}, partitionIDs...),
+		})
+	}
+	results, err := q.conn.QueryParameterizedContext(ctx, statements)
+	if err != nil {
+		return hashes, err
+	}
+	idsByRowID := make(map[int64]DocumentID, len(ids))
+	documents := results[0]
+	for documents.Next() {
+		var id DocumentID
+		var h DocumentHashes
+		if err = documents.Scan(&h.RowID, &id.Partition, &id.URL, &h.ContentHash); err != nil {
+			return hashes, err
+		}
+		idsByRowID[h.RowID] = id
+		hashes[id] = h
+	}
+	for _, chunks := range results[1:] {
+		for chunks.Next() {
+			var rowID int64
+			var hash, text string
+			if err = chunks.Scan(&rowID, &hash, &text); err != nil {
+				return hashes, err
+			}
+			if hash == "" {
+				hash = ChunkHash(text)
+			}
+			id, ok := idsByRowID[rowID]
+			if !ok {
+				// The document was created between the two reads.
+				continue
+			}
+			h := hashes[id]
+			h.ChunkHashes = append(h.ChunkHashes, hash)
+			hashes[id] = h
+		}
+	}
+	return hashes, nil
+}
+
+// ChunkID identifies a chunk of a document.
+type ChunkID struct {
+	Partition string
+	// DocumentRowID is the row ID of the document.
+	DocumentRowID int64
+	// Index of the chunk in the document.
+	Index int
+}
+
+// DocumentChunkEmbeddings returns the embeddings of the chunks stored in the
+// embedding space. Chunks that don't exist are not included.
+func (q *Queries) DocumentChunkEmbeddings(ctx context.Context, ids []ChunkID) (embeddings map[ChunkID][]float32, err error) {
+	embeddings = make(map[ChunkID][]float32, len(ids))
+	if len(ids) == 0 {
+		return embeddings, nil
+	}
+	requested := make(map[ChunkID]bool, len(ids))
+	idsByPartition := map[string][]ChunkID{}
+	var partitions []string
+	for _, id := range ids {
+		requested[id] = true
+		if _, ok := idsByPartition[id.Partition]; !ok {
+			partitions = append(partitions, id.Partition)
+		}
+		idsByPartition[id.Partition] = append(idsByPartition[id.Partition], id)
+	}
+	// The chunks are read a partition at a time. The rows that match the
+	// document and index lists, but weren't requested, are skipped.
+	var statements []gorqlite.ParameterizedStatement
+	for _, partition := range partitions {
+		var rowIDs, indexes []any
+		seenRowIDs, seenIndexes := map[int64]bool{}, map[int]bool{}
+		for _, id := range idsByPartition[partition] {
+			if !seenRowIDs[id.DocumentRowID] {
+				seenRowIDs[id.DocumentRowID] = true
+				rowIDs = append(rowIDs, id.DocumentRowID)
+			}
+			if !seenIndexes[id.Index] {
+				seenIndexes[id.Index] = true
+				indexes = append(indexes, id.Index)
+			}
+		}
+		statements = append(statements, gorqlite.ParameterizedStatement{
+			Query: `select partition, document_rowid, idx, vec_to_json(embedding)
+from ` + q.space.Table + `
+where partition = ? and document_rowid in ` + inSQL(len(rowIDs)) + ` and idx in ` + inSQL(len(indexes)),
+			Arguments: slices.Concat([]any{partition}, rowIDs, indexes),
+		})
+	}
+	results, err := q.conn.QueryParameterizedContext(ctx, statements)
+	if err != nil {
+		return embeddings, err
+	}
+	for _, result := range results {
+		for result.Next() {
+			var id ChunkID
+			var embeddingJSON string
+			if err = result.Scan(&id.Partition, &id.DocumentRowID, &id.Index, &embeddingJSON); err != nil {
+				return embeddings, err
+			}
+			if !requested[id] {
+				continue
+			}
+			var embedding []float32
+			if err = json.Unmarshal([]byte(embeddingJSON), &embedding); err != nil {
+				return embeddings, fmt.Errorf("failed to unmarshal embedding: %w", err)
+			}
+			embeddings[id] = embedding
+		}
+	}
+	return embeddings, nil
+}
+
+// inSQL returns a parenthesised list of n placeholders, for use with in.
+func inSQL(n int) string {
+	return `(` + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + `)`
+}
+
+type DocumentChunkRangeArgs struct {
+	Partition string
+	// RowID of the document.
+	RowID int64
+	// From and To are the first and last chunk indexes, inclusive.
+	From int64
+	To   int64
+}
+
+// DocumentChunkRange returns the text of the document's chunks between the
+// From and To indexes, in index order.
+func (q *Queries) DocumentChunkRange(ctx context.Context, args DocumentChunkRangeArgs) (texts []string, err error) {
+	result, err := q.conn.QueryOneParameterizedContext(ctx, gorqlite.ParameterizedStatement{
+		Query: `select text
+from ` + q.space.Table + `
+where partition = ? and document_rowid = ? and idx between ? and ?
+order by idx`,
+		Arguments: []any{args.Partition, args.RowID, args.From, args.To},
+	})
+	if err != nil {
+		return texts, err
+	}
+	for result.Next() {
+		var text string
+		if err = result.Scan(&text); err != nil {
+			return texts, err
+		}
+		texts = append(texts, text)
+	}
+	return texts, nil
+}
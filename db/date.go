@@ -0,0 +1,45 @@
+package db
+
+import (
+	"time"
+)
+
+// documentDateSQL is the SQL expression of the date of a document in the
+// document table, aliased as d, see Document.Date.
+const documentDateSQL = "coalesce(d.published_at, d.created_at)"
+
+// DateRange limits results to documents dated within the range, see
+// Document.Date. Zero times leave the range open.
+type DateRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+func (r DateRange) IsZero() bool {
+	return r.Since.IsZero() && r.Until.IsZero()
+}
+
+// Contains returns true if the date is within the range.
+func (r DateRange) Contains(date time.Time) bool {
+	if !r.Since.IsZero() && date.Before(r.Since) {
+		return false
+	}
+	if !r.Until.IsZero() && date.After(r.Until) {
+		return false
+	}
+	return true
+}
+
+// SQL returns a where clause fragment that starts with " and " for each bound
+// of the range, and its arguments. The date is a SQL expression.
+func (r DateRange) SQL(date string) (clause string, args []any) {
+	if !r.Since.IsZero() {
+		clause += " and julianday(" + date + ") >= julianday(?)"
+		args = append(args, r.Since.UTC().Format(time.RFC3339Nano))
+	}
+	if !r.Until.IsZero() {
+		clause += " and julianday(" + date + ") <= julianday(?)"
+		args = append(args, r.Until.UTC().Format(time.RFC3339Nano))
+	}
+	return clause, args
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/rqlite/gorqlite"
@@ -11,12 +12,14 @@ import (
 
 func New(conn *gorqlite.Connection) *Queries {
 	return &Queries{
-		conn: conn,
+		conn:  conn,
+		space: DefaultEmbeddingSpace,
 	}
 }
 
 type Queries struct {
-	conn *gorqlite.Connection
+	conn  *gorqlite.Connection
+	space EmbeddingSpace
 }
 
 type DocumentID struct {
@@ -28,71 +31,119 @@ func (d DocumentID) String() string {
 	return fmt.Sprintf("%s:%s", d.Partition, d.URL)
 }
 
-func newDocumentUpsertRowIDArgs(id DocumentID, title, summary string, createdAt, lastUpdatedAt time.Time) documentUpsertRowIDArgs {
-	return documentUpsertRowIDArgs{
-		DocumentID:    id,
-		Title:         title,
-		Summary:       summary,
-		CreatedAt:     createdAt,
-		LastUpdatedAt: lastUpdatedAt,
+func documentUpsertStatement(doc Document) (stmt gorqlite.ParameterizedStatement, err error) {
+	metadataJSON, err := marshalMetadata(doc.Metadata)
+	if err != nil {
+		return stmt, err
 	}
-}
-
-type documentUpsertRowIDArgs struct {
-	DocumentID
-	Title         string
-	Summary       string
-	CreatedAt     time.Time
-	LastUpdatedAt time.Time
-}
-
-func (q *Queries) documentUpsertRowID(ctx context.Context, args documentUpsertRowIDArgs) (rowID int64, err error) {
-	stmt := gorqlite.ParameterizedStatement{
-		Query: `insert into document (id, partition, url, title, summary, created_at, last_updated_at)
-values (?, ?, ?, ?, ?, ?, ?)
+	return gorqlite.ParameterizedStatement{
+		Query: `insert into document (id, partition, url, title, summary, metadata, content_hash, created_at, last_updated_at, published_at)
+values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 on conflict(id) do update
 set
     partition = excluded.partition,
     url = excluded.url,
     title = excluded.title,
     summary = excluded.summary,
-    last_updated_at = excluded.last_updated_at
+    metadata = excluded.metadata,
+    content_hash = excluded.content_hash,
+    last_updated_at = excluded.last_updated_at,
+    published_at = excluded.published_at
 `,
-		Arguments: []any{args.DocumentID.String(), args.Partition, args.URL, args.Title, args.Summary, args.CreatedAt, args.LastUpdatedAt},
-	}
-	_, err = q.conn.WriteOneParameterizedContext(ctx, stmt)
-	if err != nil {
-		return 0, err
-	}
+		Arguments: []any{doc.DocumentID.String(), doc.Partition, doc.URL, doc.Title, doc.Summary, metadataJSON, doc.ContentHash, doc.CreatedAt, doc.LastUpdatedAt, nullTime(doc.PublishedAt)},
+	}, nil
+}
 
-	// Read the row ID.
-	stmt = gorqlite.ParameterizedStatement{
-		Query:     `select rowid from document where id = ?`,
-		Arguments: []any{args.DocumentID.String()},
+// documentRowIDSQL is a subquery that selects the row ID of the document with
+// the id argument, so that statements that depend on the row ID can be
+// written in the same request as the upsert.
+const documentRowIDSQL = `(select rowid from document where id = ?)`
+
+// documentRowIDs returns the row IDs of the documents, keyed by DocumentID.String().
+func (q *Queries) documentRowIDs(ctx context.Context, ids []DocumentID) (rowIDs map[string]int64, err error) {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id.String()
 	}
-	result, err := q.conn.QueryOneParameterizedContext(ctx, stmt)
+	result, err := q.conn.QueryOneParameterizedContext(ctx, gorqlite.ParameterizedStatement{
+		Query:     `select id, rowid from document where id in ` + inSQL(len(ids)),
+		Arguments: args,
+	})
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	if !result.Next() {
-		return 0, fmt.Errorf("expected a row ID")
+	rowIDs = make(map[string]int64, len(ids))
+	for result.Next() {
+		var id string
+		var rowID int64
+		if err = result.Scan(&id, &rowID); err != nil {
+			return nil, err
+		}
+		rowIDs[id] = rowID
 	}
-	err = result.Scan(&rowID)
-	return rowID, err
+	return rowIDs, nil
 }
 
 type Document struct {
 	DocumentID
-	Title         string
-	Text          string
-	Summary       string
+	Title   string
+	Text    string
+	Summary string
+	// Metadata is a set of key/value pairs that can be filtered on, see Filter.
+	Metadata map[string]any
+	// ContentHash is a hash of the document content, used to detect changes.
+	ContentHash   string
 	CreatedAt     time.Time
 	LastUpdatedAt time.Time
+	// PublishedAt is the time that the source published the document, if known.
+	PublishedAt time.Time
+}
+
+// Date is the time that the document was published, or the time that it was
+// created if the publication time isn't known.
+func (d Document) Date() time.Time {
+	if !d.PublishedAt.IsZero() {
+		return d.PublishedAt
+	}
+	return d.CreatedAt
+}
+
+// nullTime returns nil for the zero time, so that it's stored as null.
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+func marshalMetadata(metadata map[string]any) (string, error) {
+	if len(metadata) == 0 {
+		return "{}", nil
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	return string(metadataJSON), nil
+}
+
+func unmarshalMetadata(metadataJSON string) (metadata map[string]any, err error) {
+	if err = json.Unmarshal([]byte(metadataJSON), &metadata); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+	}
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	return metadata, nil
 }
 
 type DocumentPutArgs struct {
 	Document Document
 	Chunks   []Chunk
+	// Sections group the chunks into parent sections, if set.
+	Sections []Section
+	// User that submitted the document, recorded in the revision history.
+	User string
 }
 
 type Chunk struct {
@@ -100,57 +151,119 @@ type Chunk struct {
 	Embedding []float32
 }
 
+// DocumentPut upserts the document, and replaces its chunks in the embedding space.
 func (q *Queries) DocumentPut(ctx context.Context, args DocumentPutArgs) (id int64, err error) {
-	id, err = q.documentUpsertRowID(ctx, newDocumentUpsertRowIDArgs(args.Document.DocumentID, args.Document.Title, args.Document.Summary, args.Document.CreatedAt, args.Document.LastUpdatedAt))
+	ids, err := q.DocumentPutBatch(ctx, []DocumentPutArgs{args})
 	if err != nil {
-		return id, fmt.Errorf("failed to upsert document row id: %w", err)
+		return 0, err
 	}
-	if id == 0 {
-		return id, fmt.Errorf("expected a non-zero row ID")
+	return ids[0], nil
+}
+
+// DocumentPutBatch puts the documents in a single write request. The
+// connection must be configured to execute writes in a transaction for the
+// batch to be atomic.
+func (q *Queries) DocumentPutBatch(ctx context.Context, args []DocumentPutArgs) (ids []int64, err error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	now := time.Now().UTC()
+	var statements []gorqlite.ParameterizedStatement
+	for _, a := range args {
+		stmts, err := q.documentPutStatements(a, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create statements for %q: %w", a.Document.URL, err)
+		}
+		statements = append(statements, stmts...)
+	}
+	if _, err = q.conn.WriteParameterizedContext(ctx, statements); err != nil {
+		return nil, err
 	}
 
-	statements := make([]gorqlite.ParameterizedStatement, len(args.Chunks)+2)
+	documentIDs := make([]DocumentID, len(args))
+	for i, a := range args {
+		documentIDs[i] = a.Document.DocumentID
+	}
+	rowIDs, err := q.documentRowIDs(ctx, documentIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read row IDs: %w", err)
+	}
+	ids = make([]int64, len(args))
+	for i, id := range documentIDs {
+		if ids[i] = rowIDs[id.String()]; ids[i] == 0 {
+			return nil, fmt.Errorf("expected a non-zero row ID for %q", id.URL)
+		}
+	}
+	return ids, nil
+}
+
+func (q *Queries) documentPutStatements(args DocumentPutArgs, now time.Time) (statements []gorqlite.ParameterizedStatement, err error) {
+	id := args.Document.DocumentID.String()
+	upsert, err := documentUpsertStatement(args.Document)
+	if err != nil {
+		return nil, err
+	}
+	statements = append(statements, upsert)
+	// Delete the previous chunks.
+	statements = append(statements, gorqlite.ParameterizedStatement{
+		Query:     `delete from ` + q.space.Table + ` where document_rowid = ` + documentRowIDSQL,
+		Arguments: []any{id},
+	})
 	for chunkIndex, chunk := range args.Chunks {
 		embeddingJSON, err := json.Marshal(chunk.Embedding)
 		if err != nil {
-			return id, fmt.Errorf("failed to marshal embedding: %w", err)
-		}
-		statements[chunkIndex] = gorqlite.ParameterizedStatement{
-			Query:     `insert or replace into document_chunk_vec (document_rowid, partition, idx, text, embedding) values (?, ?, ?, ?, ?)`,
-			Arguments: []any{id, args.Document.Partition, chunkIndex, chunk.Text, string(embeddingJSON)},
+			return nil, fmt.Errorf("failed to marshal embedding: %w", err)
 		}
+		statements = append(statements, gorqlite.ParameterizedStatement{
+			Query:     `insert into ` + q.space.Table + ` (document_rowid, partition, idx, text, model, chunk_hash, embedding) values (` + documentRowIDSQL + `, ?, ?, ?, ?, ?, ?)`,
+			Arguments: []any{id, args.Document.Partition, chunkIndex, chunk.Text, q.space.Model, ChunkHash(chunk.Text), string(embeddingJSON)},
+		})
 	}
-	// Delete excess rows.
-	statements[len(statements)-2] = gorqlite.ParameterizedStatement{
-		Query:     `delete from document_chunk_vec where document_rowid = ? and idx > ?`,
-		Arguments: []any{id, len(args.Chunks) - 1},
-	}
+	statements = append(statements, documentSectionStatements(id, args.Sections)...)
 	// Insert into the FTS table.
-	statements[len(statements)-1] = gorqlite.ParameterizedStatement{
-		Query:     `insert or replace into document_fts (rowid, partition, url, title, text, summary) values (?, ?, ?, ?, ?, ?)`,
+	statements = append(statements, gorqlite.ParameterizedStatement{
+		Query:     `insert or replace into document_fts (rowid, partition, url, title, text, summary) values (` + documentRowIDSQL + `, ?, ?, ?, ?, ?)`,
 		Arguments: []any{id, args.Document.Partition, args.Document.URL, args.Document.Title, args.Document.Text, args.Document.Summary},
+	})
+	// Record the revision.
+	revision, err := documentRevisionInsertStatement(args.Document, args.User, now)
+	if err != nil {
+		return nil, err
 	}
-	if _, err = q.conn.WriteParameterizedContext(ctx, statements); err != nil {
-		return id, err
-	}
-	return id, nil
+	return append(statements, revision), nil
 }
 
 func (q *Queries) DocumentDelete(ctx context.Context, args DocumentID) (err error) {
-	statements := []gorqlite.ParameterizedStatement{
+	// Delete the chunks from every embedding space.
+	spaces, err := q.EmbeddingSpaceList(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list embedding spaces: %w", err)
+	}
+	var statements []gorqlite.ParameterizedStatement
+	for _, space := range spaces {
+		statements = append(statements, gorqlite.ParameterizedStatement{
+			Query:     `delete from ` + space.Table + ` where document_rowid in (select rowid from document where partition = ? and url = ?)`,
+			Arguments: []any{args.Partition, args.URL},
+		})
+	}
+	statements = append(statements, []gorqlite.ParameterizedStatement{
 		{
-			Query:     `delete from document_chunk_vec where document_rowid in (select rowid from document where partition = ? and url = ?)`,
+			Query:     `delete from document_fts where rowid in (select rowid from document where partition = ? and url = ?)`,
 			Arguments: []any{args.Partition, args.URL},
 		},
 		{
-			Query:     `delete from document_fts where rowid in (select rowid from document where partition = ? and url = ?)`,
+			Query:     `delete from document_section where document_rowid in (select rowid from document where partition = ? and url = ?)`,
+			Arguments: []any{args.Partition, args.URL},
+		},
+		{
+			Query:     `delete from document_revision where partition = ? and url = ?`,
 			Arguments: []any{args.Partition, args.URL},
 		},
 		{
 			Query:     `delete from document where partition = ? and url = ?`,
 			Arguments: []any{args.Partition, args.URL},
 		},
-	}
+	}...)
 	if _, err = q.conn.WriteParameterizedContext(ctx, statements); err != nil {
 		return err
 	}
@@ -159,7 +272,7 @@ func (q *Queries) DocumentDelete(ctx context.Context, args DocumentID) (err erro
 
 func (q *Queries) DocumentGet(ctx context.Context, args DocumentID) (doc Document, ok bool, err error) {
 	stmt := gorqlite.ParameterizedStatement{
-		Query:     "select document.partition, document.url, document.title, document_fts.text, document.summary, document.created_at, document.last_updated_at from document_fts inner join document on document.rowid = document_fts.rowid where document_fts.partition = ? and document_fts.url = ?",
+		Query:     "select document.partition, document.url, document.title, document_fts.text, document.summary, document.metadata, document.content_hash, document.created_at, document.last_updated_at, document.published_at from document_fts inner join document on document.rowid = document_fts.rowid where document_fts.partition = ? and document_fts.url = ?",
 		Arguments: []any{args.Partition, args.URL},
 	}
 	result, err := q.conn.QueryOneParameterizedContext(ctx, stmt)
@@ -169,16 +282,70 @@ func (q *Queries) DocumentGet(ctx context.Context, args DocumentID) (doc Documen
 	if !result.Next() {
 		return Document{}, false, nil
 	}
-	if err = result.Scan(&doc.Partition, &doc.URL, &doc.Title, &doc.Text, &doc.Summary, &doc.CreatedAt, &doc.LastUpdatedAt); err != nil {
+	var metadataJSON string
+	if err = result.Scan(&doc.Partition, &doc.URL, &doc.Title, &doc.Text, &doc.Summary, &metadataJSON, &doc.ContentHash, &doc.CreatedAt, &doc.LastUpdatedAt, &doc.PublishedAt); err != nil {
+		return Document{}, false, err
+	}
+	if doc.Metadata, err = unmarshalMetadata(metadataJSON); err != nil {
 		return Document{}, false, err
 	}
 	return doc, true, nil
 }
 
+type DocumentListArgs struct {
+	Partition string
+	// URLPrefix limits the results to documents with URLs that start with the prefix.
+	URLPrefix string
+	// UpdatedSince limits the results to documents updated at or after the time, if set.
+	UpdatedSince time.Time
+	// After is the URL of the last document in the previous page.
+	After string
+	Limit int
+}
+
+// DocumentList returns documents in URL order. The Text field of the documents is not populated.
+func (q *Queries) DocumentList(ctx context.Context, args DocumentListArgs) (docs []Document, err error) {
+	query := `select partition, url, title, summary, metadata, created_at, last_updated_at, published_at
+from document
+where partition = ? and url > ? and substr(url, 1, length(?)) = ?`
+	arguments := []any{args.Partition, args.After, args.URLPrefix, args.URLPrefix}
+	if !args.UpdatedSince.IsZero() {
+		query += ` and julianday(last_updated_at) >= julianday(?)`
+		arguments = append(arguments, args.UpdatedSince.UTC().Format(time.RFC3339Nano))
+	}
+	query += `
+order by url
+limit ?`
+	arguments = append(arguments, args.Limit)
+	result, err := q.conn.QueryOneParameterizedContext(ctx, gorqlite.ParameterizedStatement{
+		Query:     query,
+		Arguments: arguments,
+	})
+	if err != nil {
+		return docs, err
+	}
+	for result.Next() {
+		var doc Document
+		var metadataJSON string
+		if err = result.Scan(&doc.Partition, &doc.URL, &doc.Title, &doc.Summary, &metadataJSON, &doc.CreatedAt, &doc.LastUpdatedAt, &doc.PublishedAt); err != nil {
+			return docs, err
+		}
+		if doc.Metadata, err = unmarshalMetadata(metadataJSON); err != nil {
+			return docs, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
 type DocumentSelectNearestArgs struct {
 	Partition string
 	Embedding []float32
 	Limit     int
+	// Filter limits the results to chunks with matching metadata.
+	Filter Filter
+	// Dates limits the results to chunks of documents dated within the range.
+	Dates DateRange
 }
 
 type DocumentSelectNearestResult struct {
@@ -191,6 +358,10 @@ type DocumentSelectNearestResult struct {
 	URL       string
 	Title     string
 	Summary   string
+	// Score is set by keyword and hybrid searches, higher is better.
+	Score float64
+	// Date of the document, see Document.Date.
+	Date time.Time
 }
 
 /*
@@ -215,11 +386,20 @@ func (q *Queries) DocumentNearest(ctx context.Context, args DocumentSelectNeares
 	if err != nil {
 		return docs, fmt.Errorf("failed to marshal input embedding: %w", err)
 	}
+	// Filter on the document metadata and dates before the KNN limit is applied.
+	var filterSQL string
+	var filterArgs []any
+	metadataSQL, metadataArgs := args.Filter.SQL("d.metadata")
+	dateSQL, dateArgs := args.Dates.SQL(documentDateSQL)
+	if metadataSQL != "" || dateSQL != "" {
+		filterSQL = " and document_rowid in (select d.rowid from document d where d.partition = ?" + metadataSQL + dateSQL + ")"
+		filterArgs = slices.Concat([]any{args.Partition}, metadataArgs, dateArgs)
+	}
 	stmt := gorqlite.ParameterizedStatement{
 		Query: `with limited_dcv as (
   select document_rowid, partition, idx, text, embedding, distance
-  from document_chunk_vec
-  where partition = ? and embedding match ?
+  from ` + q.space.Table + `
+  where partition = ? and embedding match ?` + filterSQL + `
   order by distance asc
   limit ?
 )
@@ -232,10 +412,11 @@ select
   ld.distance,
   d.url,
   d.title,
-  d.summary
+  d.summary,
+  ` + documentDateSQL + `
 from limited_dcv ld
 left join document d on d.rowid = ld.document_rowid;`,
-		Arguments: []any{args.Partition, string(inputEmbeddingJSON), args.Limit},
+		Arguments: slices.Concat([]any{args.Partition, string(inputEmbeddingJSON)}, filterArgs, []any{args.Limit}),
 	}
 	result, err := q.conn.QueryOneParameterizedContext(ctx, stmt)
 	if err != nil {
@@ -244,7 +425,7 @@ left join document d on d.rowid = ld.document_rowid;`,
 	for result.Next() {
 		var doc DocumentSelectNearestResult
 		var embeddingJSON string
-		if err = result.Scan(&doc.RowID, &doc.Partition, &doc.Index, &doc.Text, &embeddingJSON, &doc.Distance, &doc.URL, &doc.Title, &doc.Summary); err != nil {
+		if err = result.Scan(&doc.RowID, &doc.Partition, &doc.Index, &doc.Text, &embeddingJSON, &doc.Distance, &doc.URL, &doc.Title, &doc.Summary, &doc.Date); err != nil {
 			return docs, err
 		}
 		if err = json.Unmarshal([]byte(embeddingJSON), &doc.Embedding); err != nil {
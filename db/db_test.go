@@ -2,12 +2,14 @@ package db_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/db/storetest"
 	"github.com/google/go-cmp/cmp"
 	"github.com/rqlite/gorqlite"
 )
@@ -27,6 +29,10 @@ func initConnection() (err error) {
 			err = fmt.Errorf("failed to open connection: %w", err)
 			return
 		}
+		if err = conn.SetExecutionWithTransaction(true); err != nil {
+			err = fmt.Errorf("failed to configure connection: %w", err)
+			return
+		}
 		if err = db.Migrate(databaseURL); err != nil {
 			err = fmt.Errorf("failed to migrate database: %w", err)
 			return
@@ -154,6 +160,225 @@ func TestDocument(t *testing.T) {
 			t.Fatalf("unexpected document: %v", diff)
 		}
 	})
+
+	t.Run("Can get the content hash and chunks", func(t *testing.T) {
+		hashed := article1
+		hashed.ContentHash = "abc"
+		id, err := q.DocumentPut(ctx, db.DocumentPutArgs{
+			Document: hashed,
+			Chunks:   article1Chunks,
+		})
+		if err != nil {
+			t.Fatalf("failed to upsert document: %v", err)
+		}
+		hashes, err := q.DocumentHashes(ctx, []db.DocumentID{article1ID})
+		if err != nil {
+			t.Fatalf("failed to get content hash: %v", err)
+		}
+		h, ok := hashes[article1ID]
+		if !ok {
+			t.Fatalf("document not found")
+		}
+		if h.RowID != id || h.ContentHash != "abc" {
+			t.Errorf("expected row ID %d and hash %q, got %d and %q", id, "abc", h.RowID, h.ContentHash)
+		}
+		chunks, err := q.DocumentChunks(ctx, article1ID)
+		if err != nil {
+			t.Fatalf("failed to get chunks: %v", err)
+		}
+		if diff := cmp.Diff(article1Chunks, chunks); diff != "" {
+			t.Errorf("unexpected chunks: %v", diff)
+		}
+	})
+
+	t.Run("Previous versions are kept as revisions", func(t *testing.T) {
+		revisions, err := q.DocumentRevisionList(ctx, article1ID)
+		if err != nil {
+			t.Fatalf("failed to list revisions: %v", err)
+		}
+		if len(revisions) != 3 {
+			t.Fatalf("expected 3 revisions, got %d", len(revisions))
+		}
+		first, ok, err := q.DocumentRevisionGet(ctx, db.DocumentRevisionID{DocumentID: article1ID, Revision: revisions[0].Revision})
+		if err != nil {
+			t.Fatalf("failed to get revision: %v", err)
+		}
+		if !ok {
+			t.Fatalf("revision not found")
+		}
+		if first.Text != article1.Text {
+			t.Errorf("expected the first revision to contain the original text, got %q", first.Text)
+		}
+	})
+
+	t.Run("Can filter nearest chunks by metadata", func(t *testing.T) {
+		runbook := db.Document{
+			DocumentID: db.DocumentID{
+				Partition: testPartitionName,
+				URL:       "https://example.com/runbook1",
+			},
+			Title:   "Example Runbook",
+			Text:    "This is an example runbook.",
+			Summary: "An example runbook.",
+			Metadata: map[string]any{
+				"source": "runbooks",
+				"tags":   []any{"ops"},
+			},
+			CreatedAt:     now,
+			LastUpdatedAt: now,
+		}
+		_, err := q.DocumentPut(ctx, db.DocumentPutArgs{
+			Document: runbook,
+			Chunks:   []db.Chunk{createChunk("Runbook chunk 0")},
+		})
+		if err != nil {
+			t.Fatalf("failed to insert document: %v", err)
+		}
+
+		doc, ok, err := q.DocumentGet(ctx, runbook.DocumentID)
+		if err != nil {
+			t.Fatalf("failed to get document: %v", err)
+		}
+		if !ok {
+			t.Fatalf("document not found")
+		}
+		if diff := cmp.Diff(runbook, doc); diff != "" {
+			t.Fatalf("unexpected document: %v", diff)
+		}
+
+		filter, err := db.ParseFilter(`source = "runbooks"`)
+		if err != nil {
+			t.Fatalf("failed to parse filter: %v", err)
+		}
+		results, err := q.DocumentNearest(ctx, db.DocumentSelectNearestArgs{
+			Partition: testPartitionName,
+			Embedding: createChunk("").Embedding,
+			Limit:     10,
+			Filter:    filter,
+		})
+		if err != nil {
+			t.Fatalf("failed to find nearest chunks: %v", err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+		if results[0].URL != runbook.URL {
+			t.Errorf("expected %q, got %q", runbook.URL, results[0].URL)
+		}
+	})
+
+	t.Run("Metadata filters are applied before the nearest chunks are limited", func(t *testing.T) {
+		put := func(url string, metadata map[string]any, chunk db.Chunk) {
+			_, err := q.DocumentPut(ctx, db.DocumentPutArgs{
+				Document: db.Document{
+					DocumentID: db.DocumentID{Partition: testPartitionName, URL: url},
+					Title:      "Escalation",
+					Text:       chunk.Text,
+					Metadata:   metadata,
+				},
+				Chunks: []db.Chunk{chunk},
+			})
+			if err != nil {
+				t.Fatalf("failed to insert document: %v", err)
+			}
+		}
+		// The closest chunk doesn't match the filter.
+		put("https://example.com/escalation-dev", map[string]any{"tags": []any{"dev"}, "priority": 2}, createChunk("Dev escalation"))
+		further := createChunk("Ops escalation")
+		further.Embedding[0] = 100
+		put("https://example.com/escalation-ops", map[string]any{"tags": []any{"ops"}, "priority": 2}, further)
+
+		filter, err := db.ParseFilter(`tags has "ops" and priority >= 2`)
+		if err != nil {
+			t.Fatalf("failed to parse filter: %v", err)
+		}
+		results, err := q.DocumentNearest(ctx, db.DocumentSelectNearestArgs{
+			Partition: testPartitionName,
+			Embedding: createChunk("").Embedding,
+			Limit:     1,
+			Filter:    filter,
+		})
+		if err != nil {
+			t.Fatalf("failed to find nearest chunks: %v", err)
+		}
+		if len(results) != 1 || results[0].URL != "https://example.com/escalation-ops" {
+			t.Errorf("expected only the ops escalation, got %v", results)
+		}
+	})
+}
+
+func TestEmbeddingSpace(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	if err := initConnection(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	q := db.New(conn)
+
+	t.Run("The default embedding space is registered by the migrations", func(t *testing.T) {
+		space, err := q.EmbeddingSpaceEnsure(ctx, db.DefaultEmbeddingSpace.Model, db.DefaultEmbeddingSpace.Dimensions)
+		if err != nil {
+			t.Fatalf("failed to ensure embedding space: %v", err)
+		}
+		if diff := cmp.Diff(db.DefaultEmbeddingSpace, space); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("A model can't change dimensions", func(t *testing.T) {
+		_, err := q.EmbeddingSpaceEnsure(ctx, db.DefaultEmbeddingSpace.Model, 1024)
+		if !errors.Is(err, db.ErrEmbeddingDimensionsMismatch) {
+			t.Errorf("expected ErrEmbeddingDimensionsMismatch, got %v", err)
+		}
+	})
+	t.Run("New models have their own chunk table", func(t *testing.T) {
+		space, err := q.EmbeddingSpaceEnsure(ctx, "test-model:latest", 4)
+		if err != nil {
+			t.Fatalf("failed to ensure embedding space: %v", err)
+		}
+		if space.Table != "document_chunk_vec_test_model_latest_4_5b0e1cc8" {
+			t.Errorf("unexpected table name %q", space.Table)
+		}
+		sq := q.WithEmbeddingSpace(space)
+		id := db.DocumentID{Partition: testPartitionName, URL: "https://example.com/small-embeddings"}
+		_, err = sq.DocumentPut(ctx, db.DocumentPutArgs{
+			Document: db.Document{DocumentID: id, Title: "Small embeddings", Text: "Text."},
+			Chunks:   []db.Chunk{{Text: "Text.", Embedding: []float32{1, 0, 0, 0}}},
+		})
+		if err != nil {
+			t.Fatalf("failed to insert document: %v", err)
+		}
+		results, err := sq.DocumentNearest(ctx, db.DocumentSelectNearestArgs{
+			Partition: testPartitionName,
+			Embedding: []float32{1, 0, 0, 0},
+			Limit:     1,
+		})
+		if err != nil {
+			t.Fatalf("failed to find nearest chunks: %v", err)
+		}
+		if len(results) != 1 || results[0].URL != id.URL {
+			t.Errorf("expected to find %q, got %v", id.URL, results)
+		}
+		if err = sq.DocumentDelete(ctx, id); err != nil {
+			t.Fatalf("failed to delete document: %v", err)
+		}
+	})
+}
+
+func TestStore(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	if err := initConnection(); err != nil {
+		t.Fatal(err)
+	}
+	q := db.New(conn)
+	space, err := q.EmbeddingSpaceEnsure(context.Background(), "storetest", storetest.Dimensions)
+	if err != nil {
+		t.Fatalf("failed to ensure embedding space: %v", err)
+	}
+	storetest.Run(t, q.WithEmbeddingSpace(space))
 }
 
 func createChunk(s string) (chunk db.Chunk) {
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rqlite/gorqlite"
+)
+
+// EmbeddingSpace is a chunk table for the embeddings of a single model.
+type EmbeddingSpace struct {
+	Model      string
+	Dimensions int
+	Table      string
+}
+
+// DefaultEmbeddingSpace is the chunk table created by the initial migrations.
+var DefaultEmbeddingSpace = EmbeddingSpace{
+	Model:      "nomic-embed-text",
+	Dimensions: 768,
+	Table:      "document_chunk_vec",
+}
+
+var ErrEmbeddingDimensionsMismatch = errors.New("embedding dimensions mismatch")
+
+// embeddingSpaceTableName returns a table name for the model that's safe to
+// use in SQL statements, e.g. document_chunk_vec_mxbai_embed_large_1024_6befc052.
+// Models such as a-b and a_b have the same readable name, so a hash of the
+// exact model name and dimensions is appended to keep their tables apart.
+func embeddingSpaceTableName(model string, dimensions int) string {
+	name := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToLower(r)
+		}
+		return '_'
+	}, model)
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d", model, dimensions)))
+	return fmt.Sprintf("document_chunk_vec_%s_%d_%s", name, dimensions, hex.EncodeToString(hash[:4]))
+}
+
+func createChunkTableSQL(table string, dimensions int) string {
+	return fmt.Sprintf(`create virtual table if not exists %s using vec0(
+  document_rowid integer not null references document(rowid),
+  partition text not null partition key,
+  idx integer not null,
+  +text text not null,
+  +model text not null,
+  +chunk_hash text not null,
+  embedding float[%d] distance_metric=cosine
+)`, table, dimensions)
+}
+
+func (q *Queries) EmbeddingSpaceGet(ctx context.Context, model string) (space EmbeddingSpace, ok bool, err error) {
+	result, err := q.conn.QueryOneParameterizedContext(ctx, gorqlite.ParameterizedStatement{
+		Query:     `select model, dimensions, table_name from embedding_space where model = ?`,
+		Arguments: []any{model},
+	})
+	if err != nil {
+		return space, false, err
+	}
+	if !result.Next() {
+		return space, false, nil
+	}
+	if err = result.Scan(&space.Model, &space.Dimensions, &space.Table); err != nil {
+		return space, false, err
+	}
+	return space, true, nil
+}
+
+func (q *Queries) EmbeddingSpaceList(ctx context.Context) (spaces []EmbeddingSpace, err error) {
+	result, err := q.conn.QueryOneContext(ctx, `select model, dimensions, table_name from embedding_space order by model`)
+	if err != nil {
+		return spaces, err
+	}
+	for result.Next() {
+		var space EmbeddingSpace
+		if err = result.Scan(&space.Model, &space.Dimensions, &space.Table); err != nil {
+			return spaces, err
+		}
+		spaces = append(spaces, space)
+	}
+	return spaces, nil
+}
+
+// EmbeddingSpaceEnsure returns the embedding space of the model, creating its
+// chunk table if required. If the model is already registered with different
+// dimensions, ErrEmbeddingDimensionsMismatch is returned.
+func (q *Queries) EmbeddingSpaceEnsure(ctx context.Context, model string, dimensions int) (space EmbeddingSpace, err error) {
+	space, ok, err := q.EmbeddingSpaceGet(ctx, model)
+	if err != nil {
+		return space, fmt.Errorf("failed to get embedding space: %w", err)
+	}
+	if ok {
+		if space.Dimensions != dimensions {
+			return space, fmt.Errorf("%w: model %q produces %d dimensions, but the store contains %d dimensions", ErrEmbeddingDimensionsMismatch, model, dimensions, space.Dimensions)
+		}
+		return space, nil
+	}
+	if dimensions <= 0 {
+		return space, fmt.Errorf("%w: model %q produces %d dimensions", ErrEmbeddingDimensionsMismatch, model, dimensions)
+	}
+	space = EmbeddingSpace{
+		Model:      model,
+		Dimensions: dimensions,
+		Table:      embeddingSpaceTableName(model, dimensions),
+	}
+	statements := []gorqlite.ParameterizedStatement{
+		{
+			Query: createChunkTableSQL(space.Table, space.Dimensions),
+		},
+		{
+			Query:     `insert into embedding_space (model, dimensions, table_name, created_at) values (?, ?, ?, ?) on conflict(model) do nothing`,
+			Arguments: []any{space.Model, space.Dimensions, space.Table, time.Now().UTC()},
+		},
+	}
+	if _, err = q.conn.WriteParameterizedContext(ctx, statements); err != nil {
+		return space, fmt.Errorf("failed to create embedding space: %w", err)
+	}
+	return space, nil
+}
+
+// WithEmbeddingSpace returns queries that read and write chunks in the embedding space.
+func (q *Queries) WithEmbeddingSpace(space EmbeddingSpace) *Queries {
+	return &Queries{
+		conn:  q.conn,
+		space: space,
+	}
+}
+
+// EmbeddingSpace returns the embedding space that chunks are read from and written to.
+func (q *Queries) EmbeddingSpace() EmbeddingSpace {
+	return q.space
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestEmbeddingSpaceTableName(t *testing.T) {
+	valid := regexp.MustCompile(`^document_chunk_vec_[a-z0-9_]+$`)
+	names := map[string]string{}
+	for _, space := range []EmbeddingSpace{
+		{Model: "a-b", Dimensions: 768},
+		{Model: "a_b", Dimensions: 768},
+		{Model: "x:7b", Dimensions: 768},
+		{Model: "x_7b", Dimensions: 768},
+		{Model: "x_7b", Dimensions: 1024},
+		{Model: "X_7B", Dimensions: 768},
+	} {
+		name := embeddingSpaceTableName(space.Model, space.Dimensions)
+		if !valid.MatchString(name) {
+			t.Errorf("%s: table name %q isn't safe to use in SQL", space.Model, name)
+		}
+		if other, ok := names[name]; ok {
+			t.Errorf("%s/%d: table name %q is also used by %s", space.Model, space.Dimensions, name, other)
+		}
+		names[name] = space.Model
+	}
+	if a, b := embeddingSpaceTableName("a-b", 768), embeddingSpaceTableName("a-b", 768); a != b {
+		t.Errorf("expected table names to be stable, got %q and %q", a, b)
+	}
+}
@@ -0,0 +1,381 @@
+package db
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidMetadata = errors.New("invalid metadata")
+
+// ValidateMetadata checks that the metadata can be filtered on. Keys must be
+// letters, digits and underscores, and values must be strings, numbers,
+// booleans, or arrays of them, such as tags.
+func ValidateMetadata(metadata map[string]any) error {
+	for key, v := range metadata {
+		if !isFilterField(key) {
+			return fmt.Errorf("%w: key %q must only contain letters, digits and underscores", ErrInvalidMetadata, key)
+		}
+		values, isArray := v.([]any)
+		if !isArray {
+			values = []any{v}
+		}
+		for _, v := range values {
+			if _, ok := filterValue(v); !ok {
+				return fmt.Errorf("%w: %q must be a string, number, boolean or array of them", ErrInvalidMetadata, key)
+			}
+		}
+	}
+	return nil
+}
+
+func isFilterField(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// filterValue normalises a metadata value to nil, a string, a float64 or a bool.
+func filterValue(v any) (fv any, ok bool) {
+	switch v := v.(type) {
+	case nil, string, float64, bool:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	}
+	return nil, false
+}
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// Filter is a parsed filter expression, e.g.:
+//
+//	source = "runbooks" and language in ("en", "fr") and priority >= 2 and tags has "ops"
+//
+// Conditions compare a metadata key to a string, number or boolean with =,
+// !=, <, <=, >, >= or in, or check that an array, such as tags, has a value.
+// Conditions are combined with and. Values only match values of the same
+// type, and missing keys only match !=.
+type Filter struct {
+	Conditions []FilterCondition
+}
+
+type FilterCondition struct {
+	Field    string
+	Operator string
+	// Values are strings, float64s or bools.
+	Values []any
+}
+
+// SQL returns a where clause fragment that starts with " and " for each
+// condition, and its arguments. The metadata parameter is the SQL expression
+// of the JSON metadata column, e.g. d.metadata.
+func (f Filter) SQL(metadata string) (clause string, args []any) {
+	var sb strings.Builder
+	for _, c := range f.Conditions {
+		path := `$."` + c.Field + `"`
+		sb.WriteString(" and ")
+		switch c.Operator {
+		case "has":
+			// json_each returns the value itself if it isn't an array.
+			sb.WriteString("exists (select 1 from json_each(" + metadata + ", ?) where ")
+			sb.WriteString(filterTypeSQL("json_each.type", c.Values[0]))
+			sb.WriteString(" and json_each.value = ?)")
+			args = append(args, path, filterArg(c.Values[0]))
+		case "in":
+			sb.WriteString("(")
+			for i, v := range c.Values {
+				if i > 0 {
+					sb.WriteString(" or ")
+				}
+				sb.WriteString(filterTypeSQL("json_type("+metadata+", ?)", v))
+				sb.WriteString(" and json_extract(" + metadata + ", ?) = ?")
+				args = append(args, path, path, filterArg(v))
+			}
+			sb.WriteString(")")
+		case "!=":
+			sb.WriteString("not coalesce(")
+			sb.WriteString(filterTypeSQL("json_type("+metadata+", ?)", c.Values[0]))
+			sb.WriteString(" and json_extract(" + metadata + ", ?) = ?, 0)")
+			args = append(args, path, path, filterArg(c.Values[0]))
+		default:
+			sb.WriteString("(")
+			sb.WriteString(filterTypeSQL("json_type("+metadata+", ?)", c.Values[0]))
+			sb.WriteString(" and json_extract(" + metadata + ", ?) " + c.Operator + " ?)")
+			args = append(args, path, path, filterArg(c.Values[0]))
+		}
+	}
+	return sb.String(), args
+}
+
+// filterTypeSQL returns a condition that the JSON type expression matches the type of the value.
+func filterTypeSQL(typeExpr string, v any) string {
+	switch v.(type) {
+	case float64:
+		return typeExpr + " in ('integer', 'real')"
+	case bool:
+		return typeExpr + " in ('true', 'false')"
+	}
+	return typeExpr + " = 'text'"
+}
+
+// filterArg returns the value as a SQL argument. JSON booleans are extracted as 1 or 0.
+func filterArg(v any) any {
+	if b, ok := v.(bool); ok {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	return v
+}
+
+// Match reports whether the metadata matches all of the conditions, in the
+// same way as the SQL.
+func (f Filter) Match(metadata map[string]any) bool {
+	for _, c := range f.Conditions {
+		if !c.match(metadata[c.Field]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c FilterCondition) match(v any) bool {
+	switch c.Operator {
+	case "has":
+		values, isArray := v.([]any)
+		if !isArray {
+			values = []any{v}
+		}
+		for _, v := range values {
+			if cmp, ok := compareFilterValues(v, c.Values[0]); ok && cmp == 0 {
+				return true
+			}
+		}
+		return false
+	case "in":
+		for _, want := range c.Values {
+			if cmp, ok := compareFilterValues(v, want); ok && cmp == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	cmp, ok := compareFilterValues(v, c.Values[0])
+	if c.Operator == "!=" {
+		return !ok || cmp != 0
+	}
+	if !ok {
+		return false
+	}
+	switch c.Operator {
+	case "=":
+		return cmp == 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// compareFilterValues compares a metadata value to a filter value. If they
+// aren't the same type, ok is false.
+func compareFilterValues(v, want any) (result int, ok bool) {
+	v, ok = filterValue(v)
+	if !ok {
+		return 0, false
+	}
+	switch want := want.(type) {
+	case string:
+		if v, ok := v.(string); ok {
+			return strings.Compare(v, want), true
+		}
+	case float64:
+		if v, ok := v.(float64); ok {
+			return cmp.Compare(v, want), true
+		}
+	case bool:
+		if v, ok := v.(bool); ok {
+			if v == want {
+				return 0, true
+			}
+			if want {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// ParseFilter parses a filter expression. An empty expression returns an empty filter.
+func ParseFilter(expr string) (f Filter, err error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return f, err
+	}
+	p := &filterParser{tokens: tokens}
+	for len(p.tokens) > 0 {
+		if len(f.Conditions) > 0 {
+			if t := p.next(); !strings.EqualFold(t.value, "and") || t.quoted {
+				return f, fmt.Errorf("%w: expected and, got %q", ErrInvalidFilter, t.value)
+			}
+		}
+		c, err := p.condition()
+		if err != nil {
+			return f, err
+		}
+		f.Conditions = append(f.Conditions, c)
+	}
+	return f, nil
+}
+
+type filterToken struct {
+	value  string
+	quoted bool
+}
+
+type filterParser struct {
+	tokens []filterToken
+}
+
+func (p *filterParser) next() (t filterToken) {
+	if len(p.tokens) == 0 {
+		return filterToken{}
+	}
+	t, p.tokens = p.tokens[0], p.tokens[1:]
+	return t
+}
+
+// value parses a quoted string, a number, true or false.
+func (p *filterParser) value() (any, error) {
+	t := p.next()
+	if t.quoted {
+		return t.value, nil
+	}
+	switch t.value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if f, err := strconv.ParseFloat(t.value, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return f, nil
+	}
+	return nil, fmt.Errorf("%w: expected a quoted string, number or boolean, got %q", ErrInvalidFilter, t.value)
+}
+
+func (p *filterParser) condition() (c FilterCondition, err error) {
+	field := p.next()
+	if field.quoted || !isFilterField(field.value) || isFilterNumber(field.value) {
+		return c, fmt.Errorf("%w: expected a metadata key, got %q", ErrInvalidFilter, field.value)
+	}
+	c.Field = field.value
+	op := p.next()
+	c.Operator = strings.ToLower(op.value)
+	switch {
+	case op.quoted:
+		return c, fmt.Errorf("%w: expected an operator, got %q", ErrInvalidFilter, op.value)
+	case c.Operator == "in":
+		if t := p.next(); t.quoted || t.value != "(" {
+			return c, fmt.Errorf("%w: expected (, got %q", ErrInvalidFilter, t.value)
+		}
+		for {
+			v, err := p.value()
+			if err != nil {
+				return c, err
+			}
+			c.Values = append(c.Values, v)
+			t := p.next()
+			if !t.quoted && t.value == ")" {
+				return c, nil
+			}
+			if t.quoted || t.value != "," {
+				return c, fmt.Errorf("%w: expected , or ), got %q", ErrInvalidFilter, t.value)
+			}
+		}
+	case slices.Contains([]string{"=", "!=", "<", "<=", ">", ">=", "has"}, c.Operator):
+		v, err := p.value()
+		if err != nil {
+			return c, err
+		}
+		c.Values = []any{v}
+		return c, nil
+	}
+	return c, fmt.Errorf("%w: unknown operator %q", ErrInvalidFilter, op.value)
+}
+
+func isFilterNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+func tokenizeFilter(expr string) (tokens []filterToken, err error) {
+	r := []rune(expr)
+	for i := 0; i < len(r); {
+		switch {
+		case unicode.IsSpace(r[i]):
+			i++
+		case r[i] == '"':
+			// Find the end of the string, allowing for escaped characters.
+			end := i + 1
+			for ; end < len(r) && r[end] != '"'; end++ {
+				if r[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(r) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrInvalidFilter)
+			}
+			s, err := strconv.Unquote(string(r[i : end+1]))
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid string %s", ErrInvalidFilter, string(r[i:end+1]))
+			}
+			tokens = append(tokens, filterToken{value: s, quoted: true})
+			i = end + 1
+		case strings.ContainsRune("(),", r[i]):
+			tokens = append(tokens, filterToken{value: string(r[i])})
+			i++
+		case strings.ContainsRune("=!<>", r[i]):
+			end := i + 1
+			if end < len(r) && r[end] == '=' {
+				end++
+			}
+			tokens = append(tokens, filterToken{value: string(r[i:end])})
+			i = end
+		default:
+			// Keys, keywords and numbers, e.g. -1.5e3.
+			end := i
+			for end < len(r) && (unicode.IsLetter(r[end]) || unicode.IsDigit(r[end]) || strings.ContainsRune("_.-+", r[end])) {
+				end++
+			}
+			if end == i {
+				return nil, fmt.Errorf("%w: unexpected character %q", ErrInvalidFilter, r[i])
+			}
+			tokens = append(tokens, filterToken{value: string(r[i:end])})
+			i = end
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,168 @@
+package db_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		expected []db.FilterCondition
+	}{
+		{
+			name: "empty expressions have no conditions",
+			expr: "",
+		},
+		{
+			name:     "equality",
+			expr:     `source = "runbooks"`,
+			expected: []db.FilterCondition{{Field: "source", Operator: "=", Values: []any{"runbooks"}}},
+		},
+		{
+			name: "conditions can be combined with and",
+			expr: `source != "runbooks" AND language>="en"`,
+			expected: []db.FilterCondition{
+				{Field: "source", Operator: "!=", Values: []any{"runbooks"}},
+				{Field: "language", Operator: ">=", Values: []any{"en"}},
+			},
+		},
+		{
+			name:     "in",
+			expr:     `department in ("ops", "dev \"team\"")`,
+			expected: []db.FilterCondition{{Field: "department", Operator: "in", Values: []any{"ops", `dev "team"`}}},
+		},
+		{
+			name: "any key can be compared to numbers and booleans",
+			expr: `priority>-1.5 and reviewed = true and version in (1, 2e1)`,
+			expected: []db.FilterCondition{
+				{Field: "priority", Operator: ">", Values: []any{-1.5}},
+				{Field: "reviewed", Operator: "=", Values: []any{true}},
+				{Field: "version", Operator: "in", Values: []any{1.0, 20.0}},
+			},
+		},
+		{
+			name:     "arrays can be checked for a value",
+			expr:     `tags HAS "ops"`,
+			expected: []db.FilterCondition{{Field: "tags", Operator: "has", Values: []any{"ops"}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := db.ParseFilter(test.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(test.expected, f.Conditions); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestFilterSQL(t *testing.T) {
+	f, err := db.ParseFilter(`source = "runbooks" and priority != 2 and tags has "ops" and reviewed in (true, "yes")`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sql, args := f.SQL("d.metadata")
+	expectedSQL := ` and (json_type(d.metadata, ?) = 'text' and json_extract(d.metadata, ?) = ?)` +
+		` and not coalesce(json_type(d.metadata, ?) in ('integer', 'real') and json_extract(d.metadata, ?) = ?, 0)` +
+		` and exists (select 1 from json_each(d.metadata, ?) where json_each.type = 'text' and json_each.value = ?)` +
+		` and (json_type(d.metadata, ?) in ('true', 'false') and json_extract(d.metadata, ?) = ? or json_type(d.metadata, ?) = 'text' and json_extract(d.metadata, ?) = ?)`
+	if sql != expectedSQL {
+		t.Errorf("expected SQL:\n%s\ngot:\n%s", expectedSQL, sql)
+	}
+	expectedArgs := []any{
+		`$."source"`, `$."source"`, "runbooks",
+		`$."priority"`, `$."priority"`, 2.0,
+		`$."tags"`, "ops",
+		`$."reviewed"`, `$."reviewed"`, 1, `$."reviewed"`, `$."reviewed"`, "yes",
+	}
+	if diff := cmp.Diff(expectedArgs, args); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	metadata := map[string]any{
+		"source":   "runbooks",
+		"priority": 2.0,
+		"reviewed": true,
+		"tags":     []any{"ops", "linux"},
+	}
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{expr: `source = "runbooks"`, expected: true},
+		{expr: `source = "wiki"`, expected: false},
+		{expr: `source in ("wiki", "runbooks")`, expected: true},
+		{expr: `priority >= 2 and priority < 3`, expected: true},
+		{expr: `priority > 2`, expected: false},
+		{expr: `priority = "2"`, expected: false},
+		{expr: `reviewed = true`, expected: true},
+		{expr: `reviewed = 1`, expected: false},
+		{expr: `tags has "ops"`, expected: true},
+		{expr: `tags has "windows"`, expected: false},
+		{expr: `source has "runbooks"`, expected: true},
+		{expr: `tags = "ops"`, expected: false},
+		{expr: `missing = "a"`, expected: false},
+		{expr: `missing != "a"`, expected: true},
+		{expr: `priority != "2"`, expected: true},
+		{expr: `missing has "a"`, expected: false},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			f, err := db.ParseFilter(test.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := f.Match(metadata); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []string{
+		`"source" = "a"`,
+		`1 = "a"`,
+		`source = runbooks`,
+		`source has ("a")`,
+		`source = "runbooks" or language = "en"`,
+		`source like "run%"`,
+		`source in ("a" "b")`,
+		`source = "unterminated`,
+		`source = "a"; drop table document`,
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			_, err := db.ParseFilter(expr)
+			if !errors.Is(err, db.ErrInvalidFilter) {
+				t.Errorf("expected ErrInvalidFilter, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	if err := db.ValidateMetadata(map[string]any{"source": "runbooks", "tags": []any{"a", "b"}, "priority": 1.0, "reviewed": false}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	invalid := []map[string]any{
+		{"owner": map[string]any{"name": "alice"}},
+		{"tags": []any{[]any{"a"}}},
+		{"source-system": "wiki"},
+	}
+	for _, metadata := range invalid {
+		if err := db.ValidateMetadata(metadata); !errors.Is(err, db.ErrInvalidMetadata) {
+			t.Errorf("%v: expected ErrInvalidMetadata, got %v", metadata, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+)
+
+// HybridWeights configures reciprocal rank fusion of vector and keyword results.
+type HybridWeights struct {
+	// Vector is the weight given to the rank of a chunk in the vector results.
+	Vector float64
+	// Keyword is the weight given to the rank of a document in the keyword results.
+	Keyword float64
+	// K dampens the effect of the top ranks, 60 is a common choice.
+	K int
+}
+
+type DocumentHybridArgs struct {
+	Partition string
+	Text      string
+	Embedding []float32
+	Limit     int
+	Weights   HybridWeights
+	Filter    Filter
+	Dates     DateRange
+}
+
+// DocumentHybrid runs a KNN query and a full-text search in the same partition,
+// and merges the results with reciprocal rank fusion.
+func DocumentHybrid(ctx context.Context, store Store, args DocumentHybridArgs) (docs []DocumentSelectNearestResult, err error) {
+	vector, err := store.DocumentNearest(ctx, DocumentSelectNearestArgs{
+		Partition: args.Partition,
+		Embedding: args.Embedding,
+		Limit:     args.Limit,
+		Filter:    args.Filter,
+		Dates:     args.Dates,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find nearest documents: %w", err)
+	}
+	keyword, err := store.DocumentKeyword(ctx, DocumentKeywordArgs{
+		Partition: args.Partition,
+		Query:     KeywordQuery(args.Text),
+		Limit:     args.Limit,
+		Filter:    args.Filter,
+		Dates:     args.Dates,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find keyword matches: %w", err)
+	}
+	return FuseReciprocalRank(vector, keyword, args.Weights, args.Limit), nil
+}
+
+// FuseReciprocalRank merges ranked vector (chunk) results and keyword (document)
+// results. Each vector chunk scores weights.Vector / (k + rank), plus
+// weights.Keyword / (k + rank) if its document was also a keyword match.
+// Keyword matches that have no chunk in the vector results are included as
+// they are, scored on their keyword rank alone.
+func FuseReciprocalRank(vector, keyword []DocumentSelectNearestResult, weights HybridWeights, limit int) (docs []DocumentSelectNearestResult) {
+	k := float64(weights.K)
+	keywordRanks := make(map[int64]int, len(keyword))
+	for i, doc := range keyword {
+		if _, exists := keywordRanks[doc.RowID]; !exists {
+			keywordRanks[doc.RowID] = i + 1
+		}
+	}
+
+	docs = make([]DocumentSelectNearestResult, 0, len(vector)+len(keyword))
+	matched := make(map[int64]bool, len(keyword))
+	for i, doc := range vector {
+		doc.Score = weights.Vector / (k + float64(i+1))
+		if rank, ok := keywordRanks[doc.RowID]; ok {
+			doc.Score += weights.Keyword / (k + float64(rank))
+			matched[doc.RowID] = true
+		}
+		docs = append(docs, doc)
+	}
+	for i, doc := range keyword {
+		if matched[doc.RowID] {
+			continue
+		}
+		doc.Score = weights.Keyword / (k + float64(i+1))
+		docs = append(docs, doc)
+	}
+
+	slices.SortStableFunc(docs, func(a, b DocumentSelectNearestResult) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	if limit > 0 && len(docs) > limit {
+		docs = docs[:limit]
+	}
+	return docs
+}
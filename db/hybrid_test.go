@@ -0,0 +1,80 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestKeywordQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{
+			name:     "empty text returns an empty query",
+			text:     "  ",
+			expected: "",
+		},
+		{
+			name:     "words are quoted and joined with OR",
+			text:     "who owns ABC-123?",
+			expected: `"who" OR "owns" OR "ABC-123?"`,
+		},
+		{
+			name:     "quotes are escaped",
+			text:     `say "hello"`,
+			expected: `"say" OR """hello"""`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := db.KeywordQuery(test.text)
+			if actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFuseReciprocalRank(t *testing.T) {
+	weights := db.HybridWeights{Vector: 1, Keyword: 1, K: 60}
+	vector := []db.DocumentSelectNearestResult{
+		{RowID: 1, Index: 0, Text: "doc 1, chunk 0"},
+		{RowID: 2, Index: 3, Text: "doc 2, chunk 3"},
+		{RowID: 3, Index: 1, Text: "doc 3, chunk 1"},
+	}
+	keyword := []db.DocumentSelectNearestResult{
+		{RowID: 3, Index: -1, Text: "doc 3 snippet"},
+		{RowID: 4, Index: -1, Text: "doc 4 snippet"},
+	}
+
+	t.Run("chunks of keyword matched documents are boosted", func(t *testing.T) {
+		actual := db.FuseReciprocalRank(vector, keyword, weights, 10)
+		var texts []string
+		for _, doc := range actual {
+			texts = append(texts, doc.Text)
+		}
+		expected := []string{"doc 3, chunk 1", "doc 1, chunk 0", "doc 2, chunk 3", "doc 4 snippet"}
+		if diff := cmp.Diff(expected, texts); diff != "" {
+			t.Error(diff)
+		}
+		if want := 1.0/63 + 1.0/61; actual[0].Score != want {
+			t.Errorf("expected score %v, got %v", want, actual[0].Score)
+		}
+	})
+	t.Run("weights change the order", func(t *testing.T) {
+		actual := db.FuseReciprocalRank(vector, keyword, db.HybridWeights{Vector: 1, Keyword: 0, K: 60}, 10)
+		if actual[0].Text != "doc 1, chunk 0" {
+			t.Errorf("expected the first vector result to be first, got %q", actual[0].Text)
+		}
+	})
+	t.Run("results are limited", func(t *testing.T) {
+		actual := db.FuseReciprocalRank(vector, keyword, weights, 2)
+		if len(actual) != 2 {
+			t.Errorf("expected 2 results, got %d", len(actual))
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/rqlite/gorqlite"
+)
+
+// KeywordQuery converts free text, such as a user's question, into an FTS5
+// query that matches documents containing any of the words. Each word is
+// quoted, so punctuation in product codes or ticket IDs (e.g. ABC-123) is
+// treated as part of a phrase rather than as FTS5 syntax.
+func KeywordQuery(text string) string {
+	words := strings.Fields(text)
+	phrases := make([]string, 0, len(words))
+	for _, word := range words {
+		word = strings.ReplaceAll(word, `"`, `""`)
+		phrases = append(phrases, `"`+word+`"`)
+	}
+	return strings.Join(phrases, " OR ")
+}
+
+type DocumentKeywordArgs struct {
+	Partition string
+	// Query is an FTS5 match expression, see KeywordQuery.
+	Query string
+	Limit int
+	// Filter limits the results to documents with matching metadata.
+	Filter Filter
+	// Dates limits the results to documents dated within the range.
+	Dates DateRange
+}
+
+// DocumentKeyword runs a full-text search against the documents in the
+// partition. Keyword results are whole documents rather than chunks, so the
+// Text of each result is a snippet of the document text around the match,
+// the Index is -1, and the Distance is zero. The Score is the negated bm25
+// rank, so that higher scores are better.
+func (q *Queries) DocumentKeyword(ctx context.Context, args DocumentKeywordArgs) (docs []DocumentSelectNearestResult, err error) {
+	if strings.TrimSpace(args.Query) == "" {
+		return nil, nil
+	}
+	filterSQL, filterArgs := args.Filter.SQL("d.metadata")
+	dateSQL, dateArgs := args.Dates.SQL(documentDateSQL)
+	stmt := gorqlite.ParameterizedStatement{
+		Query: `select
+  document_fts.rowid,
+  document_fts.partition,
+  snippet(document_fts, 3, '', '', '…', 64),
+  bm25(document_fts),
+  d.url,
+  d.title,
+  d.summary,
+  ` + documentDateSQL + `
+from document_fts
+inner join document d on d.rowid = document_fts.rowid
+where document_fts match ? and document_fts.partition = ?` + filterSQL + dateSQL + `
+order by bm25(document_fts)
+limit ?`,
+		Arguments: slices.Concat([]any{args.Query, args.Partition}, filterArgs, dateArgs, []any{args.Limit}),
+	}
+	result, err := q.conn.QueryOneParameterizedContext(ctx, stmt)
+	if err != nil {
+		return docs, err
+	}
+	for result.Next() {
+		doc := DocumentSelectNearestResult{
+			Index: -1,
+		}
+		var rank float64
+		if err = result.Scan(&doc.RowID, &doc.Partition, &doc.Text, &rank, &doc.URL, &doc.Title, &doc.Summary, &doc.Date); err != nil {
+			return docs, err
+		}
+		doc.Score = -rank
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
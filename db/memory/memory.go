@@ -0,0 +1,369 @@
+// Package memory is a db.Store that keeps documents in memory, and searches
+// them by brute force. The store can be snapshotted to a file, so it's
+// suitable for single node deployments and tests.
+package memory
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-h/ragserver/db"
+)
+
+// New creates a store. If path is not empty, the store is loaded from the
+// snapshot at path, if it exists, and Save writes the snapshot to path.
+func New(path string) (s *Store, err error) {
+	s = &Store{
+		path:      path,
+		documents: map[db.DocumentID]*document{},
+	}
+	if path == "" {
+		return s, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+	var snap snapshot
+	if err = json.NewDecoder(f).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	s.lastRowID = snap.LastRowID
+	for _, d := range snap.Documents {
+		s.documents[d.Document.DocumentID] = d
+	}
+	return s, nil
+}
+
+var _ db.Store = (*Store)(nil)
+
+type Store struct {
+	m         sync.RWMutex
+	path      string
+	dirty     bool
+	lastRowID int64
+	documents map[db.DocumentID]*document
+}
+
+type document struct {
+	RowID     int64
+	Document  db.Document
+	Chunks    []db.Chunk
+	Sections  []db.Section
+	Revisions []db.DocumentRevision
+}
+
+type snapshot struct {
+	LastRowID int64
+	Documents []*document
+}
+
+// Save writes a snapshot of the store to its path, if the store has changed
+// since the last save.
+func (s *Store) Save() (err error) {
+	if s.path == "" {
+		return nil
+	}
+	s.m.Lock()
+	if !s.dirty {
+		s.m.Unlock()
+		return nil
+	}
+	snap := snapshot{
+		LastRowID: s.lastRowID,
+		Documents: slices.SortedFunc(maps.Values(s.documents), func(a, b *document) int {
+			return cmp.Compare(a.RowID, b.RowID)
+		}),
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		s.m.Unlock()
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	s.dirty = false
+	s.m.Unlock()
+	defer func() {
+		if err != nil {
+			// Mark the store as changed, so that the next save retries.
+			s.m.Lock()
+			s.dirty = true
+			s.m.Unlock()
+		}
+	}()
+	// Write to a temporary file and rename it, so that a failed write doesn't
+	// corrupt the previous snapshot.
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace snapshot: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) DocumentPut(ctx context.Context, args db.DocumentPutArgs) (id int64, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.put(args), nil
+}
+
+func (s *Store) DocumentPutBatch(ctx context.Context, args []db.DocumentPutArgs) (ids []int64, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	ids = make([]int64, len(args))
+	for i, a := range args {
+		ids[i] = s.put(a)
+	}
+	return ids, nil
+}
+
+func (s *Store) put(args db.DocumentPutArgs) (id int64) {
+	d, ok := s.documents[args.Document.DocumentID]
+	if !ok {
+		s.lastRowID++
+		d = &document{
+			RowID: s.lastRowID,
+		}
+		s.documents[args.Document.DocumentID] = d
+	}
+	doc := args.Document
+	if ok {
+		// Match the rqlite store, which keeps the original creation time.
+		doc.CreatedAt = d.Document.CreatedAt
+	}
+	doc.Metadata = maps.Clone(doc.Metadata)
+	d.Document = doc
+	d.Chunks = slices.Clone(args.Chunks)
+	d.Sections = slices.Clone(args.Sections)
+	d.Revisions = append(d.Revisions, db.DocumentRevision{
+		DocumentRevisionID: db.DocumentRevisionID{
+			DocumentID: doc.DocumentID,
+			Revision:   len(d.Revisions) + 1,
+		},
+		Title:     doc.Title,
+		Text:      doc.Text,
+		Summary:   doc.Summary,
+		Metadata:  doc.Metadata,
+		CreatedBy: args.User,
+		CreatedAt: time.Now().UTC(),
+	})
+	s.dirty = true
+	return d.RowID
+}
+
+func (s *Store) DocumentDelete(ctx context.Context, args db.DocumentID) (err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, ok := s.documents[args]; ok {
+		delete(s.documents, args)
+		s.dirty = true
+	}
+	return nil
+}
+
+func (s *Store) DocumentGet(ctx context.Context, args db.DocumentID) (doc db.Document, ok bool, err error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	d, ok := s.documents[args]
+	if !ok {
+		return doc, false, nil
+	}
+	doc = d.Document
+	doc.Metadata = maps.Clone(doc.Metadata)
+	return doc, true, nil
+}
+
+func (s *Store) DocumentList(ctx context.Context, args db.DocumentListArgs) (docs []db.Document, err error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	for _, d := range s.documents {
+		doc := d.Document
+		if doc.Partition != args.Partition || doc.URL <= args.After || !strings.HasPrefix(doc.URL, args.URLPrefix) {
+			continue
+		}
+		if !args.UpdatedSince.IsZero() && doc.LastUpdatedAt.Before(args.UpdatedSince) {
+			continue
+		}
+		doc.Text = ""
+		doc.ContentHash = ""
+		doc.Metadata = maps.Clone(doc.Metadata)
+		docs = append(docs, doc)
+	}
+	slices.SortFunc(docs, func(a, b db.Document) int {
+		return strings.Compare(a.URL, b.URL)
+	})
+	if len(docs) > args.Limit {
+		docs = docs[:args.Limit]
+	}
+	return docs, nil
+}
+
+func (s *Store) DocumentChunks(ctx context.Context, args db.DocumentID) (chunks []db.Chunk, err error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	d, ok := s.documents[args]
+	if !ok {
+		return nil, nil
+	}
+	return slices.Clone(d.Chunks), nil
+}
+
+func (s *Store) DocumentHashes(ctx context.Context, ids []db.DocumentID) (hashes map[db.DocumentID]db.DocumentHashes, err error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	hashes = make(map[db.DocumentID]db.DocumentHashes, len(ids))
+	for _, id := range ids {
+		d, ok := s.documents[id]
+		if !ok {
+			continue
+		}
+		h := db.DocumentHashes{
+			RowID:       d.RowID,
+			ContentHash: d.Document.ContentHash,
+		}
+		for _, chunk := range d.Chunks {
+			h.ChunkHashes = append(h.ChunkHashes, db.ChunkHash(chunk.Text))
+		}
+		hashes[id] = h
+	}
+	return hashes, nil
+}
+
+func (s *Store) DocumentChunkEmbeddings(ctx context.Context, ids []db.ChunkID) (embeddings map[db.ChunkID][]float32, err error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	byRowID := make(map[int64]*document, len(s.documents))
+	for _, d := range s.documents {
+		byRowID[d.RowID] = d
+	}
+	embeddings = make(map[db.ChunkID][]float32, len(ids))
+	for _, id := range ids {
+		d, ok := byRowID[id.DocumentRowID]
+		if !ok || d.Document.Partition != id.Partition || id.Index < 0 || id.Index >= len(d.Chunks) {
+			continue
+		}
+		embeddings[id] = slices.Clone(d.Chunks[id.Index].Embedding)
+	}
+	return embeddings, nil
+}
+
+func (s *Store) DocumentChunkRange(ctx context.Context, args db.DocumentChunkRangeArgs) (texts []string, err error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	for _, d := range s.documents {
+		if d.RowID != args.RowID || d.Document.Partition != args.Partition {
+			continue
+		}
+		for i := max(args.From, 0); i <= args.To && i < int64(len(d.Chunks)); i++ {
+			texts = append(texts, d.Chunks[i].Text)
+		}
+		return texts, nil
+	}
+	return nil, nil
+}
+
+func (s *Store) DocumentSection(ctx context.Context, args db.DocumentSectionArgs) (section db.Section, ok bool, err error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	for _, d := range s.documents {
+		if d.RowID != args.RowID || d.Document.Partition != args.Partition {
+			continue
+		}
+		for _, section := range d.Sections {
+			if int64(section.From) <= args.Chunk && args.Chunk <= int64(section.To) {
+				return section, true, nil
+			}
+		}
+		return section, false, nil
+	}
+	return section, false, nil
+}
+
+// DocumentNearest returns the chunks with the smallest cosine distance to the embedding.
+func (s *Store) DocumentNearest(ctx context.Context, args db.DocumentSelectNearestArgs) (docs []db.DocumentSelectNearestResult, err error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	for _, d := range s.documents {
+		if d.Document.Partition != args.Partition || !args.Filter.Match(d.Document.Metadata) || !args.Dates.Contains(d.Document.Date()) {
+			continue
+		}
+		for i, chunk := range d.Chunks {
+			if len(chunk.Embedding) != len(args.Embedding) {
+				continue
+			}
+			docs = append(docs, db.DocumentSelectNearestResult{
+				RowID:     d.RowID,
+				Partition: d.Document.Partition,
+				Index:     int64(i),
+				Text:      chunk.Text,
+				Embedding: chunk.Embedding,
+				Distance:  cosineDistance(args.Embedding, chunk.Embedding),
+				URL:       d.Document.URL,
+				Title:     d.Document.Title,
+				Summary:   d.Document.Summary,
+				Date:      d.Document.Date(),
+			})
+		}
+	}
+	slices.SortStableFunc(docs, func(a, b db.DocumentSelectNearestResult) int {
+		return cmp.Or(cmp.Compare(a.Distance, b.Distance), cmp.Compare(a.RowID, b.RowID), cmp.Compare(a.Index, b.Index))
+	})
+	if len(docs) > args.Limit {
+		docs = docs[:args.Limit]
+	}
+	return docs, nil
+}
+
+func cosineDistance(a, b []float32) float64 {
+	return 1 - db.CosineSimilarity(a, b)
+}
+
+func (s *Store) DocumentRevisionList(ctx context.Context, args db.DocumentID) (revisions []db.DocumentRevision, err error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	d, ok := s.documents[args]
+	if !ok {
+		return nil, nil
+	}
+	for _, r := range d.Revisions {
+		r.Text = ""
+		r.Metadata = nil
+		revisions = append(revisions, r)
+	}
+	return revisions, nil
+}
+
+func (s *Store) DocumentRevisionGet(ctx context.Context, args db.DocumentRevisionID) (r db.DocumentRevision, ok bool, err error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	d, ok := s.documents[args.DocumentID]
+	if !ok || args.Revision < 1 || args.Revision > len(d.Revisions) {
+		return r, false, nil
+	}
+	r = d.Revisions[args.Revision-1]
+	r.Metadata = maps.Clone(r.Metadata)
+	return r, true, nil
+}
@@ -0,0 +1,136 @@
+package memory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/db/storetest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStore(t *testing.T) {
+	s, err := New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	storetest.Run(t, s)
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := New(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	doc := db.Document{
+		DocumentID: db.DocumentID{Partition: "partition", URL: "https://example.com"},
+		Title:      "Title",
+		Text:       "Text",
+		Metadata:   map[string]any{"source": "wiki"},
+	}
+	chunks := []db.Chunk{{Text: "Text", Embedding: []float32{1, 2, 3}}}
+	if _, err = s.DocumentPut(ctx, db.DocumentPutArgs{Document: doc, Chunks: chunks}); err != nil {
+		t.Fatalf("failed to put document: %v", err)
+	}
+	if err = s.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	loaded, err := New(path)
+	if err != nil {
+		t.Fatalf("failed to load store: %v", err)
+	}
+	actual, ok, err := loaded.DocumentGet(ctx, doc.DocumentID)
+	if err != nil || !ok {
+		t.Fatalf("expected to find the document, got ok=%v, err=%v", ok, err)
+	}
+	if diff := cmp.Diff(doc, actual); diff != "" {
+		t.Errorf("unexpected document: %v", diff)
+	}
+	actualChunks, err := loaded.DocumentChunks(ctx, doc.DocumentID)
+	if err != nil {
+		t.Fatalf("failed to get chunks: %v", err)
+	}
+	if diff := cmp.Diff(chunks, actualChunks); diff != "" {
+		t.Errorf("unexpected chunks: %v", diff)
+	}
+	id, err := loaded.DocumentPut(ctx, db.DocumentPutArgs{Document: db.Document{DocumentID: db.DocumentID{Partition: "partition", URL: "https://example.com/2"}}})
+	if err != nil {
+		t.Fatalf("failed to put document: %v", err)
+	}
+	if id != 2 {
+		t.Errorf("expected row IDs to continue from the snapshot, got %d", id)
+	}
+}
+
+func TestSnapshotRetriesFailedSaves(t *testing.T) {
+	ctx := context.Background()
+	// The directory doesn't exist yet, so the snapshot can't be written.
+	// Permissions aren't used, because they don't apply to root.
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	path := filepath.Join(dir, "store.json")
+	s, err := New(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	doc := db.Document{DocumentID: db.DocumentID{Partition: "partition", URL: "https://example.com"}}
+	if _, err = s.DocumentPut(ctx, db.DocumentPutArgs{Document: doc}); err != nil {
+		t.Fatalf("failed to put document: %v", err)
+	}
+	if err = s.Save(); err == nil {
+		t.Fatal("expected the save to fail")
+	}
+
+	if err = os.Mkdir(dir, 0o700); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err = s.Save(); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	loaded, err := New(path)
+	if err != nil {
+		t.Fatalf("failed to load store: %v", err)
+	}
+	if _, ok, err := loaded.DocumentGet(ctx, doc.DocumentID); err != nil || !ok {
+		t.Errorf("expected the failed save to be retried, got ok=%v, err=%v", ok, err)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected query
+	}{
+		{
+			query:    `death star`,
+			expected: query{{{words: []string{"death"}}, {words: []string{"star"}}}},
+		},
+		{
+			query:    `"death star" OR plan*`,
+			expected: query{{{words: []string{"death", "star"}}}, {{words: []string{"plan"}, prefix: true}}},
+		},
+		{
+			query:    `title:rebels NOT empire`,
+			expected: query{{{words: []string{"rebels"}, column: "title"}, {words: []string{"empire"}, not: true}}},
+		},
+		{
+			query:    `"ABC-123"`,
+			expected: query{{{words: []string{"abc", "123"}}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			actual, err := parseQuery(test.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(test.expected, actual, cmp.AllowUnexported(term{})); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/a-h/ragserver/db"
+)
+
+// The search functions support a subset of the FTS5 query syntax used by the
+// rqlite store: words, prefixes (plan*), phrases ("death star"), column
+// filters (title:), NOT, and OR. Terms that aren't separated by OR must all
+// match. Documents are ranked with BM25, as in FTS5.
+
+var searchColumns = []string{"title", "text", "summary"}
+
+// token is a word in a text, lower cased, with its byte offsets.
+type token struct {
+	value      string
+	start, end int
+}
+
+// tokenize splits text into runs of letters and digits, like the FTS5 unicode61 tokenizer.
+func tokenize(text string) (tokens []token) {
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			tokens = append(tokens, token{value: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{value: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+// term is a word, prefix or phrase to find.
+type term struct {
+	words  []string
+	prefix bool
+	column string
+	not    bool
+}
+
+// query is a set of alternatives, any of which can match.
+type query [][]term
+
+func parseQuery(q string) (parsed query, err error) {
+	var group []term
+	var pending term
+	fields, err := queryFields(q)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		switch {
+		case !f.quoted && f.value == "OR":
+			if len(group) == 0 {
+				return nil, fmt.Errorf("%w: OR must follow a term", db.ErrInvalidSearchQuery)
+			}
+			parsed = append(parsed, group)
+			group = nil
+			continue
+		case !f.quoted && f.value == "AND":
+			continue
+		case !f.quoted && f.value == "NOT":
+			pending.not = true
+			continue
+		}
+		value := f.value
+		if !f.quoted {
+			if column, rest, ok := strings.Cut(value, ":"); ok && slices.Contains(searchColumns, column) {
+				pending.column = column
+				if rest == "" {
+					continue
+				}
+				value = rest
+			}
+			if strings.HasSuffix(value, "*") {
+				pending.prefix = true
+				value = strings.TrimSuffix(value, "*")
+			}
+		}
+		for _, t := range tokenize(value) {
+			pending.words = append(pending.words, t.value)
+		}
+		if len(pending.words) > 0 {
+			group = append(group, pending)
+		}
+		pending = term{}
+	}
+	if len(group) > 0 {
+		parsed = append(parsed, group)
+	}
+	return parsed, nil
+}
+
+type queryField struct {
+	value  string
+	quoted bool
+}
+
+// queryFields splits the query on whitespace, keeping quoted phrases together.
+// A doubled quote inside a phrase is a literal quote.
+func queryFields(q string) (fields []queryField, err error) {
+	for i := 0; i < len(q); {
+		r, size := utf8.DecodeRuneInString(q[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '"':
+			var sb strings.Builder
+			i++
+			for {
+				end := strings.IndexByte(q[i:], '"')
+				if end < 0 {
+					return nil, fmt.Errorf("%w: unterminated string", db.ErrInvalidSearchQuery)
+				}
+				sb.WriteString(q[i : i+end])
+				i += end + 1
+				if i < len(q) && q[i] == '"' {
+					sb.WriteByte('"')
+					i++
+					continue
+				}
+				break
+			}
+			fields = append(fields, queryField{value: sb.String(), quoted: true})
+		default:
+			end := strings.IndexFunc(q[i:], unicode.IsSpace)
+			if end < 0 {
+				end = len(q) - i
+			}
+			fields = append(fields, queryField{value: q[i : i+end]})
+			i += end
+		}
+	}
+	return fields, nil
+}
+
+// indexedDocument is a document split into tokens for each search column.
+type indexedDocument struct {
+	*document
+	columns map[string][]token
+	length  int
+}
+
+func index(d *document) (id indexedDocument) {
+	id.document = d
+	id.columns = map[string][]token{
+		"title":   tokenize(d.Document.Title),
+		"text":    tokenize(d.Document.Text),
+		"summary": tokenize(d.Document.Summary),
+	}
+	for _, tokens := range id.columns {
+		id.length += len(tokens)
+	}
+	return id
+}
+
+// matches returns the indices of the tokens in the column that start a match of the term.
+func (t term) matches(tokens []token) (starts []int) {
+	for i := 0; i+len(t.words) <= len(tokens); i++ {
+		ok := true
+		for j, w := range t.words {
+			last := j == len(t.words)-1
+			if tokens[i+j].value != w && !(last && t.prefix && strings.HasPrefix(tokens[i+j].value, w)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
+
+// count returns the number of matches of the term in the document.
+func (t term) count(d indexedDocument) (n int) {
+	for _, column := range searchColumns {
+		if t.column != "" && t.column != column {
+			continue
+		}
+		n += len(t.matches(d.columns[column]))
+	}
+	return n
+}
+
+// match returns the terms of the query that matched the document, if it matches.
+func (q query) match(d indexedDocument) (matched []term, ok bool) {
+	for _, group := range q {
+		groupOK := true
+		var groupMatched []term
+		for _, t := range group {
+			found := t.count(d) > 0
+			if found == t.not {
+				groupOK = false
+				break
+			}
+			if !t.not {
+				groupMatched = append(groupMatched, t)
+			}
+		}
+		if groupOK {
+			ok = true
+			matched = append(matched, groupMatched...)
+		}
+	}
+	return matched, ok
+}
+
+type searchResult struct {
+	indexedDocument
+	matched []term
+	// bm25 score, higher is better.
+	bm25 float64
+}
+
+// search returns the documents in the partition that match the query, best first.
+func (s *Store) search(partition, q string, include func(d *document) bool) (results []searchResult, err error) {
+	parsed, err := parseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	var docs []indexedDocument
+	var totalLength int
+	for _, d := range s.documents {
+		if d.Document.Partition != partition {
+			continue
+		}
+		id := index(d)
+		docs = append(docs, id)
+		totalLength += id.length
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	avgLength := float64(totalLength) / float64(len(docs))
+
+	// Calculate the number of documents that contain each term for the IDF.
+	containing := map[*term]int{}
+	for _, group := range parsed {
+		for i := range group {
+			for _, d := range docs {
+				if group[i].count(d) > 0 {
+					containing[&group[i]]++
+				}
+			}
+		}
+	}
+
+	const k1, b = 1.2, 0.75
+	for _, d := range docs {
+		if !include(d.document) {
+			continue
+		}
+		matched, ok := parsed.match(d)
+		if !ok {
+			continue
+		}
+		r := searchResult{indexedDocument: d, matched: matched}
+		for _, group := range parsed {
+			for i := range group {
+				t := &group[i]
+				if t.not {
+					continue
+				}
+				tf := float64(t.count(d))
+				if tf == 0 {
+					continue
+				}
+				n := float64(containing[t])
+				idf := math.Max(math.Log((float64(len(docs))-n+0.5)/(n+0.5)), 1e-6)
+				r.bm25 += idf * tf * (k1 + 1) / (tf + k1*(1-b+b*float64(d.length)/avgLength))
+			}
+		}
+		results = append(results, r)
+	}
+	slices.SortStableFunc(results, func(a, b searchResult) int {
+		return cmp.Or(cmp.Compare(b.bm25, a.bm25), cmp.Compare(a.RowID, b.RowID))
+	})
+	return results, nil
+}
+
+// highlight wraps the tokens of the column text that are part of a match in start and end.
+func highlight(column, text string, matched []term, start, end string) string {
+	return snippet(column, text, matched, start, end, "", -1)
+}
+
+// snippet returns up to n tokens of the column text around the first match,
+// with matches wrapped in start and end. If n is negative, the whole text is used.
+func snippet(column, text string, matched []term, start, end, ellipsis string, n int) string {
+	tokens := tokenize(text)
+	highlighted := make([]bool, len(tokens))
+	first := -1
+	for _, t := range matched {
+		if t.column != "" && t.column != column {
+			continue
+		}
+		for _, i := range t.matches(tokens) {
+			for j := i; j < i+len(t.words); j++ {
+				highlighted[j] = true
+			}
+			if first < 0 || i < first {
+				first = i
+			}
+		}
+	}
+	from, to := 0, len(tokens)
+	if n >= 0 && len(tokens) > n {
+		from = max(first, 0)
+		// Include some context before the match.
+		from = max(min(from-n/4, len(tokens)-n), 0)
+		to = from + n
+	}
+	var sb strings.Builder
+	textStart, textEnd := 0, len(text)
+	if from > 0 {
+		sb.WriteString(ellipsis)
+		textStart = tokens[from].start
+	}
+	if to < len(tokens) {
+		textEnd = tokens[to-1].end
+	}
+	pos := textStart
+	for i := from; i < to; i++ {
+		if !highlighted[i] {
+			continue
+		}
+		sb.WriteString(text[pos:tokens[i].start])
+		sb.WriteString(start)
+		sb.WriteString(text[tokens[i].start:tokens[i].end])
+		sb.WriteString(end)
+		pos = tokens[i].end
+	}
+	sb.WriteString(text[pos:textEnd])
+	if to < len(tokens) {
+		sb.WriteString(ellipsis)
+	}
+	return sb.String()
+}
+
+func (s *Store) DocumentKeyword(ctx context.Context, args db.DocumentKeywordArgs) (docs []db.DocumentSelectNearestResult, err error) {
+	if strings.TrimSpace(args.Query) == "" {
+		return nil, nil
+	}
+	s.m.RLock()
+	defer s.m.RUnlock()
+	results, err := s.search(args.Partition, args.Query, func(d *document) bool {
+		return args.Filter.Match(d.Document.Metadata) && args.Dates.Contains(d.Document.Date())
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range results[:min(len(results), args.Limit)] {
+		docs = append(docs, db.DocumentSelectNearestResult{
+			RowID:     r.RowID,
+			Partition: r.Document.Partition,
+			Index:     -1,
+			Text:      snippet("text", r.Document.Text, r.matched, "", "", "…", 64),
+			URL:       r.Document.URL,
+			Title:     r.Document.Title,
+			Summary:   r.Document.Summary,
+			Score:     r.bm25,
+			Date:      r.Document.Date(),
+		})
+	}
+	return docs, nil
+}
+
+func (s *Store) DocumentSearch(ctx context.Context, args db.DocumentSearchArgs) (docs []db.DocumentSearchResult, err error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	results, err := s.search(args.Partition, args.Query, func(d *document) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+	if args.Offset >= len(results) {
+		return nil, nil
+	}
+	results = results[args.Offset:]
+	for _, r := range results[:min(len(results), args.Limit)] {
+		docs = append(docs, db.DocumentSearchResult{
+			RowID:            r.RowID,
+			URL:              r.Document.URL,
+			Title:            r.Document.Title,
+			Summary:          r.Document.Summary,
+			BM25:             -r.bm25,
+			TitleHighlight:   highlight("title", r.Document.Title, r.matched, args.HighlightStart, args.HighlightEnd),
+			SummaryHighlight: highlight("summary", r.Document.Summary, r.matched, args.HighlightStart, args.HighlightEnd),
+			Snippet:          snippet("text", r.Document.Text, r.matched, args.HighlightStart, args.HighlightEnd, "…", 32),
+		})
+	}
+	return docs, nil
+}
@@ -0,0 +1 @@
+alter table document drop column metadata;
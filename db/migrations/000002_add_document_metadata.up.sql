@@ -0,0 +1,3 @@
+-- Metadata is a JSON object of key/value pairs. Filters are applied to the
+-- document metadata, so that any key can be filtered on.
+alter table document add column metadata text not null default '{}';
@@ -0,0 +1,22 @@
+create table document_chunk_backup as
+select rowid as chunk_rowid, document_rowid, partition, idx, text, embedding
+from document_chunk_vec;
+
+drop table document_chunk_vec;
+
+create virtual table document_chunk_vec using vec0(
+  document_rowid integer not null references document(rowid),
+  partition text not null partition key,
+  idx integer not null,
+  +text text not null,
+  embedding float[768]
+);
+
+insert into document_chunk_vec (rowid, document_rowid, partition, idx, text, embedding)
+select chunk_rowid, document_rowid, partition, idx, text, embedding
+from document_chunk_backup;
+
+drop table document_chunk_backup;
+
+-- Chunk tables of other embedding spaces are left in place.
+drop table embedding_space;
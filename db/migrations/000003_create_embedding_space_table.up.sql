@@ -0,0 +1,45 @@
+-- Each embedding model has its own chunk table, since the dimensions of the
+-- embedding column are fixed when a vec0 table is created.
+create table embedding_space (
+  model text primary key,
+  dimensions integer not null,
+  table_name text not null unique,
+  created_at text not null
+);
+
+-- Record the model that produced each chunk, and a hash of its text, so that
+-- unchanged chunks can be found without reading their embeddings. Existing
+-- chunks were created with the default model, and get an empty hash, which
+-- is computed from their text when it's needed. Distances are cosine
+-- distances, as they are in the memory store.
+create table document_chunk_backup as
+select rowid as chunk_rowid, document_rowid, partition, idx, text, embedding
+from document_chunk_vec;
+
+drop table document_chunk_vec;
+
+create virtual table document_chunk_vec using vec0(
+  -- Each chunk belongs to a document.
+  document_rowid integer not null references document(rowid),
+
+  -- Content is sharded based on partition.
+  partition text not null partition key,
+
+  -- Metadata columns, can appear in `WHERE` clause of KNN queries.
+  idx integer not null, -- Index of the chunk in the doc.
+
+  -- Auxiliary columns, unindexed.
+  +text text not null, -- The text of the chunk.
+  +model text not null, -- The model that created the embedding.
+  +chunk_hash text not null, -- Hash of the text of the chunk.
+  embedding float[768] distance_metric=cosine
+);
+
+insert into document_chunk_vec (rowid, document_rowid, partition, idx, text, model, chunk_hash, embedding)
+select chunk_rowid, document_rowid, partition, idx, text, 'nomic-embed-text', '', embedding
+from document_chunk_backup;
+
+drop table document_chunk_backup;
+
+insert into embedding_space (model, dimensions, table_name, created_at)
+values ('nomic-embed-text', 768, 'document_chunk_vec', strftime('%Y-%m-%dT%H:%M:%SZ', 'now'));
@@ -0,0 +1,23 @@
+-- Every version of a document, including the current one.
+create table document_revision (
+  partition text not null,
+  url text not null,
+  revision integer not null,
+
+  title text not null,
+  summary text not null,
+  text text not null,
+  metadata text not null,
+
+  -- The user that submitted the revision.
+  created_by text not null,
+  created_at text not null,
+
+  primary key (partition, url, revision)
+);
+
+-- Existing documents start with a single revision.
+insert into document_revision (partition, url, revision, title, summary, text, metadata, created_by, created_at)
+select document.partition, document.url, 1, document.title, document.summary, document_fts.text, document.metadata, '', document.last_updated_at
+from document
+inner join document_fts on document_fts.rowid = document.rowid;
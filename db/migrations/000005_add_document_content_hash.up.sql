@@ -0,0 +1,2 @@
+-- Hash of the document content, used to skip unchanged documents.
+alter table document add column content_hash text not null default '';
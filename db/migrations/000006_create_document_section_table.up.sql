@@ -0,0 +1,14 @@
+-- Parent sections of a document. Chunks are embedded for matching, and the
+-- section that contains a matching chunk is used as context.
+create table document_section (
+  document_rowid integer not null references document(rowid),
+  idx integer not null,
+
+  -- The first and last chunk indexes in the section, inclusive.
+  chunk_from integer not null,
+  chunk_to integer not null,
+
+  text text not null,
+
+  primary key (document_rowid, idx)
+);
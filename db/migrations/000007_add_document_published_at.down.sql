@@ -0,0 +1 @@
+alter table document drop column published_at;
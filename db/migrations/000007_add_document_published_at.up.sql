@@ -0,0 +1,2 @@
+-- The time that the source published the document, if known.
+alter table document add column published_at text;
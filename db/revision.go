@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/rqlite/gorqlite"
+)
+
+type DocumentRevisionID struct {
+	DocumentID
+	Revision int
+}
+
+// DocumentRevision is a version of a document. A revision is recorded each
+// time that a document is put.
+type DocumentRevision struct {
+	DocumentRevisionID
+	Title    string
+	Text     string
+	Summary  string
+	Metadata map[string]any
+	// CreatedBy is the user that submitted the revision.
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+func documentRevisionInsertStatement(doc Document, user string, now time.Time) (stmt gorqlite.ParameterizedStatement, err error) {
+	metadataJSON, err := marshalMetadata(doc.Metadata)
+	if err != nil {
+		return stmt, err
+	}
+	return gorqlite.ParameterizedStatement{
+		Query: `insert into document_revision (partition, url, revision, title, summary, text, metadata, created_by, created_at)
+values (?, ?, (select coalesce(max(revision), 0) + 1 from document_revision where partition = ? and url = ?), ?, ?, ?, ?, ?, ?)`,
+		Arguments: []any{doc.Partition, doc.URL, doc.Partition, doc.URL, doc.Title, doc.Summary, doc.Text, metadataJSON, user, now},
+	}, nil
+}
+
+// DocumentRevisionList returns the revisions of a document, oldest first. The
+// Text and Metadata fields of the revisions are not populated.
+func (q *Queries) DocumentRevisionList(ctx context.Context, args DocumentID) (revisions []DocumentRevision, err error) {
+	result, err := q.conn.QueryOneParameterizedContext(ctx, gorqlite.ParameterizedStatement{
+		Query: `select partition, url, revision, title, summary, created_by, created_at
+from document_revision
+where partition = ? and url = ?
+order by revision`,
+		Arguments: []any{args.Partition, args.URL},
+	})
+	if err != nil {
+		return revisions, err
+	}
+	for result.Next() {
+		var r DocumentRevision
+		if err = result.Scan(&r.Partition, &r.URL, &r.Revision, &r.Title, &r.Summary, &r.CreatedBy, &r.CreatedAt); err != nil {
+			return revisions, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, nil
+}
+
+func (q *Queries) DocumentRevisionGet(ctx context.Context, args DocumentRevisionID) (r DocumentRevision, ok bool, err error) {
+	result, err := q.conn.QueryOneParameterizedContext(ctx, gorqlite.ParameterizedStatement{
+		Query: `select partition, url, revision, title, summary, text, metadata, created_by, created_at
+from document_revision
+where partition = ? and url = ? and revision = ?`,
+		Arguments: []any{args.Partition, args.URL, args.Revision},
+	})
+	if err != nil {
+		return r, false, err
+	}
+	if !result.Next() {
+		return r, false, nil
+	}
+	var metadataJSON string
+	if err = result.Scan(&r.Partition, &r.URL, &r.Revision, &r.Title, &r.Summary, &r.Text, &metadataJSON, &r.CreatedBy, &r.CreatedAt); err != nil {
+		return r, false, err
+	}
+	if r.Metadata, err = unmarshalMetadata(metadataJSON); err != nil {
+		return r, false, err
+	}
+	return r, true, nil
+}
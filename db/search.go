@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rqlite/gorqlite"
+)
+
+var ErrInvalidSearchQuery = errors.New("invalid search query")
+
+type DocumentSearchArgs struct {
+	Partition string
+	// Query is an FTS5 query. It supports phrases ("death star"), prefixes (plan*),
+	// boolean operators (AND, OR, NOT) and column filters (title:, text:, summary:).
+	Query  string
+	Limit  int
+	Offset int
+	// HighlightStart and HighlightEnd wrap matched terms in the highlights and
+	// snippet. The text around them isn't escaped.
+	HighlightStart string
+	HighlightEnd   string
+}
+
+type DocumentSearchResult struct {
+	RowID   int64
+	URL     string
+	Title   string
+	Summary string
+	// BM25 rank of the document, lower is better.
+	BM25 float64
+	// TitleHighlight is the title with matched terms highlighted.
+	TitleHighlight string
+	// SummaryHighlight is the summary with matched terms highlighted.
+	SummaryHighlight string
+	// Snippet is an excerpt of the text around the matched terms.
+	Snippet string
+}
+
+func (q *Queries) DocumentSearch(ctx context.Context, args DocumentSearchArgs) (docs []DocumentSearchResult, err error) {
+	stmt := gorqlite.ParameterizedStatement{
+		Query: `select
+  document_fts.rowid,
+  d.url,
+  d.title,
+  d.summary,
+  bm25(document_fts),
+  highlight(document_fts, 2, ?, ?),
+  highlight(document_fts, 4, ?, ?),
+  snippet(document_fts, 3, ?, ?, '…', 32)
+from document_fts
+inner join document d on d.rowid = document_fts.rowid
+where document_fts match ? and document_fts.partition = ?
+order by bm25(document_fts)
+limit ? offset ?`,
+		Arguments: []any{
+			args.HighlightStart, args.HighlightEnd,
+			args.HighlightStart, args.HighlightEnd,
+			args.HighlightStart, args.HighlightEnd,
+			args.Query, args.Partition,
+			args.Limit, args.Offset,
+		},
+	}
+	result, err := q.conn.QueryOneParameterizedContext(ctx, stmt)
+	if err != nil {
+		if strings.Contains(err.Error(), "fts5:") {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidSearchQuery, err)
+		}
+		return docs, err
+	}
+	for result.Next() {
+		var doc DocumentSearchResult
+		if err = result.Scan(&doc.RowID, &doc.URL, &doc.Title, &doc.Summary, &doc.BM25, &doc.TitleHighlight, &doc.SummaryHighlight, &doc.Snippet); err != nil {
+			return docs, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
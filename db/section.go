@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+
+	"github.com/rqlite/gorqlite"
+)
+
+// Section is a parent section of a document, made up of the chunks from From
+// to To inclusive. Chunks are small, so that they match precisely, and the
+// section that contains a matching chunk gives the LLM the surrounding text.
+type Section struct {
+	Text string
+	From int
+	To   int
+}
+
+func documentSectionStatements(id string, sections []Section) (statements []gorqlite.ParameterizedStatement) {
+	statements = append(statements, gorqlite.ParameterizedStatement{
+		Query:     `delete from document_section where document_rowid = ` + documentRowIDSQL,
+		Arguments: []any{id},
+	})
+	for sectionIndex, section := range sections {
+		statements = append(statements, gorqlite.ParameterizedStatement{
+			Query:     `insert into document_section (document_rowid, idx, chunk_from, chunk_to, text) values (` + documentRowIDSQL + `, ?, ?, ?, ?)`,
+			Arguments: []any{id, sectionIndex, section.From, section.To, section.Text},
+		})
+	}
+	return statements
+}
+
+type DocumentSectionArgs struct {
+	Partition string
+	// RowID of the document.
+	RowID int64
+	// Chunk is the index of a chunk in the section.
+	Chunk int64
+}
+
+// DocumentSection returns the section of the document that contains the chunk.
+// Documents that were put without sections have none.
+func (q *Queries) DocumentSection(ctx context.Context, args DocumentSectionArgs) (section Section, ok bool, err error) {
+	result, err := q.conn.QueryOneParameterizedContext(ctx, gorqlite.ParameterizedStatement{
+		Query: `select s.text, s.chunk_from, s.chunk_to
+from document_section s
+inner join document d on d.rowid = s.document_rowid
+where d.partition = ? and s.document_rowid = ? and ? between s.chunk_from and s.chunk_to`,
+		Arguments: []any{args.Partition, args.RowID, args.Chunk},
+	})
+	if err != nil {
+		return section, false, err
+	}
+	if !result.Next() {
+		return section, false, nil
+	}
+	if err = result.Scan(&section.Text, &section.From, &section.To); err != nil {
+		return section, false, err
+	}
+	return section, true, nil
+}
@@ -0,0 +1,37 @@
+package db
+
+import "context"
+
+// Store is the storage used by the server. Queries is backed by rqlite, and
+// the memory package provides an embedded alternative.
+type Store interface {
+	// DocumentPut upserts the document, replaces its chunks, and records a revision.
+	DocumentPut(ctx context.Context, args DocumentPutArgs) (id int64, err error)
+	// DocumentPutBatch puts all of the documents, or none of them, and returns their row IDs.
+	DocumentPutBatch(ctx context.Context, args []DocumentPutArgs) (ids []int64, err error)
+	// DocumentDelete deletes the document, its chunks and its revisions.
+	DocumentDelete(ctx context.Context, args DocumentID) (err error)
+	DocumentGet(ctx context.Context, args DocumentID) (doc Document, ok bool, err error)
+	// DocumentList returns documents in URL order, without their Text.
+	DocumentList(ctx context.Context, args DocumentListArgs) (docs []Document, err error)
+	// DocumentHashes returns the hashes of the documents that exist, keyed by document ID.
+	DocumentHashes(ctx context.Context, ids []DocumentID) (hashes map[DocumentID]DocumentHashes, err error)
+	// DocumentChunks returns the chunks of the document in index order.
+	DocumentChunks(ctx context.Context, args DocumentID) (chunks []Chunk, err error)
+	// DocumentChunkEmbeddings returns the embeddings of the chunks that exist.
+	DocumentChunkEmbeddings(ctx context.Context, ids []ChunkID) (embeddings map[ChunkID][]float32, err error)
+	// DocumentChunkRange returns the text of a range of the document's chunks, in index order.
+	DocumentChunkRange(ctx context.Context, args DocumentChunkRangeArgs) (texts []string, err error)
+	// DocumentSection returns the section of the document that contains the chunk, if the document has sections.
+	DocumentSection(ctx context.Context, args DocumentSectionArgs) (section Section, ok bool, err error)
+	// DocumentNearest returns the chunks closest to the embedding, closest first.
+	DocumentNearest(ctx context.Context, args DocumentSelectNearestArgs) (docs []DocumentSelectNearestResult, err error)
+	// DocumentKeyword returns the documents that match the keyword query, best first.
+	DocumentKeyword(ctx context.Context, args DocumentKeywordArgs) (docs []DocumentSelectNearestResult, err error)
+	DocumentSearch(ctx context.Context, args DocumentSearchArgs) (docs []DocumentSearchResult, err error)
+	// DocumentRevisionList returns the revisions of a document, oldest first, without their Text and Metadata.
+	DocumentRevisionList(ctx context.Context, args DocumentID) (revisions []DocumentRevision, err error)
+	DocumentRevisionGet(ctx context.Context, args DocumentRevisionID) (r DocumentRevision, ok bool, err error)
+}
+
+var _ Store = (*Queries)(nil)
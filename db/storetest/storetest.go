@@ -0,0 +1,518 @@
+// Package storetest contains tests that every db.Store implementation must pass.
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/google/go-cmp/cmp"
+)
+
+// Dimensions is the length of the embeddings that are stored by the tests.
+const Dimensions = 4
+
+// Run runs the conformance tests against the store. The store must accept
+// embeddings with Dimensions dimensions. The tests use a new partition, so
+// they can run against a store that contains other data.
+func Run(t *testing.T, store db.Store) {
+	ctx := context.Background()
+	partition := fmt.Sprintf("storetest-%d", time.Now().UnixNano())
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	newDocument := func(url, title, text string, metadata map[string]any) db.Document {
+		return db.Document{
+			DocumentID: db.DocumentID{
+				Partition: partition,
+				URL:       url,
+			},
+			Title:         title,
+			Text:          text,
+			Summary:       "Summary of " + strings.ToLower(title) + ".",
+			Metadata:      metadata,
+			ContentHash:   "hash-" + url,
+			CreatedAt:     now,
+			LastUpdatedAt: now,
+		}
+	}
+	alpha := newDocument("https://example.com/a/alpha", "Alpha", "The alpha document is about rockets.", map[string]any{"source": "wiki", "tags": []any{"space", "rockets"}, "priority": 1.0})
+	beta := newDocument("https://example.com/a/beta", "Beta", "The beta document is about boats.", map[string]any{"source": "runbooks"})
+	gamma := newDocument("https://example.com/b/gamma", "Gamma", "The gamma document is about trains and rockets.", nil)
+	gamma.PublishedAt = time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	chunks := map[string][]db.Chunk{
+		alpha.URL: {{Text: "alpha 0", Embedding: []float32{1, 0, 0, 0}}, {Text: "alpha 1", Embedding: []float32{0.9, 0.1, 0, 0}}},
+		beta.URL:  {{Text: "beta 0", Embedding: []float32{0, 1, 0, 0}}},
+		gamma.URL: {{Text: "gamma 0", Embedding: []float32{0, 0, 1, 0}}},
+	}
+	rowIDs := map[string]int64{}
+	t.Cleanup(func() {
+		for _, doc := range []db.Document{alpha, beta, gamma} {
+			_ = store.DocumentDelete(ctx, doc.DocumentID)
+		}
+	})
+
+	t.Run("Can put and get documents", func(t *testing.T) {
+		for _, doc := range []db.Document{alpha, beta, gamma} {
+			id, err := store.DocumentPut(ctx, db.DocumentPutArgs{
+				Document: doc,
+				Chunks:   chunks[doc.URL],
+				User:     "storetest",
+			})
+			if err != nil {
+				t.Fatalf("failed to put document: %v", err)
+			}
+			if id == 0 {
+				t.Errorf("expected a non-zero row ID")
+			}
+			rowIDs[doc.URL] = id
+		}
+		doc, ok, err := store.DocumentGet(ctx, alpha.DocumentID)
+		if err != nil {
+			t.Fatalf("failed to get document: %v", err)
+		}
+		if !ok {
+			t.Fatalf("document not found")
+		}
+		if diff := cmp.Diff(alpha, doc); diff != "" {
+			t.Errorf("unexpected document: %v", diff)
+		}
+	})
+	t.Run("Missing documents are not found", func(t *testing.T) {
+		_, ok, err := store.DocumentGet(ctx, db.DocumentID{Partition: partition, URL: "https://example.com/missing"})
+		if err != nil {
+			t.Fatalf("failed to get document: %v", err)
+		}
+		if ok {
+			t.Error("expected the document not to be found")
+		}
+	})
+	t.Run("Can get the hashes and chunks", func(t *testing.T) {
+		missingID := db.DocumentID{Partition: partition, URL: "https://example.com/missing"}
+		hashes, err := store.DocumentHashes(ctx, []db.DocumentID{alpha.DocumentID, beta.DocumentID, missingID})
+		if err != nil {
+			t.Fatalf("failed to get hashes: %v", err)
+		}
+		expectedHashes := map[db.DocumentID]db.DocumentHashes{
+			alpha.DocumentID: {
+				RowID:       rowIDs[alpha.URL],
+				ContentHash: alpha.ContentHash,
+				ChunkHashes: []string{db.ChunkHash("alpha 0"), db.ChunkHash("alpha 1")},
+			},
+			beta.DocumentID: {
+				RowID:       rowIDs[beta.URL],
+				ContentHash: beta.ContentHash,
+				ChunkHashes: []string{db.ChunkHash("beta 0")},
+			},
+		}
+		if diff := cmp.Diff(expectedHashes, hashes); diff != "" {
+			t.Errorf("unexpected hashes: %v", diff)
+		}
+		actual, err := store.DocumentChunks(ctx, alpha.DocumentID)
+		if err != nil {
+			t.Fatalf("failed to get chunks: %v", err)
+		}
+		if diff := cmp.Diff(chunks[alpha.URL], actual); diff != "" {
+			t.Errorf("unexpected chunks: %v", diff)
+		}
+	})
+	t.Run("Can get the embeddings of chunks", func(t *testing.T) {
+		ids := []db.ChunkID{
+			{Partition: partition, DocumentRowID: rowIDs[alpha.URL], Index: 1},
+			{Partition: partition, DocumentRowID: rowIDs[alpha.URL], Index: 5},
+			{Partition: "other", DocumentRowID: rowIDs[alpha.URL], Index: 0},
+		}
+		embeddings, err := store.DocumentChunkEmbeddings(ctx, ids)
+		if err != nil {
+			t.Fatalf("failed to get chunk embeddings: %v", err)
+		}
+		expectedEmbeddings := map[db.ChunkID][]float32{
+			ids[0]: chunks[alpha.URL][1].Embedding,
+		}
+		if diff := cmp.Diff(expectedEmbeddings, embeddings); diff != "" {
+			t.Errorf("unexpected embeddings: %v", diff)
+		}
+	})
+	t.Run("Can get a range of chunks", func(t *testing.T) {
+		texts, err := store.DocumentChunkRange(ctx, db.DocumentChunkRangeArgs{
+			Partition: partition,
+			RowID:     rowIDs[alpha.URL],
+			From:      -1,
+			To:        5,
+		})
+		if err != nil {
+			t.Fatalf("failed to get chunk range: %v", err)
+		}
+		if diff := cmp.Diff([]string{"alpha 0", "alpha 1"}, texts); diff != "" {
+			t.Errorf("unexpected texts: %v", diff)
+		}
+		texts, err = store.DocumentChunkRange(ctx, db.DocumentChunkRangeArgs{
+			Partition: partition,
+			RowID:     rowIDs[alpha.URL],
+			From:      1,
+			To:        1,
+		})
+		if err != nil {
+			t.Fatalf("failed to get chunk range: %v", err)
+		}
+		if diff := cmp.Diff([]string{"alpha 1"}, texts); diff != "" {
+			t.Errorf("unexpected texts: %v", diff)
+		}
+	})
+	t.Run("Can list documents by prefix", func(t *testing.T) {
+		docs, err := store.DocumentList(ctx, db.DocumentListArgs{
+			Partition: partition,
+			URLPrefix: "https://example.com/a/",
+			Limit:     10,
+		})
+		if err != nil {
+			t.Fatalf("failed to list documents: %v", err)
+		}
+		if diff := cmp.Diff([]string{alpha.URL, beta.URL}, urls(docs)); diff != "" {
+			t.Errorf("unexpected documents: %v", diff)
+		}
+		for _, doc := range docs {
+			if doc.Text != "" {
+				t.Errorf("expected the text of %q not to be populated", doc.URL)
+			}
+		}
+	})
+	t.Run("Can page through documents", func(t *testing.T) {
+		docs, err := store.DocumentList(ctx, db.DocumentListArgs{
+			Partition: partition,
+			After:     alpha.URL,
+			Limit:     1,
+		})
+		if err != nil {
+			t.Fatalf("failed to list documents: %v", err)
+		}
+		if diff := cmp.Diff([]string{beta.URL}, urls(docs)); diff != "" {
+			t.Errorf("unexpected documents: %v", diff)
+		}
+	})
+	t.Run("Nearest chunks are returned closest first", func(t *testing.T) {
+		results, err := store.DocumentNearest(ctx, db.DocumentSelectNearestArgs{
+			Partition: partition,
+			Embedding: []float32{1, 0, 0, 0},
+			Limit:     2,
+		})
+		if err != nil {
+			t.Fatalf("failed to find nearest chunks: %v", err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(results))
+		}
+		if results[0].Text != "alpha 0" || results[1].Text != "alpha 1" {
+			t.Errorf("expected alpha 0 and alpha 1, got %q and %q", results[0].Text, results[1].Text)
+		}
+		if results[0].URL != alpha.URL || results[0].Title != alpha.Title || results[0].Index != 0 || results[1].Index != 1 {
+			t.Errorf("unexpected result: %+v", results[0])
+		}
+		// Distances are cosine distances on every store.
+		expected := []float64{0, 1 - 0.9/math.Sqrt(0.82)}
+		for i, result := range results {
+			if math.Abs(result.Distance-expected[i]) > 1e-5 {
+				t.Errorf("expected result %d to have a cosine distance of %v, got %v", i, expected[i], result.Distance)
+			}
+		}
+	})
+	t.Run("Nearest chunks can be filtered by metadata", func(t *testing.T) {
+		filter, err := db.ParseFilter(`source = "runbooks"`)
+		if err != nil {
+			t.Fatalf("failed to parse filter: %v", err)
+		}
+		results, err := store.DocumentNearest(ctx, db.DocumentSelectNearestArgs{
+			Partition: partition,
+			Embedding: []float32{1, 0, 0, 0},
+			Limit:     10,
+			Filter:    filter,
+		})
+		if err != nil {
+			t.Fatalf("failed to find nearest chunks: %v", err)
+		}
+		if len(results) != 1 || results[0].URL != beta.URL {
+			t.Errorf("expected only %q, got %v", beta.URL, results)
+		}
+	})
+	t.Run("Nearest chunks and keyword matches can be filtered by any metadata key", func(t *testing.T) {
+		filter, err := db.ParseFilter(`tags has "space" and priority >= 1 and source != "runbooks"`)
+		if err != nil {
+			t.Fatalf("failed to parse filter: %v", err)
+		}
+		nearest, err := store.DocumentNearest(ctx, db.DocumentSelectNearestArgs{
+			Partition: partition,
+			Embedding: []float32{0, 0, 1, 0},
+			Limit:     10,
+			Filter:    filter,
+		})
+		if err != nil {
+			t.Fatalf("failed to find nearest chunks: %v", err)
+		}
+		if len(nearest) != 2 || nearest[0].URL != alpha.URL || nearest[1].URL != alpha.URL {
+			t.Errorf("expected only the chunks of %q, got %v", alpha.URL, nearest)
+		}
+		keyword, err := store.DocumentKeyword(ctx, db.DocumentKeywordArgs{
+			Partition: partition,
+			Query:     db.KeywordQuery("rockets"),
+			Limit:     10,
+			Filter:    filter,
+		})
+		if err != nil {
+			t.Fatalf("failed keyword search: %v", err)
+		}
+		if len(keyword) != 1 || keyword[0].URL != alpha.URL {
+			t.Errorf("expected only %q, got %v", alpha.URL, keyword)
+		}
+	})
+	t.Run("Nearest chunks and keyword matches can be filtered by date", func(t *testing.T) {
+		// Gamma was published before it was created.
+		dates := db.DateRange{Until: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+		nearest, err := store.DocumentNearest(ctx, db.DocumentSelectNearestArgs{
+			Partition: partition,
+			Embedding: []float32{1, 0, 0, 0},
+			Limit:     10,
+			Dates:     dates,
+		})
+		if err != nil {
+			t.Fatalf("failed to find nearest chunks: %v", err)
+		}
+		if len(nearest) != 1 || nearest[0].URL != gamma.URL {
+			t.Fatalf("expected only %q, got %v", gamma.URL, nearest)
+		}
+		if !nearest[0].Date.Equal(gamma.PublishedAt) {
+			t.Errorf("expected the date to be the published date %v, got %v", gamma.PublishedAt, nearest[0].Date)
+		}
+		keyword, err := store.DocumentKeyword(ctx, db.DocumentKeywordArgs{
+			Partition: partition,
+			Query:     db.KeywordQuery("rockets"),
+			Limit:     10,
+			Dates:     db.DateRange{Since: now},
+		})
+		if err != nil {
+			t.Fatalf("failed keyword search: %v", err)
+		}
+		if len(keyword) != 1 || keyword[0].URL != alpha.URL {
+			t.Fatalf("expected only %q, got %v", alpha.URL, keyword)
+		}
+		if !keyword[0].Date.Equal(alpha.CreatedAt) {
+			t.Errorf("expected the date to be the creation date %v, got %v", alpha.CreatedAt, keyword[0].Date)
+		}
+	})
+	t.Run("Keyword search finds matching documents", func(t *testing.T) {
+		results, err := store.DocumentKeyword(ctx, db.DocumentKeywordArgs{
+			Partition: partition,
+			Query:     db.KeywordQuery("rockets"),
+			Limit:     10,
+		})
+		if err != nil {
+			t.Fatalf("failed keyword search: %v", err)
+		}
+		var actual []string
+		for _, r := range results {
+			actual = append(actual, r.URL)
+			if r.Index != -1 {
+				t.Errorf("expected keyword results to have an index of -1, got %d", r.Index)
+			}
+			if r.Score <= 0 {
+				t.Errorf("expected a positive score, got %v", r.Score)
+			}
+		}
+		if diff := cmp.Diff([]string{alpha.URL, gamma.URL}, actual, sortStrings); diff != "" {
+			t.Errorf("unexpected results: %v", diff)
+		}
+	})
+	t.Run("Search highlights matches", func(t *testing.T) {
+		results, err := store.DocumentSearch(ctx, db.DocumentSearchArgs{
+			Partition:      partition,
+			Query:          "beta",
+			Limit:          10,
+			HighlightStart: "[",
+			HighlightEnd:   "]",
+		})
+		if err != nil {
+			t.Fatalf("failed search: %v", err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+		if results[0].TitleHighlight != "[Beta]" {
+			t.Errorf("unexpected title highlight %q", results[0].TitleHighlight)
+		}
+		if !strings.Contains(results[0].Snippet, "[beta]") {
+			t.Errorf("expected the snippet to contain a highlight, got %q", results[0].Snippet)
+		}
+	})
+	t.Run("Updates replace chunks and add revisions", func(t *testing.T) {
+		updated := alpha
+		updated.Text = "The alpha document is about satellites."
+		updated.CreatedAt = now.Add(time.Hour)
+		updated.LastUpdatedAt = now.Add(time.Hour)
+		updatedChunks := []db.Chunk{{Text: "alpha updated", Embedding: []float32{1, 1, 0, 0}}}
+		id, err := store.DocumentPut(ctx, db.DocumentPutArgs{
+			Document: updated,
+			Chunks:   updatedChunks,
+			User:     "storetest",
+		})
+		if err != nil {
+			t.Fatalf("failed to put document: %v", err)
+		}
+		if id != rowIDs[alpha.URL] {
+			t.Errorf("expected the row ID to be kept, got %d, expected %d", id, rowIDs[alpha.URL])
+		}
+		actual, err := store.DocumentChunks(ctx, alpha.DocumentID)
+		if err != nil {
+			t.Fatalf("failed to get chunks: %v", err)
+		}
+		if diff := cmp.Diff(updatedChunks, actual); diff != "" {
+			t.Errorf("unexpected chunks: %v", diff)
+		}
+		doc, _, err := store.DocumentGet(ctx, alpha.DocumentID)
+		if err != nil {
+			t.Fatalf("failed to get document: %v", err)
+		}
+		if !doc.CreatedAt.Equal(alpha.CreatedAt) || !doc.LastUpdatedAt.Equal(updated.LastUpdatedAt) {
+			t.Errorf("expected the creation time to be kept, and the update time to change, got %v and %v", doc.CreatedAt, doc.LastUpdatedAt)
+		}
+		revisions, err := store.DocumentRevisionList(ctx, alpha.DocumentID)
+		if err != nil {
+			t.Fatalf("failed to list revisions: %v", err)
+		}
+		if len(revisions) != 2 {
+			t.Fatalf("expected 2 revisions, got %d", len(revisions))
+		}
+		if revisions[0].Revision != 1 || revisions[1].Revision != 2 {
+			t.Errorf("expected revisions 1 and 2, got %d and %d", revisions[0].Revision, revisions[1].Revision)
+		}
+		first, ok, err := store.DocumentRevisionGet(ctx, db.DocumentRevisionID{DocumentID: alpha.DocumentID, Revision: 1})
+		if err != nil {
+			t.Fatalf("failed to get revision: %v", err)
+		}
+		if !ok {
+			t.Fatalf("revision not found")
+		}
+		if first.Text != alpha.Text || first.CreatedBy != "storetest" {
+			t.Errorf("unexpected revision: %+v", first)
+		}
+	})
+	t.Run("Can put documents in a batch", func(t *testing.T) {
+		delta := newDocument("https://example.com/b/delta", "Delta", "The delta document is about rivers.", nil)
+		epsilon := newDocument("https://example.com/b/epsilon", "Epsilon", "The epsilon document is about lakes.", nil)
+		t.Cleanup(func() {
+			_ = store.DocumentDelete(ctx, delta.DocumentID)
+			_ = store.DocumentDelete(ctx, epsilon.DocumentID)
+		})
+		ids, err := store.DocumentPutBatch(ctx, []db.DocumentPutArgs{
+			{Document: delta, Chunks: []db.Chunk{{Text: "delta 0", Embedding: []float32{0, 0, 0, 1}}}, User: "storetest"},
+			{Document: epsilon, Chunks: []db.Chunk{{Text: "epsilon 0", Embedding: []float32{0, 0, 1, 1}}}, User: "storetest"},
+		})
+		if err != nil {
+			t.Fatalf("failed to put batch: %v", err)
+		}
+		if len(ids) != 2 || ids[0] == 0 || ids[1] == 0 || ids[0] == ids[1] {
+			t.Fatalf("expected 2 distinct non-zero row IDs, got %v", ids)
+		}
+		hashes, err := store.DocumentHashes(ctx, []db.DocumentID{delta.DocumentID, epsilon.DocumentID})
+		if err != nil {
+			t.Fatalf("failed to get hashes: %v", err)
+		}
+		for i, doc := range []db.Document{delta, epsilon} {
+			h, ok := hashes[doc.DocumentID]
+			if !ok {
+				t.Fatalf("expected to find %q", doc.URL)
+			}
+			if h.RowID != ids[i] {
+				t.Errorf("expected row ID %d for %q, got %d", ids[i], doc.URL, h.RowID)
+			}
+			actual, err := store.DocumentChunks(ctx, doc.DocumentID)
+			if err != nil {
+				t.Fatalf("failed to get chunks: %v", err)
+			}
+			if len(actual) != 1 {
+				t.Errorf("expected 1 chunk for %q, got %d", doc.URL, len(actual))
+			}
+		}
+	})
+	t.Run("Can get the section that contains a chunk", func(t *testing.T) {
+		zeta := newDocument("https://example.com/b/zeta", "Zeta", "# One\n\nzeta 0 zeta 1\n\n# Two\n\nzeta 2", nil)
+		t.Cleanup(func() {
+			_ = store.DocumentDelete(ctx, zeta.DocumentID)
+		})
+		sections := []db.Section{
+			{Text: "# One\n\nzeta 0 zeta 1", From: 0, To: 1},
+			{Text: "# Two\n\nzeta 2", From: 2, To: 2},
+		}
+		id, err := store.DocumentPut(ctx, db.DocumentPutArgs{
+			Document: zeta,
+			Chunks: []db.Chunk{
+				{Text: "zeta 0", Embedding: []float32{1, 1, 0, 0}},
+				{Text: "zeta 1", Embedding: []float32{1, 1, 1, 0}},
+				{Text: "zeta 2", Embedding: []float32{1, 1, 1, 1}},
+			},
+			Sections: sections,
+			User:     "storetest",
+		})
+		if err != nil {
+			t.Fatalf("failed to put document: %v", err)
+		}
+		for chunk, expected := range []db.Section{sections[0], sections[0], sections[1]} {
+			section, ok, err := store.DocumentSection(ctx, db.DocumentSectionArgs{Partition: partition, RowID: id, Chunk: int64(chunk)})
+			if err != nil {
+				t.Fatalf("failed to get section: %v", err)
+			}
+			if !ok {
+				t.Fatalf("section not found for chunk %d", chunk)
+			}
+			if diff := cmp.Diff(expected, section); diff != "" {
+				t.Errorf("unexpected section for chunk %d: %v", chunk, diff)
+			}
+		}
+		if _, ok, err := store.DocumentSection(ctx, db.DocumentSectionArgs{Partition: partition, RowID: rowIDs[alpha.URL], Chunk: 0}); err != nil || ok {
+			t.Errorf("expected documents without sections to have none, got ok=%v, err=%v", ok, err)
+		}
+		if _, ok, err := store.DocumentSection(ctx, db.DocumentSectionArgs{Partition: "other", RowID: id, Chunk: 0}); err != nil || ok {
+			t.Errorf("expected sections in other partitions not to be found, got ok=%v, err=%v", ok, err)
+		}
+	})
+	t.Run("Deleted documents are removed with their chunks and revisions", func(t *testing.T) {
+		if err := store.DocumentDelete(ctx, beta.DocumentID); err != nil {
+			t.Fatalf("failed to delete document: %v", err)
+		}
+		if _, ok, err := store.DocumentGet(ctx, beta.DocumentID); err != nil || ok {
+			t.Errorf("expected the document to be deleted, got ok=%v, err=%v", ok, err)
+		}
+		if actual, err := store.DocumentChunks(ctx, beta.DocumentID); err != nil || len(actual) != 0 {
+			t.Errorf("expected the chunks to be deleted, got %v, err=%v", actual, err)
+		}
+		if revisions, err := store.DocumentRevisionList(ctx, beta.DocumentID); err != nil || len(revisions) != 0 {
+			t.Errorf("expected the revisions to be deleted, got %v, err=%v", revisions, err)
+		}
+		results, err := store.DocumentKeyword(ctx, db.DocumentKeywordArgs{
+			Partition: partition,
+			Query:     db.KeywordQuery("beta"),
+			Limit:     10,
+		})
+		if err != nil {
+			t.Fatalf("failed keyword search: %v", err)
+		}
+		if len(results) != 0 {
+			t.Errorf("expected no keyword results, got %v", results)
+		}
+	})
+}
+
+var sortStrings = cmp.Transformer("sort", func(in []string) []string {
+	out := append([]string(nil), in...)
+	slices.Sort(out)
+	return out
+})
+
+func urls(docs []db.Document) (urls []string) {
+	for _, doc := range docs {
+		urls = append(urls, doc.URL)
+	}
+	return urls
+}
@@ -3,28 +3,62 @@ package post
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"slices"
+	"strconv"
 	"time"
 
 	"github.com/a-h/ragserver/auth"
+	"github.com/a-h/ragserver/collections"
+	"github.com/a-h/ragserver/db"
 	"github.com/a-h/ragserver/models"
+	"github.com/a-h/ragserver/retrieval"
+	"github.com/a-h/ragserver/tokens"
 	"github.com/a-h/respond"
 	"github.com/tmc/langchaingo/llms"
 )
 
-func New(log *slog.Logger, llm llms.Model) Handler {
+func New(log *slog.Logger, retriever *retrieval.Retriever, llm llms.Model, maxContextDocs int, userPrompt func(query string, context string) (string, error)) Handler {
 	return Handler{
-		log: log,
-		llm: llm,
+		log:            log,
+		retriever:      retriever,
+		llm:            llm,
+		maxContextDocs: maxContextDocs,
+		userPrompt:     userPrompt,
+		counter:        tokens.Approximate{CharsPerToken: 4},
 	}
 }
 
 type Handler struct {
-	log *slog.Logger
-	llm llms.Model
+	log            *slog.Logger
+	retriever      *retrieval.Retriever
+	llm            llms.Model
+	maxContextDocs int
+	userPrompt     func(query string, context string) (string, error)
+	counter        tokens.Counter
+	contextBudget  int
+	collections    collections.Collections
+}
+
+// WithContextBudget returns a handler that counts tokens with the counter, and
+// packs as much context into the prompt as fits in budget tokens, after the
+// conversation. If the budget is zero, the prompt includes all of the
+// retrieved context.
+func (h Handler) WithContextBudget(counter tokens.Counter, budget int) Handler {
+	h.counter = counter
+	h.contextBudget = budget
+	return h
+}
+
+// WithCollections returns a handler that can search the shared collections
+// that the user has been granted read access to.
+func (h Handler) WithCollections(collections collections.Collections) Handler {
+	h.collections = collections
+	return h
 }
 
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -48,8 +82,33 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	messages := req.Messages
+	if req.RAG != nil {
+		cols, err := h.collections.GetAll(user, req.RAG.Collections)
+		if err != nil {
+			respond.WithError(w, err.Error(), collections.StatusCode(err))
+			return
+		}
+		var counts retrieval.TokenCounts
+		messages, counts, err = h.addContext(r.Context(), cols, req)
+		if errors.Is(err, retrieval.ErrInvalidArgs) {
+			respond.WithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			h.log.Error("failed to add context", slog.Any("error", err))
+			respond.WithError(w, "failed to add context", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Prompt-Tokens", strconv.Itoa(counts.Total()))
+		w.Header().Set("X-Context-Tokens", strconv.Itoa(counts.Context))
+		if counts.Budget > 0 {
+			w.Header().Set("X-Context-Budget", strconv.Itoa(counts.Budget))
+		}
+	}
+
 	var msgs []llms.MessageContent
-	for _, m := range req.Messages {
+	for _, m := range messages {
 		msgs = append(msgs, llms.TextParts(llms.ChatMessageType(m.Type), m.Content))
 	}
 
@@ -78,6 +137,64 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// addContext condenses the conversation into a standalone question, retrieves
+// context for it, and returns a copy of the messages where the latest human
+// message includes the context, with the number of tokens in the prompt.
+func (h Handler) addContext(ctx context.Context, cols []collections.Collection, req models.ChatPostRequest) (messages []models.ChatMessage, counts retrieval.TokenCounts, err error) {
+	question, err := retrieval.Condense(ctx, h.llm, req.Messages)
+	if err != nil {
+		return nil, counts, err
+	}
+	//TODO: Add metrics for query time. Use partition as a dimension.
+	docs, err := h.retriever.Retrieve(ctx, retrieval.Args{
+		Partitions:  collections.Partitions(cols),
+		Text:        question,
+		Mode:        req.RAG.Mode,
+		Limit:       h.maxContextDocs,
+		Filter:      req.RAG.Filter,
+		MaxDistance: req.RAG.MaxDistance,
+		Transform:   req.RAG.Transform,
+	})
+	if err != nil {
+		return nil, counts, err
+	}
+
+	// Condense has checked that there's a human message.
+	messages = slices.Clone(req.Messages)
+	latest := len(messages) - 1
+	for messages[latest].Type != models.ChatMessageTypeHuman {
+		latest--
+	}
+	basePrompt, err := h.userPrompt(messages[latest].Content, "")
+	if err != nil {
+		return nil, counts, fmt.Errorf("failed to generate prompt: %w", err)
+	}
+	promptTokens := h.counter.Count(basePrompt)
+	for i, m := range messages {
+		if i != latest {
+			promptTokens += h.counter.Count(m.Content)
+		}
+	}
+	docs, counts = retrieval.PackContext(h.counter, h.contextBudget, promptTokens, docs)
+	if counts.Budget > 0 && counts.Prompt > counts.Budget {
+		return nil, counts, fmt.Errorf("%w: the conversation is too long, it has %d tokens, which is more than the budget of %d", retrieval.ErrInvalidArgs, counts.Prompt, counts.Budget)
+	}
+	if messages[latest].Content, err = h.userPrompt(messages[latest].Content, retrieval.FormatContext(docs)); err != nil {
+		return nil, counts, fmt.Errorf("failed to generate prompt: %w", err)
+	}
+
+	docIDs := make([]db.DocumentID, len(docs))
+	for i, doc := range docs {
+		docIDs[i] = db.DocumentID{
+			Partition: doc.Partition,
+			URL:       doc.URL,
+		}
+	}
+	h.log.Info("chat context", slog.String("question", question), slog.Any("docs", docIDs),
+		slog.Int("promptTokens", counts.Prompt), slog.Int("contextTokens", counts.Context), slog.Int("budget", counts.Budget))
+	return messages, counts, nil
+}
+
 const TestMessage = `Hello!
 
 I'm a test message.
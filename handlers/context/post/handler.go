@@ -2,31 +2,38 @@ package post
 
 import (
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 
 	"github.com/a-h/ragserver/auth"
+	"github.com/a-h/ragserver/collections"
 	"github.com/a-h/ragserver/db"
 	"github.com/a-h/ragserver/models"
+	"github.com/a-h/ragserver/retrieval"
 	"github.com/a-h/respond"
-	"github.com/tmc/langchaingo/embeddings"
-	"github.com/tmc/langchaingo/llms"
 )
 
-func New(log *slog.Logger, embedder embeddings.Embedder, llm llms.Model, queries *db.Queries, maxContextDocs int) Handler {
+func New(log *slog.Logger, retriever *retrieval.Retriever, maxContextDocs int) Handler {
 	return Handler{
 		log:            log,
-		embedder:       embedder,
-		queries:        queries,
+		retriever:      retriever,
 		maxContextDocs: maxContextDocs,
 	}
 }
 
 type Handler struct {
 	log            *slog.Logger
-	embedder       embeddings.Embedder
-	queries        *db.Queries
+	retriever      *retrieval.Retriever
 	maxContextDocs int
+	collections    collections.Collections
+}
+
+// WithCollections returns a handler that can search the shared collections
+// that the user has been granted read access to.
+func (h Handler) WithCollections(collections collections.Collections) Handler {
+	h.collections = collections
+	return h
 }
 
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -44,24 +51,45 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cols, err := h.collections.GetAll(user, req.Collections)
+	if err != nil {
+		respond.WithError(w, err.Error(), collections.StatusCode(err))
+		return
+	}
+
 	var docs []db.DocumentSelectNearestResult
 
 	// If this is a test API key, don't use the LLM.
 	if req.Text != "" && user != "test-user-no-llm" {
-		embedding, err := h.embedder.EmbedQuery(r.Context(), req.Text)
-		if err != nil {
-			h.log.Error("failed to embed query", slog.Any("error", err))
-			respond.WithError(w, "failed to embed query", http.StatusInternalServerError)
-			return
-		}
-
 		//TODO: Add metrics for query time. Use partition as a dimension.
 		// Find the most similar documents.
-		docs, err = h.queries.DocumentNearest(r.Context(), db.DocumentSelectNearestArgs{
-			Partition: user,
-			Embedding: embedding,
-			Limit:     h.maxContextDocs,
-		})
+		args := retrieval.Args{
+			Partitions:      collections.Partitions(cols),
+			Text:            req.Text,
+			Mode:            req.Mode,
+			Limit:           h.maxContextDocs,
+			Filter:          req.Filter,
+			MaxChunksPerURL: req.MaxChunksPerURL,
+			MaxDistance:     req.MaxDistance,
+			Transform:       req.Transform,
+			Expand:          req.Expand,
+			Recency:         retrieval.NewRecency(req.Recency),
+		}
+		if req.MMR != nil {
+			args.MMR = true
+			args.MMRLambda = req.MMR.Lambda
+		}
+		if req.Since != nil {
+			args.Dates.Since = *req.Since
+		}
+		if req.Until != nil {
+			args.Dates.Until = *req.Until
+		}
+		docs, err = h.retriever.Retrieve(r.Context(), args)
+		if errors.Is(err, retrieval.ErrInvalidArgs) {
+			respond.WithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			h.log.Error("failed to find nearest documents", slog.Any("error", err))
 			respond.WithError(w, "failed to find nearest documents", http.StatusInternalServerError)
@@ -72,12 +100,15 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var qpr models.ContextPostResponse
 	for _, doc := range docs {
 		qpr.Results = append(qpr.Results, models.ContextDocument{
-			Text:      doc.Text,
-			Embedding: doc.Embedding,
-			Distance:  doc.Distance,
-			URL:       doc.URL,
-			Title:     doc.Title,
-			Summary:   doc.Summary,
+			Text:       doc.Text,
+			Embedding:  doc.Embedding,
+			Distance:   doc.Distance,
+			Score:      doc.Score,
+			URL:        doc.URL,
+			Title:      doc.Title,
+			Summary:    doc.Summary,
+			Collection: collections.Name(cols, doc.Partition),
+			Date:       doc.Date,
 		})
 	}
 
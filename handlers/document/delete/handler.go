@@ -0,0 +1,54 @@
+package delete
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/a-h/ragserver/collections"
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/respond"
+)
+
+func New(log *slog.Logger, store db.Store) Handler {
+	return Handler{
+		log:   log,
+		store: store,
+	}
+}
+
+type Handler struct {
+	log   *slog.Logger
+	store db.Store
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	partition, ok := collections.GetPartition(r)
+	if !ok {
+		http.Error(w, "collection not provided", http.StatusInternalServerError)
+		return
+	}
+
+	id := db.DocumentID{
+		Partition: partition,
+		URL:       r.PathValue("url"),
+	}
+	_, ok, err := h.store.DocumentGet(r.Context(), id)
+	if err != nil {
+		h.log.Error("failed to get document", slog.Any("error", err))
+		respond.WithError(w, "failed to get document", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		respond.WithError(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	if err = h.store.DocumentDelete(r.Context(), id); err != nil {
+		h.log.Error("failed to delete document", slog.Any("error", err))
+		respond.WithError(w, "failed to delete document", http.StatusInternalServerError)
+		return
+	}
+	h.log.Info("document deleted", slog.Any("id", id))
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,63 @@
+package get
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/a-h/ragserver/collections"
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/models"
+	"github.com/a-h/respond"
+)
+
+func New(log *slog.Logger, store db.Store) Handler {
+	return Handler{
+		log:   log,
+		store: store,
+	}
+}
+
+type Handler struct {
+	log   *slog.Logger
+	store db.Store
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	partition, ok := collections.GetPartition(r)
+	if !ok {
+		http.Error(w, "collection not provided", http.StatusInternalServerError)
+		return
+	}
+
+	doc, ok, err := h.store.DocumentGet(r.Context(), db.DocumentID{
+		Partition: partition,
+		URL:       r.PathValue("url"),
+	})
+	if err != nil {
+		h.log.Error("failed to get document", slog.Any("error", err))
+		respond.WithError(w, "failed to get document", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		respond.WithError(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	var publishedAt *time.Time
+	if !doc.PublishedAt.IsZero() {
+		publishedAt = &doc.PublishedAt
+	}
+	respond.WithJSON(w, models.DocumentGetResponse{
+		Document: models.Document{
+			URL:         doc.URL,
+			Title:       doc.Title,
+			Text:        doc.Text,
+			Summary:     doc.Summary,
+			Metadata:    doc.Metadata,
+			PublishedAt: publishedAt,
+		},
+		CreatedAt:     doc.CreatedAt,
+		LastUpdatedAt: doc.LastUpdatedAt,
+	}, http.StatusOK)
+}
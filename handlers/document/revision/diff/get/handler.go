@@ -0,0 +1,102 @@
+package get
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/a-h/ragserver/collections"
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/models"
+	"github.com/a-h/ragserver/textdiff"
+	"github.com/a-h/respond"
+)
+
+// maxLines is the maximum number of lines in both revisions. The time taken
+// to diff them grows with the number of lines multiplied by the number of
+// changed lines.
+const maxLines = 10_000
+
+func New(log *slog.Logger, store db.Store) Handler {
+	return Handler{
+		log:   log,
+		store: store,
+	}
+}
+
+type Handler struct {
+	log   *slog.Logger
+	store db.Store
+}
+
+// ServeHTTP returns the diff between the revision in the path and the
+// revision in the "to" query parameter, which defaults to the latest revision.
+// If the revisions have more than maxLines lines between them, the request is
+// rejected as unprocessable.
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	partition, ok := collections.GetPartition(r)
+	if !ok {
+		http.Error(w, "collection not provided", http.StatusInternalServerError)
+		return
+	}
+
+	id := db.DocumentID{
+		Partition: partition,
+		URL:       r.PathValue("url"),
+	}
+	from, err := strconv.Atoi(r.PathValue("revision"))
+	if err != nil {
+		respond.WithError(w, "invalid revision", http.StatusBadRequest)
+		return
+	}
+	var to int
+	if s := r.URL.Query().Get("to"); s != "" {
+		to, err = strconv.Atoi(s)
+		if err != nil {
+			respond.WithError(w, "invalid to revision", http.StatusBadRequest)
+			return
+		}
+	} else {
+		revisions, err := h.store.DocumentRevisionList(r.Context(), id)
+		if err != nil {
+			h.log.Error("failed to list revisions", slog.Any("error", err))
+			respond.WithError(w, "failed to list revisions", http.StatusInternalServerError)
+			return
+		}
+		if len(revisions) == 0 {
+			respond.WithError(w, "document not found", http.StatusNotFound)
+			return
+		}
+		to = revisions[len(revisions)-1].Revision
+	}
+
+	var texts [2]string
+	for i, revision := range []int{from, to} {
+		rev, ok, err := h.store.DocumentRevisionGet(r.Context(), db.DocumentRevisionID{
+			DocumentID: id,
+			Revision:   revision,
+		})
+		if err != nil {
+			h.log.Error("failed to get revision", slog.Any("error", err))
+			respond.WithError(w, "failed to get revision", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			respond.WithError(w, fmt.Sprintf("revision %d not found", revision), http.StatusNotFound)
+			return
+		}
+		texts[i] = rev.Text
+	}
+	if lines := strings.Count(texts[0], "\n") + strings.Count(texts[1], "\n"); lines > maxLines {
+		respond.WithError(w, fmt.Sprintf("revisions have %d lines, which is more than the %d lines that can be diffed", lines, maxLines), http.StatusUnprocessableEntity)
+		return
+	}
+
+	respond.WithJSON(w, models.DocumentRevisionDiffResponse{
+		From: from,
+		To:   to,
+		Diff: textdiff.Unified(fmt.Sprintf("revision %d", from), fmt.Sprintf("revision %d", to), texts[0], texts[1], 3),
+	}, http.StatusOK)
+}
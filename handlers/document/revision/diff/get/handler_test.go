@@ -0,0 +1,79 @@
+package get
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/a-h/ragserver/auth"
+	"github.com/a-h/ragserver/collections"
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/db/memory"
+	"github.com/a-h/ragserver/models"
+)
+
+func TestHandler(t *testing.T) {
+	store, err := memory.New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	put := func(url, text string) {
+		_, err := store.DocumentPut(context.Background(), db.DocumentPutArgs{
+			Document: db.Document{DocumentID: db.DocumentID{Partition: "alice", URL: url}, Text: text},
+		})
+		if err != nil {
+			t.Fatalf("failed to put document: %v", err)
+		}
+	}
+	put("small", "a\nb\n")
+	put("small", "a\nc\n")
+	put("large", strings.Repeat("a\n", maxLines/2))
+	put("large", strings.Repeat("b\n", maxLines/2+1))
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mux := http.NewServeMux()
+	mux.Handle("GET /documents/{url}/revisions/{revision}/diff", collections.New(nil, New(log, store)))
+	server := httptest.NewServer(auth.New(map[string]string{"key": "alice"}, mux))
+	defer server.Close()
+
+	get := func(documentURL string) *http.Response {
+		r, err := http.NewRequest(http.MethodGet, server.URL+"/documents/"+url.PathEscape(documentURL)+"/revisions/1/diff", nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		r.Header.Set("Authorization", "Bearer key")
+		resp, err := server.Client().Do(r)
+		if err != nil {
+			t.Fatalf("failed to get diff: %v", err)
+		}
+		return resp
+	}
+
+	t.Run("revisions are diffed against the latest revision", func(t *testing.T) {
+		resp := get("small")
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+		var diff models.DocumentRevisionDiffResponse
+		if err := json.NewDecoder(resp.Body).Decode(&diff); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if expected := "--- revision 1\n+++ revision 2\n@@ -1,2 +1,2 @@\n a\n-b\n+c\n"; diff.Diff != expected {
+			t.Errorf("expected diff:\n%s\ngot:\n%s", expected, diff.Diff)
+		}
+	})
+	t.Run("revisions that are too large to diff are rejected", func(t *testing.T) {
+		resp := get("large")
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("expected status 422, got %d", resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package get
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/a-h/ragserver/collections"
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/models"
+	"github.com/a-h/respond"
+)
+
+func New(log *slog.Logger, store db.Store) Handler {
+	return Handler{
+		log:   log,
+		store: store,
+	}
+}
+
+type Handler struct {
+	log   *slog.Logger
+	store db.Store
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	partition, ok := collections.GetPartition(r)
+	if !ok {
+		http.Error(w, "collection not provided", http.StatusInternalServerError)
+		return
+	}
+
+	revision, err := strconv.Atoi(r.PathValue("revision"))
+	if err != nil {
+		respond.WithError(w, "invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	rev, ok, err := h.store.DocumentRevisionGet(r.Context(), db.DocumentRevisionID{
+		DocumentID: db.DocumentID{
+			Partition: partition,
+			URL:       r.PathValue("url"),
+		},
+		Revision: revision,
+	})
+	if err != nil {
+		h.log.Error("failed to get revision", slog.Any("error", err))
+		respond.WithError(w, "failed to get revision", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		respond.WithError(w, "revision not found", http.StatusNotFound)
+		return
+	}
+
+	respond.WithJSON(w, models.DocumentRevisionGetResponse{
+		Revision: rev.Revision,
+		Document: models.Document{
+			URL:      rev.URL,
+			Title:    rev.Title,
+			Text:     rev.Text,
+			Summary:  rev.Summary,
+			Metadata: rev.Metadata,
+		},
+		CreatedBy: rev.CreatedBy,
+		CreatedAt: rev.CreatedAt,
+	}, http.StatusOK)
+}
@@ -0,0 +1,107 @@
+package post
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/a-h/ragserver/auth"
+	"github.com/a-h/ragserver/collections"
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/ingest"
+	"github.com/a-h/ragserver/models"
+	"github.com/a-h/respond"
+)
+
+func New(log *slog.Logger, store db.Store, ingester *ingest.Ingester) Handler {
+	return Handler{
+		log:      log,
+		store:    store,
+		ingester: ingester,
+	}
+}
+
+type Handler struct {
+	log      *slog.Logger
+	store    db.Store
+	ingester *ingest.Ingester
+}
+
+// ServeHTTP handles custom methods on a revision, e.g. POST /documents/{url}/revisions/3:restore.
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUser(r)
+	if !ok {
+		http.Error(w, "authentication not provided", http.StatusUnauthorized)
+		return
+	}
+	partition, ok := collections.GetPartition(r)
+	if !ok {
+		http.Error(w, "collection not provided", http.StatusInternalServerError)
+		return
+	}
+
+	revisionString, ok := strings.CutSuffix(r.PathValue("revision"), ":restore")
+	if !ok {
+		respond.WithError(w, "not found", http.StatusNotFound)
+		return
+	}
+	revision, err := strconv.Atoi(revisionString)
+	if err != nil {
+		respond.WithError(w, "invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	rev, ok, err := h.store.DocumentRevisionGet(r.Context(), db.DocumentRevisionID{
+		DocumentID: db.DocumentID{
+			Partition: partition,
+			URL:       r.PathValue("url"),
+		},
+		Revision: revision,
+	})
+	if err != nil {
+		h.log.Error("failed to get revision", slog.Any("error", err))
+		respond.WithError(w, "failed to get revision", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		respond.WithError(w, "revision not found", http.StatusNotFound)
+		return
+	}
+
+	// Revisions don't record the publication time, so the current one is kept.
+	current, ok, err := h.store.DocumentGet(r.Context(), rev.DocumentID)
+	if err != nil {
+		h.log.Error("failed to get document", slog.Any("error", err))
+		respond.WithError(w, "failed to get document", http.StatusInternalServerError)
+		return
+	}
+	doc := models.Document{
+		URL:      rev.URL,
+		Title:    rev.Title,
+		Text:     rev.Text,
+		Summary:  rev.Summary,
+		Metadata: rev.Metadata,
+	}
+	if ok && !current.PublishedAt.IsZero() {
+		doc.PublishedAt = &current.PublishedAt
+	}
+
+	// Restoring puts the old content as a new revision. Chunks that match the current content aren't re-embedded.
+	result, err := h.ingester.Put(r.Context(), ingest.PutArgs{
+		Partition: partition,
+		User:      user,
+		Document:  doc,
+	})
+	if err != nil {
+		h.log.Error("failed to restore revision", slog.Any("error", err))
+		respond.WithError(w, "failed to restore revision", http.StatusInternalServerError)
+		return
+	}
+	h.log.Info("revision restored", slog.String("url", rev.URL), slog.Int("revision", revision))
+
+	respond.WithJSON(w, models.DocumentRevisionRestoreResponse{
+		ID:       result.ID,
+		Restored: revision,
+	}, http.StatusOK)
+}
@@ -0,0 +1,60 @@
+package get
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/a-h/ragserver/collections"
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/models"
+	"github.com/a-h/respond"
+)
+
+func New(log *slog.Logger, store db.Store) Handler {
+	return Handler{
+		log:   log,
+		store: store,
+	}
+}
+
+type Handler struct {
+	log   *slog.Logger
+	store db.Store
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	partition, ok := collections.GetPartition(r)
+	if !ok {
+		http.Error(w, "collection not provided", http.StatusInternalServerError)
+		return
+	}
+
+	revisions, err := h.store.DocumentRevisionList(r.Context(), db.DocumentID{
+		Partition: partition,
+		URL:       r.PathValue("url"),
+	})
+	if err != nil {
+		h.log.Error("failed to list revisions", slog.Any("error", err))
+		respond.WithError(w, "failed to list revisions", http.StatusInternalServerError)
+		return
+	}
+	if len(revisions) == 0 {
+		respond.WithError(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	resp := models.DocumentRevisionsGetResponse{
+		Revisions: make([]models.DocumentRevisionMetadata, len(revisions)),
+	}
+	for i, rev := range revisions {
+		resp.Revisions[i] = models.DocumentRevisionMetadata{
+			Revision:  rev.Revision,
+			Title:     rev.Title,
+			Summary:   rev.Summary,
+			CreatedBy: rev.CreatedBy,
+			CreatedAt: rev.CreatedAt,
+		}
+	}
+
+	respond.WithJSON(w, resp, http.StatusOK)
+}
@@ -0,0 +1,104 @@
+package post
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/a-h/ragserver/auth"
+	"github.com/a-h/ragserver/collections"
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/ingest"
+	"github.com/a-h/ragserver/models"
+	"github.com/a-h/respond"
+)
+
+func New(log *slog.Logger, ingester *ingest.Ingester) Handler {
+	return Handler{
+		log:      log,
+		ingester: ingester,
+	}
+}
+
+type Handler struct {
+	log      *slog.Logger
+	ingester *ingest.Ingester
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUser(r)
+	if !ok {
+		http.Error(w, "authentication not provided", http.StatusUnauthorized)
+		return
+	}
+	partition, ok := collections.GetPartition(r)
+	if !ok {
+		http.Error(w, "collection not provided", http.StatusInternalServerError)
+		return
+	}
+
+	var req models.DocumentsBatchPostRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		h.log.Error("failed to decode body", slog.Any("error", err))
+		respond.WithError(w, "failed to decode body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Documents) > models.DocumentsBatchMaxDocuments {
+		respond.WithError(w, fmt.Sprintf("a batch can contain at most %d documents", models.DocumentsBatchMaxDocuments), http.StatusBadRequest)
+		return
+	}
+
+	resp := models.DocumentsBatchPostResponse{
+		Results: make([]models.DocumentsBatchPostResult, len(req.Documents)),
+	}
+	// Validate the documents, and only put the valid ones.
+	var indices []int
+	var args []ingest.PutArgs
+	seen := make(map[string]bool, len(req.Documents))
+	for i, doc := range req.Documents {
+		resp.Results[i].URL = doc.URL
+		if err = db.ValidateMetadata(doc.Metadata); err != nil {
+			resp.Results[i].Error = err.Error()
+			resp.Results[i].StatusCode = http.StatusBadRequest
+			continue
+		}
+		if seen[doc.URL] {
+			resp.Results[i].Error = "duplicate URL in batch"
+			resp.Results[i].StatusCode = http.StatusBadRequest
+			continue
+		}
+		seen[doc.URL] = true
+		indices = append(indices, i)
+		args = append(args, ingest.PutArgs{
+			Partition: partition,
+			User:      user,
+			Document:  doc,
+		})
+	}
+
+	// If this is a test API key, don't use the LLM.
+	if user == "test-user-no-llm" {
+		for j, i := range indices {
+			resp.Results[i].ID = int64(123 + j)
+			resp.Results[i].Status = models.DocumentStatusCreated
+		}
+		respond.WithJSON(w, resp, http.StatusOK)
+		return
+	}
+
+	results, errs := h.ingester.PutBatch(r.Context(), args)
+	for j, i := range indices {
+		if errs[j] != nil {
+			h.log.Error("document put failed", slog.String("url", args[j].Document.URL), slog.Any("error", errs[j]))
+			resp.Results[i].Error = "document put failed"
+			resp.Results[i].StatusCode = http.StatusInternalServerError
+			continue
+		}
+		resp.Results[i].ID = results[j].ID
+		resp.Results[i].Status = results[j].Status
+	}
+
+	respond.WithJSON(w, resp, http.StatusOK)
+}
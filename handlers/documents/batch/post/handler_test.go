@@ -0,0 +1,117 @@
+package post
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-h/ragserver/auth"
+	"github.com/a-h/ragserver/collections"
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/db/memory"
+	"github.com/a-h/ragserver/ingest"
+	"github.com/a-h/ragserver/models"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+// fixedEmbedder embeds every text as the same vector.
+type fixedEmbedder struct{}
+
+func (fixedEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i := range texts {
+		embeddings[i] = []float32{1, 0}
+	}
+	return embeddings, nil
+}
+
+func (fixedEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	return []float32{1, 0}, nil
+}
+
+// failingStore fails to write the document with the URL.
+type failingStore struct {
+	*memory.Store
+	url string
+}
+
+func (s failingStore) DocumentPut(ctx context.Context, args db.DocumentPutArgs) (int64, error) {
+	if args.Document.URL == s.url {
+		return 0, errors.New("constraint failed")
+	}
+	return s.Store.DocumentPut(ctx, args)
+}
+
+func (s failingStore) DocumentPutBatch(ctx context.Context, args []db.DocumentPutArgs) ([]int64, error) {
+	for _, a := range args {
+		if a.Document.URL == s.url {
+			return nil, errors.New("constraint failed")
+		}
+	}
+	return s.Store.DocumentPutBatch(ctx, args)
+}
+
+func TestHandler(t *testing.T) {
+	ms, err := memory.New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store := failingStore{Store: ms, url: "failing"}
+	ingester := ingest.New(textsplitter.NewMarkdownTextSplitter(), fixedEmbedder{}, store)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	server := httptest.NewServer(auth.New(map[string]string{"key": "alice"}, collections.New(nil, New(log, ingester))))
+	defer server.Close()
+
+	body, err := json.Marshal(models.DocumentsBatchPostRequest{
+		Documents: []models.Document{
+			{URL: "valid", Title: "Valid", Text: "A valid document."},
+			{URL: "invalid", Title: "Invalid", Text: "Invalid metadata.", Metadata: map[string]any{"source system": "wiki"}},
+			{URL: "valid", Title: "Duplicate", Text: "A duplicate document."},
+			{URL: "failing", Title: "Failing", Text: "A document that the store rejects."},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to encode request: %v", err)
+	}
+	r, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer key")
+	resp, err := server.Client().Do(r)
+	if err != nil {
+		t.Fatalf("failed to put documents: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	var batch models.DocumentsBatchPostResponse
+	if err = json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	var statusCodes []int
+	for _, result := range batch.Results {
+		statusCodes = append(statusCodes, result.StatusCode)
+	}
+	expected := []int{0, http.StatusBadRequest, http.StatusBadRequest, http.StatusInternalServerError}
+	if diff := cmp.Diff(expected, statusCodes); diff != "" {
+		t.Errorf("unexpected status codes: %v", diff)
+	}
+	if batch.Results[0].Error != "" || batch.Results[0].Status != models.DocumentStatusCreated {
+		t.Errorf("expected the valid document to be created, got %+v", batch.Results[0])
+	}
+	if !strings.Contains(batch.Results[1].Error, "source system") {
+		t.Errorf("expected the invalid metadata key to be reported, got %q", batch.Results[1].Error)
+	}
+	if batch.Results[3].Error != "document put failed" {
+		t.Errorf("expected the store failure not to be exposed, got %q", batch.Results[3].Error)
+	}
+}
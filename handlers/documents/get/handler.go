@@ -0,0 +1,100 @@
+package get
+
+import (
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a-h/ragserver/collections"
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/models"
+	"github.com/a-h/respond"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+func New(log *slog.Logger, store db.Store) Handler {
+	return Handler{
+		log:   log,
+		store: store,
+	}
+}
+
+type Handler struct {
+	log   *slog.Logger
+	store db.Store
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	partition, ok := collections.GetPartition(r)
+	if !ok {
+		http.Error(w, "collection not provided", http.StatusInternalServerError)
+		return
+	}
+
+	q := r.URL.Query()
+	limit := defaultLimit
+	if s := q.Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			respond.WithError(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxLimit)
+	}
+	var updatedSince time.Time
+	if s := q.Get("updated_since"); s != "" {
+		var err error
+		updatedSince, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			respond.WithError(w, "invalid updated_since, expected an RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	after, err := base64.RawURLEncoding.DecodeString(q.Get("cursor"))
+	if err != nil {
+		respond.WithError(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
+
+	// Fetch an extra document to find out if there's another page.
+	docs, err := h.store.DocumentList(r.Context(), db.DocumentListArgs{
+		Partition:    partition,
+		URLPrefix:    q.Get("prefix"),
+		UpdatedSince: updatedSince,
+		After:        string(after),
+		Limit:        limit + 1,
+	})
+	if err != nil {
+		h.log.Error("failed to list documents", slog.Any("error", err))
+		respond.WithError(w, "failed to list documents", http.StatusInternalServerError)
+		return
+	}
+
+	var resp models.DocumentsGetResponse
+	if len(docs) > limit {
+		docs = docs[:limit]
+		resp.Next = base64.RawURLEncoding.EncodeToString([]byte(docs[len(docs)-1].URL))
+	}
+	resp.Documents = make([]models.DocumentMetadata, len(docs))
+	for i, doc := range docs {
+		resp.Documents[i] = models.DocumentMetadata{
+			URL:           doc.URL,
+			Title:         doc.Title,
+			Summary:       doc.Summary,
+			CreatedAt:     doc.CreatedAt,
+			LastUpdatedAt: doc.LastUpdatedAt,
+		}
+		if !doc.PublishedAt.IsZero() {
+			resp.Documents[i].PublishedAt = &doc.PublishedAt
+		}
+	}
+
+	respond.WithJSON(w, resp, http.StatusOK)
+}
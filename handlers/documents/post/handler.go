@@ -2,36 +2,27 @@ package post
 
 import (
 	"encoding/json"
-	"errors"
 	"log/slog"
 	"net/http"
-	"slices"
-	"sync"
 
 	"github.com/a-h/ragserver/auth"
+	"github.com/a-h/ragserver/collections"
 	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/ingest"
 	"github.com/a-h/ragserver/models"
 	"github.com/a-h/respond"
-	"github.com/tmc/langchaingo/embeddings"
-	"github.com/tmc/langchaingo/textsplitter"
 )
 
-func New(log *slog.Logger, embedder embeddings.Embedder, queries *db.Queries) Handler {
-	splitter := textsplitter.NewMarkdownTextSplitter()
-
+func New(log *slog.Logger, ingester *ingest.Ingester) Handler {
 	return Handler{
 		log:      log,
-		splitter: splitter,
-		embedder: embedder,
-		queries:  queries,
+		ingester: ingester,
 	}
 }
 
 type Handler struct {
 	log      *slog.Logger
-	splitter textsplitter.TextSplitter
-	embedder embeddings.Embedder
-	queries  *db.Queries
+	ingester *ingest.Ingester
 }
 
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -40,6 +31,11 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "authentication not provided", http.StatusUnauthorized)
 		return
 	}
+	partition, ok := collections.GetPartition(r)
+	if !ok {
+		http.Error(w, "collection not provided", http.StatusInternalServerError)
+		return
+	}
 
 	var req models.DocumentsPostRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -49,75 +45,33 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	texts, err := h.split(req.Document)
-	if err != nil {
-		h.log.Error("failed to split text", slog.Any("error", err))
-		respond.WithError(w, "failed to split text", http.StatusInternalServerError)
+	if err = db.ValidateMetadata(req.Document.Metadata); err != nil {
+		respond.WithError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// If this is a test API key, don't use the LLM.
 	if user == "test-user-no-llm" {
-		respond.WithJSON(w, models.DocumentsPostResponse{ID: 123}, http.StatusOK)
-		return
-	}
-
-	//TODO: Add metrics for text count, text length, and embedding time. Use partition as a dimension.
-	embeddings, err := h.embedder.EmbedDocuments(r.Context(), texts)
-	if err != nil {
-		h.log.Error("failed to embed documents", slog.Any("error", err))
-		respond.WithError(w, "failed to embed documents", http.StatusInternalServerError)
+		respond.WithJSON(w, models.DocumentsPostResponse{ID: 123, Status: models.DocumentStatusCreated}, http.StatusOK)
 		return
 	}
 
-	if len(texts) != len(embeddings) {
-		h.log.Error("length mismatch", slog.Int("texts", len(texts)), slog.Int("embeddings", len(embeddings)))
-		respond.WithError(w, "split/embedding failed", http.StatusInternalServerError)
-		return
-	}
-
-	chunks := make([]db.Chunk, len(texts))
-	for i := 0; i < len(texts); i++ {
-		chunks[i] = db.Chunk{
-			Text:      texts[i],
-			Embedding: embeddings[i],
-		}
-	}
-
-	var resp models.DocumentsPostResponse
-	resp.ID, err = h.queries.DocumentPut(r.Context(), db.DocumentPutArgs{
-		Document: db.Document{
-			DocumentID: db.DocumentID{
-				Partition: user,
-				URL:       req.Document.URL,
-			},
-			Title:   req.Document.Title,
-			Text:    req.Document.Text,
-			Summary: req.Document.Summary,
-		},
-		Chunks: chunks,
+	result, err := h.ingester.Put(r.Context(), ingest.PutArgs{
+		Partition: partition,
+		User:      user,
+		Document:  req.Document,
 	})
 	if err != nil {
 		h.log.Error("document put failed", slog.Any("error", err))
 		respond.WithError(w, "document put failed", http.StatusInternalServerError)
 		return
 	}
+	h.log.Debug("document put", slog.String("url", req.Document.URL), slog.String("status", string(result.Status)),
+		slog.Int("embeddedChunks", result.EmbeddedChunks), slog.Int("reusedChunks", result.ReusedChunks))
+	resp := models.DocumentsPostResponse{
+		ID:     result.ID,
+		Status: result.Status,
+	}
 
 	respond.WithJSON(w, resp, http.StatusOK)
 }
-
-func (h *Handler) split(d models.Document) ([]string, error) {
-	inputs := []string{d.Title, d.Text, d.Summary}
-	outputs := make([][]string, len(inputs))
-	errs := make([]error, len(inputs))
-	var wg sync.WaitGroup
-	wg.Add(len(inputs))
-	for i := range inputs {
-		go func(i int) {
-			defer wg.Done()
-			outputs[i], errs[i] = h.splitter.SplitText(inputs[i])
-		}(i)
-	}
-	wg.Wait()
-	return slices.Concat(outputs...), errors.Join(errs...)
-}
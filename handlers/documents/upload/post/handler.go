@@ -0,0 +1,163 @@
+package post
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/a-h/ragserver/auth"
+	"github.com/a-h/ragserver/collections"
+	"github.com/a-h/ragserver/ingest"
+	"github.com/a-h/ragserver/loaders"
+	"github.com/a-h/ragserver/models"
+	"github.com/a-h/respond"
+)
+
+// maxMemory is the size of the uploaded files that are held in memory while
+// the form is parsed. The rest are written to temporary files.
+const maxMemory = 8 << 20
+
+func New(log *slog.Logger, ingester *ingest.Ingester) Handler {
+	return Handler{
+		log:      log,
+		ingester: ingester,
+	}
+}
+
+type Handler struct {
+	log      *slog.Logger
+	ingester *ingest.Ingester
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUser(r)
+	if !ok {
+		http.Error(w, "authentication not provided", http.StatusUnauthorized)
+		return
+	}
+	partition, ok := collections.GetPartition(r)
+	if !ok {
+		http.Error(w, "collection not provided", http.StatusInternalServerError)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, models.DocumentsUploadMaxBytes)
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			respond.WithError(w, fmt.Sprintf("uploads can be at most %d bytes", mbe.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		h.log.Error("failed to parse form", slog.Any("error", err))
+		respond.WithError(w, "failed to parse form", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		respond.WithError(w, "no files uploaded", http.StatusBadRequest)
+		return
+	}
+	if len(files) > models.DocumentsBatchMaxDocuments {
+		respond.WithError(w, fmt.Sprintf("at most %d files can be uploaded", models.DocumentsBatchMaxDocuments), http.StatusBadRequest)
+		return
+	}
+	url, title := r.FormValue("url"), r.FormValue("title")
+	if len(files) > 1 && (url != "" || title != "") {
+		respond.WithError(w, "url and title can only be set when a single file is uploaded", http.StatusBadRequest)
+		return
+	}
+
+	resp := models.DocumentsUploadPostResponse{
+		Results: make([]models.DocumentsUploadPostResult, len(files)),
+	}
+	// Load the files, and only put the ones that contain text.
+	var indices []int
+	var args []ingest.PutArgs
+	seen := make(map[string]bool, len(files))
+	for i, file := range files {
+		resp.Results[i].FileName = file.Filename
+		doc, err := h.load(r, file)
+		if err != nil {
+			resp.Results[i].Error = err.Error()
+			continue
+		}
+		doc.URL = cmp.Or(url, file.Filename)
+		doc.Title = cmp.Or(title, doc.Title, file.Filename)
+		resp.Results[i].URL = doc.URL
+		resp.Results[i].Title = doc.Title
+		if seen[doc.URL] {
+			resp.Results[i].Error = "duplicate URL in upload"
+			continue
+		}
+		seen[doc.URL] = true
+		indices = append(indices, i)
+		args = append(args, ingest.PutArgs{
+			Partition: partition,
+			User:      user,
+			Document:  doc,
+		})
+	}
+
+	// If this is a test API key, don't use the LLM.
+	if user == "test-user-no-llm" {
+		for j, i := range indices {
+			resp.Results[i].ID = int64(123 + j)
+			resp.Results[i].Status = models.DocumentStatusCreated
+		}
+		respond.WithJSON(w, resp, http.StatusOK)
+		return
+	}
+
+	results, errs := h.ingester.PutBatch(r.Context(), args)
+	for j, i := range indices {
+		if errs[j] != nil {
+			h.log.Error("document put failed", slog.String("url", args[j].Document.URL), slog.Any("error", errs[j]))
+			resp.Results[i].Error = "document put failed"
+			continue
+		}
+		resp.Results[i].ID = results[j].ID
+		resp.Results[i].Status = results[j].Status
+	}
+
+	respond.WithJSON(w, resp, http.StatusOK)
+}
+
+// load extracts the text of an uploaded file. The returned errors can be
+// shown to the user.
+func (h Handler) load(r *http.Request, file *multipart.FileHeader) (doc models.Document, err error) {
+	f, err := file.Open()
+	if err != nil {
+		h.log.Error("failed to open uploaded file", slog.String("fileName", file.Filename), slog.Any("error", err))
+		return doc, errors.New("failed to read file")
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		h.log.Error("failed to read uploaded file", slog.String("fileName", file.Filename), slog.Any("error", err))
+		return doc, errors.New("failed to read file")
+	}
+	loaded, err := loaders.Load(r.Context(), file.Header.Get("Content-Type"), file.Filename, data)
+	if errors.Is(err, loaders.ErrUnsupportedType) {
+		return doc, err
+	}
+	if err != nil {
+		h.log.Warn("failed to load uploaded file", slog.String("fileName", file.Filename), slog.Any("error", err))
+		return doc, errors.New("failed to extract text from file")
+	}
+	if strings.TrimSpace(loaded.Text) == "" {
+		return doc, errors.New("no text found in file")
+	}
+	return models.Document{
+		Title:       loaded.Title,
+		Summary:     loaded.Summary,
+		PublishedAt: loaded.PublishedAt,
+		Text:        loaded.Text,
+	}, nil
+}
@@ -3,42 +3,63 @@ package post
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"slices"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/a-h/ragserver/auth"
+	"github.com/a-h/ragserver/collections"
 	"github.com/a-h/ragserver/db"
 	"github.com/a-h/ragserver/models"
+	"github.com/a-h/ragserver/retrieval"
+	"github.com/a-h/ragserver/tokens"
 	"github.com/a-h/respond"
-	"github.com/tmc/langchaingo/embeddings"
 	"github.com/tmc/langchaingo/llms"
 )
 
-func New(log *slog.Logger, embedder embeddings.Embedder, llm llms.Model, queries *db.Queries, maxContextDocs int, systemPrompt string, userPrompt func(query string, context string) (string, error)) Handler {
+func New(log *slog.Logger, retriever *retrieval.Retriever, llm llms.Model, maxContextDocs int, systemPrompt string, userPrompt func(query string, context string) (string, error)) Handler {
 	return Handler{
 		log:            log,
-		embedder:       embedder,
+		retriever:      retriever,
 		llm:            llm,
-		queries:        queries,
 		maxContextDocs: maxContextDocs,
 		systemPrompt:   systemPrompt,
 		userPrompt:     userPrompt,
+		counter:        tokens.Approximate{CharsPerToken: 4},
 	}
 }
 
 type Handler struct {
 	log            *slog.Logger
-	embedder       embeddings.Embedder
+	retriever      *retrieval.Retriever
 	llm            llms.Model
-	queries        *db.Queries
 	maxContextDocs int
 	systemPrompt   string
 	userPrompt     func(query string, context string) (string, error)
+	counter        tokens.Counter
+	contextBudget  int
+	collections    collections.Collections
+}
+
+// WithContextBudget returns a handler that counts tokens with the counter, and
+// packs as much context into the prompt as fits in budget tokens. If the budget
+// is zero, the prompt includes all of the retrieved context.
+func (h Handler) WithContextBudget(counter tokens.Counter, budget int) Handler {
+	h.counter = counter
+	h.contextBudget = budget
+	return h
+}
+
+// WithCollections returns a handler that can search the shared collections
+// that the user has been granted read access to.
+func (h Handler) WithCollections(collections collections.Collections) Handler {
+	h.collections = collections
+	return h
 }
 
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -56,71 +77,113 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cols, err := h.collections.GetAll(user, req.Collections)
+	if err != nil {
+		respond.WithError(w, err.Error(), collections.StatusCode(err))
+		return
+	}
+
+	stream := newStreamWriter(w, req.Sources)
+
 	// If this is a test API key, don't use the LLM.
 	if user == "test-user-no-llm" {
-		writeTestMessage(w)
+		stream.WriteSources(nil)
+		writeTestMessage(stream)
 		return
 	}
 
 	var docs []db.DocumentSelectNearestResult
 
 	if !req.NoContext && req.Text != "" {
-		embedding, err := h.embedder.EmbedQuery(r.Context(), req.Text)
-		if err != nil {
-			h.log.Error("failed to embed query", slog.Any("error", err))
-			respond.WithError(w, "failed to embed query", http.StatusInternalServerError)
-			return
-		}
-
 		//TODO: Add metrics for query time. Use partition as a dimension.
 		// Find the most similar documents.
-		docs, err = h.queries.DocumentNearest(r.Context(), db.DocumentSelectNearestArgs{
-			Partition: user,
-			Embedding: embedding,
-			Limit:     h.maxContextDocs,
-		})
+		args := retrieval.Args{
+			Partitions:      collections.Partitions(cols),
+			Text:            req.Text,
+			Mode:            req.Mode,
+			Limit:           h.maxContextDocs,
+			Filter:          req.Filter,
+			MaxChunksPerURL: req.MaxChunksPerURL,
+			MaxDistance:     req.MaxDistance,
+			Strict:          req.Strict,
+			Transform:       req.Transform,
+			Expand:          req.Expand,
+			Recency:         retrieval.NewRecency(req.Recency),
+		}
+		if req.MMR != nil {
+			args.MMR = true
+			args.MMRLambda = req.MMR.Lambda
+		}
+		if req.Since != nil {
+			args.Dates.Since = *req.Since
+		}
+		if req.Until != nil {
+			args.Dates.Until = *req.Until
+		}
+		docs, err = h.retriever.Retrieve(r.Context(), args)
+		if errors.Is(err, retrieval.ErrInvalidArgs) {
+			respond.WithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			h.log.Error("failed to find nearest documents", slog.Any("error", err))
 			respond.WithError(w, "failed to find nearest documents", http.StatusInternalServerError)
 			return
 		}
+		if req.Strict && len(docs) == 0 {
+			h.log.Info("no relevant documents found", slog.Any("partitions", collections.Partitions(cols)))
+			respond.WithJSON(w, models.QueryPostNoRelevantDocumentsResponse{
+				NoRelevantDocuments: true,
+				Message:             "no relevant documents found",
+			}, http.StatusOK)
+			return
+		}
 	}
 
-	var sb strings.Builder
-	for _, doc := range docs {
-		sb.WriteString(fmt.Sprintf("## Context from URL: %q, title: %q\n", doc.URL, doc.Title))
-		sb.WriteString("\n")
-		sb.WriteString(doc.Text)
-		sb.WriteString("\n")
+	// Pack the context into the space left by the rest of the prompt.
+	basePrompt, err := h.userPrompt(req.Text, "")
+	if err != nil {
+		h.log.Error("failed to generate prompt", slog.Any("error", err))
+		respond.WithError(w, "failed to generate prompt", http.StatusInternalServerError)
+		return
+	}
+	docs, counts := retrieval.PackContext(h.counter, h.contextBudget, h.counter.Count(h.systemPrompt)+h.counter.Count(basePrompt), docs)
+	if counts.Budget > 0 && counts.Prompt > counts.Budget {
+		respond.WithError(w, fmt.Sprintf("the query is too long, the prompt has %d tokens, which is more than the budget of %d", counts.Prompt, counts.Budget), http.StatusBadRequest)
+		return
 	}
-	prompt, err := h.userPrompt(req.Text, sb.String())
+	prompt, err := h.userPrompt(req.Text, retrieval.FormatContext(docs))
 	if err != nil {
 		h.log.Error("failed to generate prompt", slog.Any("error", err))
 		respond.WithError(w, "failed to generate prompt", http.StatusInternalServerError)
 		return
 	}
+	setTokenHeaders(w, counts)
 
 	docIDs := make([]db.DocumentID, len(docs))
 	for i, doc := range docs {
 		docIDs[i] = db.DocumentID{
-			Partition: user,
+			Partition: doc.Partition,
 			URL:       doc.URL,
 		}
 	}
-	h.log.Info("query context", slog.Any("docs", docIDs))
+	h.log.Info("query context", slog.Any("docs", docIDs), slog.Int("promptTokens", counts.Prompt), slog.Int("contextTokens", counts.Context), slog.Int("budget", counts.Budget))
 
+	sources := retrieval.Sources(docs)
+	for i, doc := range docs {
+		sources[i].Collection = collections.Name(cols, doc.Partition)
+	}
+	if err = stream.WriteSources(sources); err != nil {
+		h.log.Error("failed to write sources", slog.Any("error", err))
+		return
+	}
 	f := func(ctx context.Context, chunk []byte) error {
 		select {
 		case <-ctx.Done():
 			return nil
 		default:
-			if _, err := w.Write(chunk); err != nil {
-				return err
-			}
-			if flusher, canFlush := w.(http.Flusher); canFlush {
-				flusher.Flush()
-			}
-			return nil
+			_, err := stream.Write(chunk)
+			return err
 		}
 	}
 
@@ -130,7 +193,7 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}, llms.WithStreamingFunc(f))
 	if err != nil {
 		h.log.Error("failed to generate content", slog.Any("error", err))
-		respond.WithError(w, "failed to generate content", http.StatusInternalServerError)
+		stream.WriteError("failed to generate content", http.StatusInternalServerError)
 		return
 	}
 }
@@ -143,15 +206,89 @@ I'm here to help you test your integration with the API.
 
 If you can see me, then your integration is working!`
 
-func writeTestMessage(w http.ResponseWriter) (err error) {
+func writeTestMessage(w io.Writer) (err error) {
 	for chunk := range slices.Chunk([]rune(TestMessage), 4) {
 		if _, err := io.WriteString(w, string(chunk)); err != nil {
 			return err
 		}
-		if flusher, canFlush := w.(http.Flusher); canFlush {
-			flusher.Flush()
-		}
 		time.Sleep(100 * time.Millisecond)
 	}
 	return nil
 }
+
+// setTokenHeaders reports the number of tokens in the prompt, which are
+// estimates, unless the counter uses the model's tokenizer.
+func setTokenHeaders(w http.ResponseWriter, counts retrieval.TokenCounts) {
+	w.Header().Set("X-Prompt-Tokens", strconv.Itoa(counts.Total()))
+	w.Header().Set("X-Context-Tokens", strconv.Itoa(counts.Context))
+	if counts.Budget > 0 {
+		w.Header().Set("X-Context-Budget", strconv.Itoa(counts.Budget))
+	}
+}
+
+func newStreamWriter(w http.ResponseWriter, events bool) *streamWriter {
+	sw := &streamWriter{w: w}
+	if events {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		sw.events = json.NewEncoder(w)
+	}
+	return sw
+}
+
+// streamWriter streams the answer, and flushes after each write. If events
+// are enabled, each write is sent as a models.QueryPostEvent, otherwise the
+// text is written as it is.
+type streamWriter struct {
+	w       http.ResponseWriter
+	events  *json.Encoder
+	started bool
+}
+
+// WriteError responds with the error, if nothing has been written yet. Once
+// the response has started, its status can't be changed, so the error is sent
+// as an event if events are enabled, and otherwise the answer just ends.
+func (sw *streamWriter) WriteError(msg string, status int) {
+	if !sw.started {
+		respond.WithError(sw.w, msg, status)
+		return
+	}
+	if sw.events != nil {
+		sw.encode(models.QueryPostEvent{Error: msg})
+	}
+}
+
+// WriteSources sends the sources event. It does nothing if events are not enabled.
+func (sw *streamWriter) WriteSources(sources []models.QuerySource) error {
+	if sw.events == nil {
+		return nil
+	}
+	return sw.encode(models.QueryPostEvent{Sources: sources})
+}
+
+func (sw *streamWriter) Write(p []byte) (n int, err error) {
+	if sw.events == nil {
+		sw.started = true
+		n, err = sw.w.Write(p)
+		sw.flush()
+		return n, err
+	}
+	if err = sw.encode(models.QueryPostEvent{Text: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (sw *streamWriter) encode(e models.QueryPostEvent) error {
+	sw.started = true
+	if err := sw.events.Encode(e); err != nil {
+		return err
+	}
+	sw.flush()
+	return nil
+}
+
+func (sw *streamWriter) flush() {
+	if flusher, canFlush := sw.w.(http.Flusher); canFlush {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,167 @@
+package post
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-h/ragserver/auth"
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/db/memory"
+	"github.com/a-h/ragserver/models"
+	"github.com/a-h/ragserver/retrieval"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// queryEmbedder embeds every query as the same vector.
+type queryEmbedder []float32
+
+func (e queryEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (e queryEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	return e, nil
+}
+
+// streamingModel streams the chunks of the answer, and then returns err.
+type streamingModel struct {
+	chunks []string
+	err    error
+}
+
+func (m streamingModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	var opts llms.CallOptions
+	for _, o := range options {
+		o(&opts)
+	}
+	for _, chunk := range m.chunks {
+		if err := opts.StreamingFunc(ctx, []byte(chunk)); err != nil {
+			return nil, err
+		}
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: strings.Join(m.chunks, "")}}}, nil
+}
+
+func (m streamingModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+func newTestServer(t *testing.T, model llms.Model) *httptest.Server {
+	t.Helper()
+	store, err := memory.New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.DocumentPut(context.Background(), db.DocumentPutArgs{
+		Document: db.Document{
+			DocumentID: db.DocumentID{Partition: "alice", URL: "https://example.com/billing"},
+			Title:      "Billing",
+			Text:       "The billing service sends invoices.",
+		},
+		Chunks: []db.Chunk{{Text: "The billing service sends invoices.", Embedding: []float32{1, 0}}},
+	})
+	if err != nil {
+		t.Fatalf("failed to put document: %v", err)
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	retriever := retrieval.New(queryEmbedder{1, 0}, store, db.HybridWeights{})
+	userPrompt := func(query, context string) (string, error) {
+		return context + "\n" + query, nil
+	}
+	h := New(log, retriever, model, 5, "You are a chatbot.", userPrompt)
+	server := httptest.NewServer(auth.New(map[string]string{"key": "alice"}, h))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func query(t *testing.T, server *httptest.Server, req models.QueryPostRequest) *http.Response {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("failed to encode request: %v", err)
+	}
+	r, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer key")
+	resp, err := server.Client().Do(r)
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func decodeEvents(t *testing.T, r io.Reader) (events []models.QueryPostEvent) {
+	t.Helper()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var e models.QueryPostEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("failed to decode event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("failed to read events: %v", err)
+	}
+	return events
+}
+
+func TestHandler(t *testing.T) {
+	t.Run("sources are streamed before the answer", func(t *testing.T) {
+		server := newTestServer(t, streamingModel{chunks: []string{"It sends ", "invoices [1]."}})
+		resp := query(t, server, models.QueryPostRequest{Text: "What does billing do?", Sources: true})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+		if contentType := resp.Header.Get("Content-Type"); contentType != "application/x-ndjson" {
+			t.Errorf("expected ndjson, got %q", contentType)
+		}
+		events := decodeEvents(t, resp.Body)
+		if len(events) != 3 {
+			t.Fatalf("expected 3 events, got %+v", events)
+		}
+		var urls []string
+		for _, source := range events[0].Sources {
+			urls = append(urls, source.URL)
+		}
+		if diff := cmp.Diff([]string{"https://example.com/billing"}, urls); diff != "" {
+			t.Errorf("unexpected sources: %v", diff)
+		}
+		if events[1].Text != "It sends " || events[2].Text != "invoices [1]." {
+			t.Errorf("unexpected answer events: %+v", events[1:])
+		}
+	})
+	t.Run("errors after the answer has started are sent as an event", func(t *testing.T) {
+		server := newTestServer(t, streamingModel{chunks: []string{"It sends "}, err: errors.New("model unloaded")})
+		resp := query(t, server, models.QueryPostRequest{Text: "What does billing do?", Sources: true})
+		events := decodeEvents(t, resp.Body)
+		if len(events) != 3 {
+			t.Fatalf("expected 3 events, got %+v", events)
+		}
+		if expected := (models.QueryPostEvent{Error: "failed to generate content"}); !cmp.Equal(expected, events[2]) {
+			t.Errorf("expected the last event to be %+v, got %+v", expected, events[2])
+		}
+	})
+	t.Run("errors before a plain text answer has started set the status", func(t *testing.T) {
+		server := newTestServer(t, streamingModel{err: errors.New("model unloaded")})
+		resp := query(t, server, models.QueryPostRequest{Text: "What does billing do?"})
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("expected status 500, got %d", resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package post
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"html"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/a-h/ragserver/auth"
+	"github.com/a-h/ragserver/collections"
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/models"
+	"github.com/a-h/respond"
+)
+
+const (
+	defaultLimit = 10
+	maxLimit     = 100
+)
+
+// The store wraps matches in unescaped document text, so private use
+// characters mark the matches until the text has been escaped.
+const (
+	highlightStart = "\ue000"
+	highlightEnd   = "\ue001"
+)
+
+var highlightReplacer = strings.NewReplacer(highlightStart, "<mark>", highlightEnd, "</mark>")
+
+// highlightHTML escapes the text, and wraps the matches in <mark> tags.
+func highlightHTML(s string) string {
+	return highlightReplacer.Replace(html.EscapeString(s))
+}
+
+func New(log *slog.Logger, store db.Store) Handler {
+	return Handler{
+		log:   log,
+		store: store,
+	}
+}
+
+type Handler struct {
+	log         *slog.Logger
+	store       db.Store
+	collections collections.Collections
+}
+
+// WithCollections returns a handler that can search the shared collections
+// that the user has been granted read access to.
+func (h Handler) WithCollections(collections collections.Collections) Handler {
+	h.collections = collections
+	return h
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUser(r)
+	if !ok {
+		http.Error(w, "authentication not provided", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.SearchPostRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		h.log.Error("failed to decode body", slog.Any("error", err))
+		respond.WithError(w, "failed to decode body", http.StatusBadRequest)
+		return
+	}
+	if req.Query == "" {
+		respond.WithError(w, "query is required", http.StatusBadRequest)
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultLimit
+	}
+	if req.Limit > maxLimit {
+		req.Limit = maxLimit
+	}
+	if req.Offset < 0 {
+		req.Offset = 0
+	}
+
+	cols, err := h.collections.GetAll(user, req.Collections)
+	if err != nil {
+		respond.WithError(w, err.Error(), collections.StatusCode(err))
+		return
+	}
+
+	// Each collection is searched for the whole page, so that the results can
+	// be merged by rank before the page is taken.
+	results := []models.SearchResult{}
+	for _, col := range cols {
+		docs, err := h.store.DocumentSearch(r.Context(), db.DocumentSearchArgs{
+			Partition:      col.Partition,
+			Query:          req.Query,
+			Limit:          req.Offset + req.Limit,
+			HighlightStart: highlightStart,
+			HighlightEnd:   highlightEnd,
+		})
+		if errors.Is(err, db.ErrInvalidSearchQuery) {
+			respond.WithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			h.log.Error("failed to search documents", slog.Any("error", err))
+			respond.WithError(w, "failed to search documents", http.StatusInternalServerError)
+			return
+		}
+		for _, doc := range docs {
+			results = append(results, models.SearchResult{
+				URL:              doc.URL,
+				Title:            doc.Title,
+				Summary:          doc.Summary,
+				BM25:             doc.BM25,
+				TitleHighlight:   highlightHTML(doc.TitleHighlight),
+				SummaryHighlight: highlightHTML(doc.SummaryHighlight),
+				Snippet:          highlightHTML(doc.Snippet),
+				Collection:       col.Name,
+			})
+		}
+	}
+	slices.SortStableFunc(results, func(a, b models.SearchResult) int {
+		return cmp.Compare(a.BM25, b.BM25)
+	})
+
+	resp := models.SearchPostResponse{
+		Results: results[min(req.Offset, len(results)):min(req.Offset+req.Limit, len(results))],
+	}
+
+	respond.WithJSON(w, resp, http.StatusOK)
+}
@@ -0,0 +1,152 @@
+package post
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-h/ragserver/auth"
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/db/memory"
+	"github.com/a-h/ragserver/models"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestServer(t *testing.T, docs ...db.Document) *httptest.Server {
+	t.Helper()
+	store, err := memory.New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for _, doc := range docs {
+		if _, err = store.DocumentPut(context.Background(), db.DocumentPutArgs{Document: doc}); err != nil {
+			t.Fatalf("failed to put document: %v", err)
+		}
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	server := httptest.NewServer(auth.New(map[string]string{"key": "alice"}, New(log, store)))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func search(t *testing.T, server *httptest.Server, req models.SearchPostRequest) (resp models.SearchPostResponse, statusCode int) {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("failed to encode request: %v", err)
+	}
+	r, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer key")
+	httpResp, err := server.Client().Do(r)
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode == http.StatusOK {
+		if err = json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+	return resp, httpResp.StatusCode
+}
+
+func TestHandler(t *testing.T) {
+	server := newTestServer(t,
+		db.Document{
+			DocumentID: db.DocumentID{Partition: "alice", URL: "https://example.com/a"},
+			Title:      `<script>alert("death star")</script>`,
+			Summary:    "Plans & schematics.",
+			Text:       "The death star <b>plans</b> are stored here.",
+		},
+		db.Document{
+			DocumentID: db.DocumentID{Partition: "alice", URL: "https://example.com/b"},
+			Title:      "Death star",
+			Text:       "The death star.",
+		},
+		db.Document{
+			DocumentID: db.DocumentID{Partition: "alice", URL: "https://example.com/c"},
+			Title:      "Star destroyer",
+			Text:       "Another star.",
+		},
+		db.Document{
+			DocumentID: db.DocumentID{Partition: "bob", URL: "https://example.com/d"},
+			Title:      "Death star",
+			Text:       "Someone else's document.",
+		},
+	)
+
+	t.Run("highlights are escaped HTML", func(t *testing.T) {
+		resp, statusCode := search(t, server, models.SearchPostRequest{Query: `"death star" plans`})
+		if statusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", statusCode)
+		}
+		if len(resp.Results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(resp.Results))
+		}
+		r := resp.Results[0]
+		if expected := `&lt;script&gt;alert(&#34;<mark>death</mark> <mark>star</mark>&#34;)&lt;/script&gt;`; r.TitleHighlight != expected {
+			t.Errorf("expected title highlight %q, got %q", expected, r.TitleHighlight)
+		}
+		if expected := `<mark>Plans</mark> &amp; schematics.`; r.SummaryHighlight != expected {
+			t.Errorf("expected summary highlight %q, got %q", expected, r.SummaryHighlight)
+		}
+		if expected := `The <mark>death</mark> <mark>star</mark> &lt;b&gt;<mark>plans</mark>&lt;/b&gt; are stored here.`; r.Snippet != expected {
+			t.Errorf("expected snippet %q, got %q", expected, r.Snippet)
+		}
+	})
+	t.Run("results are paginated", func(t *testing.T) {
+		all, statusCode := search(t, server, models.SearchPostRequest{Query: "star"})
+		if statusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", statusCode)
+		}
+		// Other users' documents aren't included.
+		var expected []string
+		for _, r := range all.Results {
+			expected = append(expected, r.URL)
+		}
+		if len(expected) != 3 {
+			t.Fatalf("expected 3 results, got %v", expected)
+		}
+
+		var urls []string
+		for offset := 0; offset < 4; offset += 2 {
+			resp, statusCode := search(t, server, models.SearchPostRequest{Query: "star", Limit: 2, Offset: offset})
+			if statusCode != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", statusCode)
+			}
+			for _, r := range resp.Results {
+				urls = append(urls, r.URL)
+			}
+		}
+		if diff := cmp.Diff(expected, urls); diff != "" {
+			t.Errorf("unexpected results: %v", diff)
+		}
+	})
+	t.Run("offsets past the end return no results", func(t *testing.T) {
+		resp, statusCode := search(t, server, models.SearchPostRequest{Query: "star", Offset: 10})
+		if statusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", statusCode)
+		}
+		if len(resp.Results) != 0 {
+			t.Errorf("expected no results, got %d", len(resp.Results))
+		}
+	})
+	t.Run("invalid queries are bad requests", func(t *testing.T) {
+		if _, statusCode := search(t, server, models.SearchPostRequest{Query: `"death star`}); statusCode != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", statusCode)
+		}
+	})
+	t.Run("empty queries are bad requests", func(t *testing.T) {
+		if _, statusCode := search(t, server, models.SearchPostRequest{}); statusCode != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", statusCode)
+		}
+	})
+}
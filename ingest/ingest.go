@@ -0,0 +1,358 @@
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/models"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+func New(splitter textsplitter.TextSplitter, embedder embeddings.Embedder, store db.Store) *Ingester {
+	return &Ingester{
+		splitter: splitter,
+		embedder: embedder,
+		store:    store,
+		now:      time.Now,
+	}
+}
+
+// Ingester splits documents into chunks, embeds the chunks, and stores them.
+type Ingester struct {
+	splitter textsplitter.TextSplitter
+	embedder embeddings.Embedder
+	store    db.Store
+	// sectionSplitter splits documents into parent sections, if set.
+	sectionSplitter textsplitter.TextSplitter
+	sectionSize     int
+	now             func() time.Time
+}
+
+// WithParentSections returns an ingester that splits documents into parent
+// sections of up to sectionSize characters, at markdown headings where
+// possible, and then splits each section into chunks. Text that's shorter than
+// sectionSize is kept as a single section.
+func (i *Ingester) WithParentSections(sectionSize int) *Ingester {
+	ii := *i
+	ii.sectionSize = sectionSize
+	ii.sectionSplitter = textsplitter.NewMarkdownTextSplitter(
+		textsplitter.WithChunkSize(sectionSize),
+		textsplitter.WithChunkOverlap(0),
+	)
+	return &ii
+}
+
+type PutArgs struct {
+	Partition string
+	// User that submitted the document.
+	User     string
+	Document models.Document
+}
+
+type PutResult struct {
+	ID     int64
+	Status models.DocumentStatus
+	// EmbeddedChunks is the number of chunks that were sent to the embedder.
+	EmbeddedChunks int
+	// ReusedChunks is the number of chunks whose text was unchanged, so their
+	// existing embeddings were kept.
+	ReusedChunks int
+}
+
+// embedBatchSize is the maximum number of chunks sent to the embedder in a single call.
+const embedBatchSize = 64
+
+// Put stores the document. If the document content is unchanged, nothing is
+// written. Otherwise, only chunks with new text are embedded.
+func (i *Ingester) Put(ctx context.Context, args PutArgs) (result PutResult, err error) {
+	results, errs := i.PutBatch(ctx, []PutArgs{args})
+	return results[0], errs[0]
+}
+
+// PutBatch stores the documents, embedding the changed chunks of all of the
+// documents in bounded batches, and writing the documents in a single batch.
+// If the batch can't be written, the documents are written one at a time, so
+// that each document gets its own status. The result and error of each
+// document are returned in the order of args.
+func (i *Ingester) PutBatch(ctx context.Context, args []PutArgs) (results []PutResult, errs []error) {
+	results = make([]PutResult, len(args))
+	errs = make([]error, len(args))
+	puts := make([]db.DocumentPutArgs, len(args))
+
+	// Read the hashes of the existing documents and chunks in one request.
+	ids := make([]db.DocumentID, len(args))
+	for j, a := range args {
+		ids[j] = db.DocumentID{Partition: a.Partition, URL: a.Document.URL}
+	}
+	existing, err := i.store.DocumentHashes(ctx, ids)
+	if err != nil {
+		for j := range errs {
+			errs[j] = fmt.Errorf("failed to get document hashes: %w", err)
+		}
+		return results, errs
+	}
+
+	// Find the chunks that can reuse existing embeddings, and the chunks that
+	// need to be embedded.
+	type chunkRef struct {
+		doc, chunk int
+	}
+	var refs []chunkRef
+	reused := map[chunkRef]db.ChunkID{}
+	for j, a := range args {
+		var reuse map[int]int
+		var embed []int
+		h, ok := existing[ids[j]]
+		results[j], puts[j], reuse, embed, errs[j] = i.prepare(a, h, ok)
+		if errs[j] != nil {
+			continue
+		}
+		for chunkIndex, existingIndex := range reuse {
+			reused[chunkRef{doc: j, chunk: chunkIndex}] = db.ChunkID{Partition: a.Partition, DocumentRowID: h.RowID, Index: existingIndex}
+		}
+		for _, chunkIndex := range embed {
+			refs = append(refs, chunkRef{doc: j, chunk: chunkIndex})
+		}
+	}
+
+	// Only the embeddings of the reused chunks are read.
+	if len(reused) > 0 {
+		chunkIDs := slices.Collect(maps.Values(reused))
+		embeddings, err := i.store.DocumentChunkEmbeddings(ctx, chunkIDs)
+		for ref, chunkID := range reused {
+			if err != nil {
+				errs[ref.doc] = fmt.Errorf("failed to get document chunk embeddings: %w", err)
+				continue
+			}
+			embedding, ok := embeddings[chunkID]
+			if !ok {
+				// The chunk was replaced after its hash was read.
+				refs = append(refs, ref)
+				results[ref.doc].EmbeddedChunks++
+				results[ref.doc].ReusedChunks--
+				continue
+			}
+			puts[ref.doc].Chunks[ref.chunk].Embedding = embedding
+		}
+	}
+
+	// Embed the chunks.
+	//TODO: Add metrics for text count, text length, and embedding time. Use partition as a dimension.
+	for batch := range slices.Chunk(refs, embedBatchSize) {
+		texts := make([]string, len(batch))
+		for j, ref := range batch {
+			texts[j] = puts[ref.doc].Chunks[ref.chunk].Text
+		}
+		embeddings, err := i.embedder.EmbedDocuments(ctx, texts)
+		if err == nil && len(texts) != len(embeddings) {
+			err = fmt.Errorf("split/embedding length mismatch: %d texts, %d embeddings", len(texts), len(embeddings))
+		}
+		for j, ref := range batch {
+			if err != nil {
+				errs[ref.doc] = fmt.Errorf("failed to embed documents: %w", err)
+				continue
+			}
+			puts[ref.doc].Chunks[ref.chunk].Embedding = embeddings[j]
+		}
+	}
+
+	// Write the documents that have changed, and have been embedded.
+	var indices []int
+	var batch []db.DocumentPutArgs
+	for j := range args {
+		if errs[j] != nil || results[j].Status == models.DocumentStatusUnchanged {
+			continue
+		}
+		indices = append(indices, j)
+		batch = append(batch, puts[j])
+	}
+	if len(batch) == 0 {
+		return results, errs
+	}
+	rowIDs, err := i.store.DocumentPutBatch(ctx, batch)
+	if err == nil {
+		for j, docIndex := range indices {
+			results[docIndex].ID = rowIDs[j]
+		}
+		return results, errs
+	}
+	if len(batch) == 1 {
+		errs[indices[0]] = fmt.Errorf("document put failed: %w", err)
+		return results, errs
+	}
+	// The batch is written in a single transaction, so one bad document fails
+	// all of them. Write them one at a time to find out which.
+	for j, docIndex := range indices {
+		if results[docIndex].ID, err = i.store.DocumentPut(ctx, batch[j]); err != nil {
+			errs[docIndex] = fmt.Errorf("document put failed: %w", err)
+		}
+	}
+	return results, errs
+}
+
+// prepare splits the document into chunks. Chunks whose text is unchanged can
+// reuse the embeddings of existing chunks, so reuse maps their index to the
+// index of the existing chunk. The indices of the chunks that need to be
+// embedded are returned in embed. If the document is unchanged, the result
+// status is unchanged, and nothing needs to be written.
+func (i *Ingester) prepare(args PutArgs, existing db.DocumentHashes, exists bool) (result PutResult, put db.DocumentPutArgs, reuse map[int]int, embed []int, err error) {
+	id := db.DocumentID{
+		Partition: args.Partition,
+		URL:       args.Document.URL,
+	}
+	contentHash, err := hashDocument(args.Document)
+	if err != nil {
+		return result, put, nil, nil, fmt.Errorf("failed to hash document: %w", err)
+	}
+	if i.sectionSplitter != nil {
+		// Changing the sections requires the document to be split again.
+		contentHash = hashText(fmt.Sprintf("sections:%d:%s", i.sectionSize, contentHash))
+	}
+
+	result.Status = models.DocumentStatusCreated
+	if exists {
+		result.Status = models.DocumentStatusUpdated
+		// If the embedding model has changed, the chunks will be missing, so the document is re-embedded.
+		if existing.ContentHash == contentHash && len(existing.ChunkHashes) > 0 {
+			result.ID = existing.RowID
+			result.Status = models.DocumentStatusUnchanged
+			result.ReusedChunks = len(existing.ChunkHashes)
+			return result, put, nil, nil, nil
+		}
+	}
+
+	texts, sections, err := i.split(args.Document)
+	if err != nil {
+		return result, put, nil, nil, fmt.Errorf("failed to split text: %w", err)
+	}
+	chunks, reuse, embed := planChunks(texts, existing.ChunkHashes)
+	result.EmbeddedChunks = len(embed)
+	result.ReusedChunks = len(chunks) - len(embed)
+
+	// The store keeps the creation time of existing documents.
+	now := i.now().UTC()
+	put = db.DocumentPutArgs{
+		Document: db.Document{
+			DocumentID:    id,
+			Title:         args.Document.Title,
+			Text:          args.Document.Text,
+			Summary:       args.Document.Summary,
+			Metadata:      args.Document.Metadata,
+			ContentHash:   contentHash,
+			CreatedAt:     now,
+			LastUpdatedAt: now,
+		},
+		Chunks:   chunks,
+		Sections: sections,
+		User:     args.User,
+	}
+	if args.Document.PublishedAt != nil {
+		put.Document.PublishedAt = args.Document.PublishedAt.UTC()
+	}
+	return result, put, reuse, embed, nil
+}
+
+// hashDocument returns a hex encoded SHA-256 hash of the document content.
+func hashDocument(d models.Document) (string, error) {
+	// Map keys are sorted by json.Marshal, so the output is stable.
+	data, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+	return hashText(string(data)), nil
+}
+
+func hashText(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// planChunks creates a chunk for each text. Chunks with the same text hash as
+// an existing chunk can reuse its embedding, so reuse maps their index to the
+// index of the existing chunk. The indices of the chunks that need to be
+// embedded are returned in embed.
+func planChunks(texts []string, existing []string) (chunks []db.Chunk, reuse map[int]int, embed []int) {
+	existingIndexes := make(map[string]int, len(existing))
+	for j, hash := range existing {
+		existingIndexes[hash] = j
+	}
+	chunks = make([]db.Chunk, len(texts))
+	reuse = make(map[int]int)
+	for j, text := range texts {
+		chunks[j].Text = text
+		if existingIndex, ok := existingIndexes[db.ChunkHash(text)]; ok {
+			reuse[j] = existingIndex
+			continue
+		}
+		embed = append(embed, j)
+	}
+	return chunks, reuse, embed
+}
+
+// split returns the chunks of the document, and its parent sections if the
+// ingester is configured with them.
+func (i *Ingester) split(d models.Document) (texts []string, sections []db.Section, err error) {
+	if i.sectionSplitter != nil {
+		return i.splitSections(d)
+	}
+	texts, err = i.splitChunks(d)
+	return texts, nil, err
+}
+
+func (i *Ingester) splitChunks(d models.Document) ([]string, error) {
+	inputs := []string{d.Title, d.Text, d.Summary}
+	outputs := make([][]string, len(inputs))
+	errs := make([]error, len(inputs))
+	var wg sync.WaitGroup
+	wg.Add(len(inputs))
+	for j := range inputs {
+		go func(j int) {
+			defer wg.Done()
+			outputs[j], errs[j] = i.splitter.SplitText(inputs[j])
+		}(j)
+	}
+	wg.Wait()
+	return slices.Concat(outputs...), errors.Join(errs...)
+}
+
+func (i *Ingester) splitSections(d models.Document) (texts []string, sections []db.Section, err error) {
+	for _, input := range []string{d.Title, d.Text, d.Summary} {
+		if strings.TrimSpace(input) == "" {
+			continue
+		}
+		parents := []string{input}
+		if utf8.RuneCountInString(input) > i.sectionSize {
+			if parents, err = i.sectionSplitter.SplitText(input); err != nil {
+				return nil, nil, err
+			}
+		}
+		for _, parent := range parents {
+			children, err := i.splitter.SplitText(parent)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(children) == 0 {
+				continue
+			}
+			sections = append(sections, db.Section{
+				Text: parent,
+				From: len(texts),
+				To:   len(texts) + len(children) - 1,
+			})
+			texts = append(texts, children...)
+		}
+	}
+	return texts, sections, nil
+}
@@ -0,0 +1,294 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/db/memory"
+	"github.com/a-h/ragserver/models"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+func TestPlanChunks(t *testing.T) {
+	existing := []string{db.ChunkHash("a"), db.ChunkHash("b")}
+	chunks, reuse, embed := planChunks([]string{"b", "c", "a"}, existing)
+
+	expectedChunks := []db.Chunk{{Text: "b"}, {Text: "c"}, {Text: "a"}}
+	if diff := cmp.Diff(expectedChunks, chunks); diff != "" {
+		t.Errorf("unexpected chunks: %v", diff)
+	}
+	if diff := cmp.Diff(map[int]int{0: 1, 2: 0}, reuse); diff != "" {
+		t.Errorf("unexpected chunks to reuse: %v", diff)
+	}
+	if diff := cmp.Diff([]int{1}, embed); diff != "" {
+		t.Errorf("unexpected chunks to embed: %v", diff)
+	}
+}
+
+func TestHashDocument(t *testing.T) {
+	doc := models.Document{
+		URL:      "https://example.com",
+		Title:    "Title",
+		Text:     "Text",
+		Metadata: map[string]any{"source": "a", "language": "en"},
+	}
+	a, err := hashDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := hashDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Errorf("expected the hash to be stable, got %q and %q", a, b)
+	}
+	doc.Metadata["source"] = "b"
+	c, err := hashDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == c {
+		t.Error("expected a metadata change to change the hash")
+	}
+}
+
+type countingEmbedder struct {
+	texts int
+}
+
+func (e *countingEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	e.texts += len(texts)
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embeddings[i] = []float32{float32(len(text)), 1}
+	}
+	return embeddings, nil
+}
+
+func (e *countingEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	return []float32{float32(len(text)), 1}, nil
+}
+
+func TestPutBatch(t *testing.T) {
+	ctx := context.Background()
+	store, err := memory.New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	embedder := &countingEmbedder{}
+	ingester := New(textsplitter.NewMarkdownTextSplitter(), embedder, store)
+
+	docs := []PutArgs{
+		{Partition: "partition", Document: models.Document{URL: "https://example.com/1", Title: "One", Text: "The first document."}},
+		{Partition: "partition", Document: models.Document{URL: "https://example.com/2", Title: "Two", Text: "The second document."}},
+	}
+	results, errs := ingester.PutBatch(ctx, docs)
+	for i := range docs {
+		if errs[i] != nil {
+			t.Fatalf("unexpected error for document %d: %v", i, errs[i])
+		}
+		if results[i].Status != models.DocumentStatusCreated {
+			t.Errorf("expected document %d to be created, got %q", i, results[i].Status)
+		}
+	}
+	if embedder.texts != 4 {
+		t.Errorf("expected 4 chunks to be embedded, got %d", embedder.texts)
+	}
+
+	t.Run("Unchanged documents are not embedded or written", func(t *testing.T) {
+		embedder.texts = 0
+		result, err := ingester.Put(ctx, docs[0])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Status != models.DocumentStatusUnchanged || result.ID != results[0].ID {
+			t.Errorf("expected document %d to be unchanged, got %+v", results[0].ID, result)
+		}
+		if embedder.texts != 0 {
+			t.Errorf("expected no chunks to be embedded, got %d", embedder.texts)
+		}
+		revisions, err := store.DocumentRevisionList(ctx, db.DocumentID{Partition: "partition", URL: docs[0].Document.URL})
+		if err != nil {
+			t.Fatalf("failed to list revisions: %v", err)
+		}
+		if len(revisions) != 1 {
+			t.Errorf("expected 1 revision, got %d", len(revisions))
+		}
+	})
+	t.Run("Only changed chunks are embedded", func(t *testing.T) {
+		embedder.texts = 0
+		updated := docs[1]
+		updated.Document.Text = "The updated second document."
+		result, err := ingester.Put(ctx, updated)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Status != models.DocumentStatusUpdated {
+			t.Errorf("expected the document to be updated, got %q", result.Status)
+		}
+		if result.EmbeddedChunks != 1 || result.ReusedChunks != 1 || embedder.texts != 1 {
+			t.Errorf("expected 1 embedded and 1 reused chunk, got %+v, with %d texts embedded", result, embedder.texts)
+		}
+	})
+}
+
+// failingStore fails to write the document with the URL, and counts the
+// requests to read document hashes.
+type failingStore struct {
+	*memory.Store
+	url       string
+	hashReads int
+	batchPuts int
+}
+
+func (s *failingStore) DocumentHashes(ctx context.Context, ids []db.DocumentID) (map[db.DocumentID]db.DocumentHashes, error) {
+	s.hashReads++
+	return s.Store.DocumentHashes(ctx, ids)
+}
+
+func (s *failingStore) DocumentPut(ctx context.Context, args db.DocumentPutArgs) (int64, error) {
+	if args.Document.URL == s.url {
+		return 0, errors.New("constraint failed")
+	}
+	return s.Store.DocumentPut(ctx, args)
+}
+
+func (s *failingStore) DocumentPutBatch(ctx context.Context, args []db.DocumentPutArgs) ([]int64, error) {
+	s.batchPuts++
+	for _, a := range args {
+		if a.Document.URL == s.url {
+			return nil, errors.New("constraint failed")
+		}
+	}
+	return s.Store.DocumentPutBatch(ctx, args)
+}
+
+func TestPutBatchReportsEachDocument(t *testing.T) {
+	ctx := context.Background()
+	ms, err := memory.New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store := &failingStore{Store: ms, url: "https://example.com/2"}
+	ingester := New(textsplitter.NewMarkdownTextSplitter(), &countingEmbedder{}, store)
+
+	docs := []PutArgs{
+		{Partition: "partition", Document: models.Document{URL: "https://example.com/1", Text: "The first document."}},
+		{Partition: "partition", Document: models.Document{URL: "https://example.com/2", Text: "The second document."}},
+		{Partition: "partition", Document: models.Document{URL: "https://example.com/3", Text: "The third document."}},
+	}
+	results, errs := ingester.PutBatch(ctx, docs)
+	if store.hashReads != 1 {
+		t.Errorf("expected the hashes of the batch to be read in 1 request, got %d", store.hashReads)
+	}
+	if store.batchPuts != 1 {
+		t.Errorf("expected 1 batch write, got %d", store.batchPuts)
+	}
+	if errs[1] == nil {
+		t.Error("expected the second document to fail")
+	}
+	for _, j := range []int{0, 2} {
+		if errs[j] != nil {
+			t.Errorf("unexpected error for document %d: %v", j, errs[j])
+		}
+		if results[j].ID == 0 {
+			t.Errorf("expected document %d to have a row ID", j)
+		}
+		if _, ok, _ := store.DocumentGet(ctx, db.DocumentID{Partition: "partition", URL: docs[j].Document.URL}); !ok {
+			t.Errorf("expected document %d to be stored", j)
+		}
+	}
+}
+
+func TestPutTimestamps(t *testing.T) {
+	ctx := context.Background()
+	store, err := memory.New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	ingester := New(textsplitter.NewMarkdownTextSplitter(), &countingEmbedder{}, store)
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	updated := created.Add(time.Hour)
+	published := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	id := db.DocumentID{Partition: "partition", URL: "https://example.com"}
+
+	put := func(t *testing.T, now time.Time, doc models.Document) db.Document {
+		ingester.now = func() time.Time { return now }
+		if _, err := ingester.Put(ctx, PutArgs{Partition: id.Partition, Document: doc}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		stored, _, err := store.DocumentGet(ctx, id)
+		if err != nil {
+			t.Fatalf("failed to get document: %v", err)
+		}
+		return stored
+	}
+
+	stored := put(t, created, models.Document{URL: id.URL, Text: "Version 1."})
+	if !stored.CreatedAt.Equal(created) || !stored.LastUpdatedAt.Equal(created) || !stored.PublishedAt.IsZero() {
+		t.Errorf("expected the timestamps to be set, got %+v", stored)
+	}
+	stored = put(t, updated, models.Document{URL: id.URL, Text: "Version 2.", PublishedAt: &published})
+	if !stored.CreatedAt.Equal(created) || !stored.LastUpdatedAt.Equal(updated) || !stored.PublishedAt.Equal(published) {
+		t.Errorf("expected the creation time to be kept, got %+v", stored)
+	}
+	if !stored.Date().Equal(published) {
+		t.Errorf("expected the document to be dated by its publication time, got %v", stored.Date())
+	}
+}
+
+func TestPutParentSections(t *testing.T) {
+	ctx := context.Background()
+	store, err := memory.New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	splitter := textsplitter.NewMarkdownTextSplitter(textsplitter.WithChunkSize(20), textsplitter.WithChunkOverlap(0))
+	ingester := New(splitter, &countingEmbedder{}, store).WithParentSections(60)
+
+	text := "# One\n\nThe first section is long enough to be split.\n\n# Two\n\nThe second section is as well."
+	result, err := ingester.Put(ctx, PutArgs{
+		Partition: "partition",
+		Document:  models.Document{URL: "https://example.com", Title: "Title", Text: text},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	chunks, err := store.DocumentChunks(ctx, db.DocumentID{Partition: "partition", URL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("failed to get chunks: %v", err)
+	}
+
+	// The title is short, so it's a section of its own, and the text is split at its headings.
+	var sections []string
+	for chunk := 0; chunk < len(chunks); {
+		section, ok, err := store.DocumentSection(ctx, db.DocumentSectionArgs{Partition: "partition", RowID: result.ID, Chunk: int64(chunk)})
+		if err != nil {
+			t.Fatalf("failed to get section: %v", err)
+		}
+		if !ok {
+			t.Fatalf("no section for chunk %d", chunk)
+		}
+		if section.From != chunk || section.To < section.From {
+			t.Fatalf("expected a section starting at chunk %d, got %+v", chunk, section)
+		}
+		sections = append(sections, section.Text)
+		chunk = section.To + 1
+	}
+	expected := []string{
+		"Title",
+		"# One\nThe first section is long enough to be split.",
+		"# Two\nThe second section is as well.",
+	}
+	if diff := cmp.Diff(expected, sections); diff != "" {
+		t.Error(diff)
+	}
+	if len(chunks) <= len(sections) {
+		t.Errorf("expected the sections to be split into smaller chunks, got %d chunks", len(chunks))
+	}
+}
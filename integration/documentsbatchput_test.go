@@ -0,0 +1,44 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/a-h/ragserver/client"
+	"github.com/a-h/ragserver/models"
+)
+
+func TestDocumentsBatchPut(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	c := client.New("http://localhost:9020", "test-api-key-no-llm")
+	resp, err := c.DocumentsBatchPut(context.Background(), models.DocumentsBatchPostRequest{
+		Documents: []models.Document{
+			{
+				URL:   "/test-batch-1",
+				Title: "A test document",
+				Text:  "This is a test document. It is used to test the batch document post endpoint.",
+			},
+			{
+				URL:      "/test-batch-2",
+				Title:    "A test document with invalid metadata",
+				Text:     "This document has a metadata key that can't be filtered on, so it is rejected.",
+				Metadata: map[string]any{"source system": "wiki"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to put documents: %v", err)
+	}
+	if len(resp.Results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(resp.Results))
+	}
+	if resp.Results[0].Error != "" {
+		t.Errorf("expected the first document to succeed, got %q", resp.Results[0].Error)
+	}
+	if resp.Results[1].Error == "" || resp.Results[1].StatusCode != http.StatusBadRequest {
+		t.Errorf("expected the second document to be rejected as invalid, got %+v", resp.Results[1])
+	}
+}
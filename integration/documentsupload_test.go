@@ -0,0 +1,43 @@
+package integration
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/a-h/ragserver/client"
+)
+
+func TestDocumentsUpload(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	c := client.New("http://localhost:9020", "test-api-key-no-llm")
+	resp, err := c.DocumentsUpload(context.Background(), client.DocumentsUploadRequest{
+		Files: []client.UploadFile{
+			{
+				Name:    "test-upload.md",
+				Content: strings.NewReader("# A test document\n\nIt is used to test the document upload endpoint."),
+			},
+			{
+				Name:    "test-upload.png",
+				Content: strings.NewReader("not an image"),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to upload documents: %v", err)
+	}
+	if len(resp.Results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(resp.Results))
+	}
+	if resp.Results[0].Error != "" {
+		t.Errorf("expected the first file to succeed, got %q", resp.Results[0].Error)
+	}
+	if resp.Results[0].Title != "A test document" {
+		t.Errorf("expected the title to be extracted, got %q", resp.Results[0].Title)
+	}
+	if resp.Results[1].Error == "" {
+		t.Error("expected the second file to fail")
+	}
+}
@@ -35,3 +35,33 @@ func TestQueryPost(t *testing.T) {
 		t.Fatalf("expected %q, got %q", querypost.TestMessage, actual)
 	}
 }
+
+func TestQueryPostWithSources(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	var sourcesCalled bool
+	sources := func(ctx context.Context, sources []models.QuerySource) error {
+		sourcesCalled = true
+		return nil
+	}
+	buf := new(bytes.Buffer)
+	f := func(ctx context.Context, chunk []byte) (err error) {
+		_, err = buf.Write(chunk)
+		return err
+	}
+	c := client.New("http://localhost:9020", "test-api-key-no-llm")
+	err := c.QueryPostWithSources(context.Background(), models.QueryPostRequest{
+		Text: "This is a test query.",
+	}, sources, f)
+	if err != nil {
+		t.Fatalf("failed to post query: %v", err)
+	}
+	if !sourcesCalled {
+		t.Error("expected the sources to be received")
+	}
+	actual := buf.String()
+	if actual != querypost.TestMessage {
+		t.Fatalf("expected %q, got %q", querypost.TestMessage, actual)
+	}
+}
@@ -0,0 +1,212 @@
+package loaders
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/tmc/langchaingo/documentloaders"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrUnsupportedType = errors.New("unsupported file type")
+
+// Content types that can be loaded.
+const (
+	ContentTypeText     = "text/plain"
+	ContentTypeMarkdown = "text/markdown"
+	ContentTypeHTML     = "text/html"
+	ContentTypeCSV      = "text/csv"
+	ContentTypePDF      = "application/pdf"
+)
+
+var contentTypeAliases = map[string]string{
+	"text/x-markdown":       ContentTypeMarkdown,
+	"application/xhtml+xml": ContentTypeHTML,
+	"application/csv":       ContentTypeCSV,
+}
+
+var extensionContentTypes = map[string]string{
+	".txt":      ContentTypeText,
+	".text":     ContentTypeText,
+	".md":       ContentTypeMarkdown,
+	".markdown": ContentTypeMarkdown,
+	".html":     ContentTypeHTML,
+	".htm":      ContentTypeHTML,
+	".csv":      ContentTypeCSV,
+	".pdf":      ContentTypePDF,
+}
+
+// ContentType returns the content type of a file that can be loaded. If the
+// content type is empty or generic, e.g. application/octet-stream, the
+// extension of the file name is used instead. If the file can't be loaded,
+// an empty string is returned.
+func ContentType(contentType, name string) string {
+	byExtension := extensionContentTypes[strings.ToLower(path.Ext(name))]
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if alias, ok := contentTypeAliases[mediaType]; ok {
+		mediaType = alias
+	}
+	// Browsers often send markdown files as text/plain.
+	if mediaType == ContentTypeText && byExtension == ContentTypeMarkdown {
+		return ContentTypeMarkdown
+	}
+	switch mediaType {
+	case ContentTypeText, ContentTypeMarkdown, ContentTypeHTML, ContentTypeCSV, ContentTypePDF:
+		return mediaType
+	}
+	return byExtension
+}
+
+// Document is the text extracted from a file.
+type Document struct {
+	// Title is the title of the document, if the file has one.
+	Title string
+	// Summary and PublishedAt are set from the front matter of markdown files.
+	Summary     string
+	PublishedAt *time.Time
+	Text        string
+}
+
+// Load extracts the text of a file, using a loader that's picked by the
+// content type, or the extension of the file name, see ContentType.
+func Load(ctx context.Context, contentType, name string, data []byte) (doc Document, err error) {
+	switch ContentType(contentType, name) {
+	case ContentTypeText:
+		return Document{Text: string(data)}, nil
+	case ContentTypeMarkdown:
+		return loadMarkdown(string(data))
+	case ContentTypeHTML:
+		return loadHTML(ctx, data)
+	case ContentTypeCSV:
+		doc.Text, err = loadText(ctx, documentloaders.NewCSV(bytes.NewReader(data)))
+		return doc, err
+	case ContentTypePDF:
+		doc.Text, err = PDF(ctx, bytes.NewReader(data), int64(len(data)))
+		return doc, err
+	}
+	return doc, fmt.Errorf("%w: %q", ErrUnsupportedType, name)
+}
+
+// PDF extracts the text of each page of a PDF.
+func PDF(ctx context.Context, r io.ReaderAt, size int64) (text string, err error) {
+	text, err = loadText(ctx, documentloaders.NewPDF(r, size))
+	if err != nil {
+		return "", fmt.Errorf("failed to load PDF: %w", err)
+	}
+	return text, nil
+}
+
+func loadHTML(ctx context.Context, data []byte) (doc Document, err error) {
+	page, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	if err != nil {
+		return doc, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+	doc.Title = strings.TrimSpace(page.Find("title").First().Text())
+	if doc.Title == "" {
+		doc.Title = strings.TrimSpace(page.Find("h1").First().Text())
+	}
+	doc.Text, err = HTMLText(ctx, page.Selection)
+	return doc, err
+}
+
+// blockSelector matches the HTML elements that are separated by line breaks.
+const blockSelector = "address, article, aside, blockquote, br, dd, div, dl, dt, figcaption, figure, footer, h1, h2, h3, h4, h5, h6, header, hr, li, main, nav, ol, p, pre, section, table, td, th, tr, ul"
+
+// HTMLText returns the text of the HTML, without scripts or styles, and with
+// line breaks between blocks. The selection is modified.
+func HTMLText(ctx context.Context, sel *goquery.Selection) (text string, err error) {
+	sel.Find("script, style, noscript, template").Remove()
+	sel.Find(blockSelector).AppendHtml("\n")
+	rendered, err := goquery.OuterHtml(sel)
+	if err != nil {
+		return "", fmt.Errorf("failed to render HTML: %w", err)
+	}
+	text, err = loadText(ctx, documentloaders.NewHTML(strings.NewReader(rendered)))
+	if err != nil {
+		return "", err
+	}
+	// The loader escapes the text as HTML.
+	return html.UnescapeString(text), nil
+}
+
+// loadText joins the text of the documents returned by the loader, e.g. the
+// pages of a PDF, or the rows of a CSV file.
+func loadText(ctx context.Context, loader documentloaders.Loader) (text string, err error) {
+	docs, err := loader.Load(ctx)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, doc := range docs {
+		sb.WriteString(doc.PageContent)
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// frontMatter is the YAML front matter of a markdown file.
+type frontMatter struct {
+	Title   string    `yaml:"title"`
+	Summary string    `yaml:"summary"`
+	Date    time.Time `yaml:"date"`
+}
+
+// loadMarkdown reads the title, summary and date from the YAML front matter,
+// if there is any. Otherwise, the title is the first level one heading.
+func loadMarkdown(text string) (doc Document, err error) {
+	var fm frontMatter
+	if yml, body, ok := cutFrontMatter(text); ok {
+		if err = yaml.Unmarshal([]byte(yml), &fm); err != nil {
+			return doc, fmt.Errorf("failed to parse front matter: %w", err)
+		}
+		text = body
+	}
+	doc = Document{
+		Title:   cmp.Or(fm.Title, markdownTitle(text)),
+		Summary: fm.Summary,
+		Text:    text,
+	}
+	if !fm.Date.IsZero() {
+		doc.PublishedAt = &fm.Date
+	}
+	return doc, nil
+}
+
+// cutFrontMatter splits the YAML front matter, between --- lines at the start
+// of the text, from the rest of the text.
+func cutFrontMatter(text string) (yml, body string, ok bool) {
+	rest, ok := strings.CutPrefix(strings.ReplaceAll(text, "\r\n", "\n"), "---\n")
+	if !ok {
+		return "", text, false
+	}
+	if body, ok = strings.CutPrefix(rest, "---\n"); ok {
+		return "", body, true
+	}
+	if yml, body, ok = strings.Cut(rest, "\n---\n"); ok {
+		return yml, strings.TrimLeft(body, "\n"), true
+	}
+	if yml, ok = strings.CutSuffix(rest, "\n---"); ok {
+		return yml, "", true
+	}
+	return "", text, false
+}
+
+// markdownTitle returns the text of the first level one heading.
+func markdownTitle(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if title, ok := strings.CutPrefix(strings.TrimSpace(line), "# "); ok {
+			return strings.TrimSpace(title)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,205 @@
+package loaders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		fileName    string
+		expected    string
+	}{
+		{
+			name:        "the content type is used",
+			contentType: "application/pdf",
+			fileName:    "report",
+			expected:    ContentTypePDF,
+		},
+		{
+			name:        "parameters are ignored",
+			contentType: "text/html; charset=utf-8",
+			fileName:    "index.txt",
+			expected:    ContentTypeHTML,
+		},
+		{
+			name:        "generic content types use the extension",
+			contentType: "application/octet-stream",
+			fileName:    "data.CSV",
+			expected:    ContentTypeCSV,
+		},
+		{
+			name:     "missing content types use the extension",
+			fileName: "index.htm",
+			expected: ContentTypeHTML,
+		},
+		{
+			name:        "markdown sent as text is markdown",
+			contentType: "text/plain",
+			fileName:    "README.md",
+			expected:    ContentTypeMarkdown,
+		},
+		{
+			name:        "aliases are supported",
+			contentType: "text/x-markdown",
+			fileName:    "notes",
+			expected:    ContentTypeMarkdown,
+		},
+		{
+			name:        "unsupported files are empty",
+			contentType: "image/png",
+			fileName:    "image.png",
+			expected:    "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := ContentType(tt.contentType, tt.fileName); actual != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name            string
+		contentType     string
+		fileName        string
+		data            []byte
+		expectedTitle   string
+		expectedSummary string
+		expectedDate    time.Time
+		expectedText    []string
+		unexpectedText  []string
+	}{
+		{
+			name:         "text",
+			fileName:     "notes.txt",
+			data:         []byte("# Not a title\nSome notes."),
+			expectedText: []string{"Some notes."},
+		},
+		{
+			name:          "markdown titles are the first level one heading",
+			fileName:      "notes.md",
+			data:          []byte("Intro\n\n## Section\n\n# Notes\n\nSome notes."),
+			expectedTitle: "Notes",
+			expectedText:  []string{"## Section", "Some notes."},
+		},
+		{
+			name:            "markdown front matter sets the title, summary and date",
+			fileName:        "notes.md",
+			data:            []byte("---\ntitle: From front matter\nsummary: A summary.\ndate: 2024-03-01\n---\n# Heading\n\nSome notes."),
+			expectedTitle:   "From front matter",
+			expectedSummary: "A summary.",
+			expectedDate:    time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			expectedText:    []string{"# Heading"},
+			unexpectedText:  []string{"summary:"},
+		},
+		{
+			name:            "markdown front matter without a title uses the first heading",
+			fileName:        "notes.md",
+			data:            []byte("---\r\nsummary: A summary.\r\n---\r\n# Heading\r\n"),
+			expectedTitle:   "Heading",
+			expectedSummary: "A summary.",
+			unexpectedText:  []string{"---"},
+		},
+		{
+			name:           "HTML titles are the title element",
+			contentType:    "text/html",
+			fileName:       "index",
+			data:           []byte("<html><head><title> The plans </title><script>var x;</script></head><body><h1>Heading</h1><p>The exhaust port&#39;s size.</p></body></html>"),
+			expectedTitle:  "The plans",
+			expectedText:   []string{"Heading\nThe exhaust port's size."},
+			unexpectedText: []string{"var x"},
+		},
+		{
+			name:          "HTML without a title uses the first heading",
+			fileName:      "index.html",
+			data:          []byte("<html><body><h1>Heading</h1><p>The exhaust port.</p></body></html>"),
+			expectedTitle: "Heading",
+			expectedText:  []string{"The exhaust port."},
+		},
+		{
+			name:         "CSV rows are labelled with the header",
+			fileName:     "ships.csv",
+			data:         []byte("name,class\nX-wing,starfighter\nMillennium Falcon,freighter\n"),
+			expectedText: []string{"name: X-wing\nclass: starfighter", "name: Millennium Falcon\nclass: freighter"},
+		},
+		{
+			name:         "PDF text is extracted",
+			fileName:     "plans.pdf",
+			data:         testPDF("The exhaust port"),
+			expectedText: []string{"The exhaust port"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Load(context.Background(), tt.contentType, tt.fileName, tt.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if doc.Title != tt.expectedTitle {
+				t.Errorf("expected title %q, got %q", tt.expectedTitle, doc.Title)
+			}
+			if doc.Summary != tt.expectedSummary {
+				t.Errorf("expected summary %q, got %q", tt.expectedSummary, doc.Summary)
+			}
+			var date time.Time
+			if doc.PublishedAt != nil {
+				date = *doc.PublishedAt
+			}
+			if !date.Equal(tt.expectedDate) {
+				t.Errorf("expected date %v, got %v", tt.expectedDate, date)
+			}
+			for _, text := range tt.expectedText {
+				if !strings.Contains(doc.Text, text) {
+					t.Errorf("expected text to contain %q, got %q", text, doc.Text)
+				}
+			}
+			for _, text := range tt.unexpectedText {
+				if strings.Contains(doc.Text, text) {
+					t.Errorf("expected text not to contain %q, got %q", text, doc.Text)
+				}
+			}
+		})
+	}
+	t.Run("unsupported files return an error", func(t *testing.T) {
+		_, err := Load(context.Background(), "image/png", "image.png", nil)
+		if !errors.Is(err, ErrUnsupportedType) {
+			t.Errorf("expected ErrUnsupportedType, got %v", err)
+		}
+	})
+}
+
+// testPDF returns a single page PDF that contains the text.
+func testPDF(text string) []byte {
+	stream := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%s) Tj ET", text)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+	var sb strings.Builder
+	sb.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = sb.Len()
+		fmt.Fprintf(&sb, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := sb.Len()
+	fmt.Fprintf(&sb, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&sb, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&sb, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return []byte(sb.String())
+}
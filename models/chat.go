@@ -2,6 +2,25 @@ package models
 
 type ChatPostRequest struct {
 	Messages []ChatMessage `json:"msgs"`
+	// RAG retrieves context for the latest human message, if set.
+	RAG *ChatRAG `json:"rag,omitempty"`
+}
+
+// ChatRAG configures retrieval-augmented chat. The conversation is condensed
+// into a standalone question, which is used to retrieve context from the
+// user's documents. The context is added to the latest human message.
+type ChatRAG struct {
+	// Mode of context retrieval, defaults to vector.
+	Mode RetrievalMode `json:"mode,omitempty"`
+	// Filter limits the context to documents with matching metadata.
+	Filter string `json:"filter,omitempty"`
+	// MaxDistance drops context chunks further than this from the question,
+	// overriding the server default if set. Zero disables the threshold.
+	MaxDistance *float64 `json:"maxDistance,omitempty"`
+	// Transform rewrites the condensed question before retrieval, if set.
+	Transform QueryTransform `json:"transform,omitempty"`
+	// Collections to search for context, defaults to the user's personal collection.
+	Collections []string `json:"collections,omitempty"`
 }
 
 type ChatMessageType string
@@ -1,9 +1,76 @@
 package models
 
+import "time"
+
 type ContextPostRequest struct {
 	Text string `json:"text"`
+	// Mode of retrieval, defaults to vector.
+	Mode RetrievalMode `json:"mode,omitempty"`
+	// Filter limits the results to documents with matching metadata,
+	// e.g. `source = "runbooks" and language in ("en", "fr") and tags has "ops"`.
+	Filter string `json:"filter,omitempty"`
+	// MMR selects a diverse set of chunks, rather than the nearest.
+	MMR *MMR `json:"mmr,omitempty"`
+	// MaxChunksPerURL limits the number of chunks from each document, if set.
+	MaxChunksPerURL int `json:"maxChunksPerUrl,omitempty"`
+	// MaxDistance drops chunks further than this from the text, overriding the
+	// server default if set. Zero disables the threshold.
+	MaxDistance *float64 `json:"maxDistance,omitempty"`
+	// Expand adds this many neighbouring chunks before and after each chunk,
+	// merging overlapping chunks into passages in reading order.
+	Expand int `json:"expand,omitempty"`
+	// Transform rewrites the text before retrieval, if set.
+	Transform QueryTransform `json:"transform,omitempty"`
+	// Collections to search, defaults to the user's personal collection.
+	Collections []string `json:"collections,omitempty"`
+	// Since limits the results to documents dated at or after the time. Documents
+	// are dated by their PublishedAt time, or the time they were first put.
+	Since *time.Time `json:"since,omitempty"`
+	// Until limits the results to documents dated at or before the time.
+	Until *time.Time `json:"until,omitempty"`
+	// Recency favours recent documents, if set.
+	Recency *Recency `json:"recency,omitempty"`
+}
+
+// MMR configures maximal marginal relevance selection of chunks.
+type MMR struct {
+	// Lambda trades relevance (1) against diversity (0), e.g. 0.5.
+	Lambda float64 `json:"lambda"`
+}
+
+// Recency blends the relevance of each chunk with the exponential decay of its
+// document's age.
+type Recency struct {
+	// HalfLifeDays is the age, in days, at which a document's recency halves, e.g. 365.
+	HalfLifeDays float64 `json:"halfLifeDays"`
+	// Weight is the share of the score given to recency, between 0 and 1, defaults to 0.5.
+	Weight float64 `json:"weight,omitempty"`
 }
 
+// QueryTransform rewrites the query before retrieval.
+type QueryTransform string
+
+const (
+	// QueryTransformMultiQuery retrieves chunks for several paraphrases of
+	// the query, and fuses the results.
+	QueryTransformMultiQuery QueryTransform = "multi-query"
+	// QueryTransformHyDE embeds a hypothetical answer to the query, instead
+	// of the query.
+	QueryTransformHyDE QueryTransform = "hyde"
+)
+
+// RetrievalMode selects how documents are found.
+type RetrievalMode string
+
+const (
+	// RetrievalModeVector finds the chunks with the nearest embeddings.
+	RetrievalModeVector RetrievalMode = "vector"
+	// RetrievalModeKeyword uses full-text search.
+	RetrievalModeKeyword RetrievalMode = "keyword"
+	// RetrievalModeHybrid fuses vector and keyword results.
+	RetrievalModeHybrid RetrievalMode = "hybrid"
+)
+
 type ContextPostResponse struct {
 	Results []ContextDocument `json:"results"`
 }
@@ -12,7 +79,12 @@ type ContextDocument struct {
 	Text      string    `json:"text"`
 	Embedding []float32 `json:"embedding"`
 	Distance  float64   `json:"distance"`
+	Score     float64   `json:"score,omitempty"`
 	URL       string    `json:"url"`
 	Title     string    `json:"title"`
 	Summary   string    `json:"summary"`
+	// Collection that the document belongs to.
+	Collection string `json:"collection"`
+	// Date of the document, see ContextPostRequest.Since.
+	Date time.Time `json:"date"`
 }
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type DocumentsPostRequest struct {
 	Document Document `json:"document"`
 }
@@ -9,8 +11,107 @@ type Document struct {
 	Title   string `json:"title"`
 	Text    string `json:"text"`
 	Summary string `json:"summary"`
+	// Metadata is a set of key/value pairs that can be used in filters. Keys
+	// must be letters, digits and underscores, and values must be strings,
+	// numbers, booleans, or arrays of them, such as tags.
+	Metadata map[string]any `json:"metadata,omitempty"`
+	// PublishedAt is the time that the source published the document, if
+	// known. Otherwise, the document is dated by the time it was first put.
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
 }
 
 type DocumentsPostResponse struct {
-	ID int64 `json:"id"`
+	ID     int64          `json:"id"`
+	Status DocumentStatus `json:"status"`
+}
+
+// DocumentsBatchMaxDocuments is the maximum number of documents in a batch.
+const DocumentsBatchMaxDocuments = 100
+
+type DocumentsBatchPostRequest struct {
+	Documents []Document `json:"documents"`
+}
+
+type DocumentsBatchPostResponse struct {
+	// Results are in the same order as the request documents.
+	Results []DocumentsBatchPostResult `json:"results"`
+}
+
+type DocumentsBatchPostResult struct {
+	URL    string         `json:"url"`
+	ID     int64          `json:"id,omitempty"`
+	Status DocumentStatus `json:"status,omitempty"`
+	// Error is set if the document could not be put.
+	Error string `json:"error,omitempty"`
+	// StatusCode is set with Error to the status code that the document would
+	// have been rejected with if it was put on its own: 400 if the document is
+	// invalid, or 500 if the server failed to put it, so that it can be retried.
+	StatusCode int `json:"statusCode,omitempty"`
+}
+
+// DocumentsUploadMaxBytes is the maximum size of an upload request.
+const DocumentsUploadMaxBytes = 32 << 20
+
+// DocumentsUploadPostResponse is the response to a multipart upload of files
+// to POST /documents:upload. Each file is uploaded in a part named file. If a
+// single file is uploaded, the url and title parts override the URL and title
+// of the document, which otherwise default to the file name and the title
+// found in the file.
+type DocumentsUploadPostResponse struct {
+	// Results are in the same order as the uploaded files.
+	Results []DocumentsUploadPostResult `json:"results"`
+}
+
+type DocumentsUploadPostResult struct {
+	FileName string         `json:"fileName"`
+	URL      string         `json:"url,omitempty"`
+	Title    string         `json:"title,omitempty"`
+	ID       int64          `json:"id,omitempty"`
+	Status   DocumentStatus `json:"status,omitempty"`
+	// Error is set if the file could not be loaded or put.
+	Error string `json:"error,omitempty"`
+}
+
+// DocumentStatus is the outcome of putting a document.
+type DocumentStatus string
+
+const (
+	// DocumentStatusCreated means that the document didn't previously exist.
+	DocumentStatusCreated DocumentStatus = "created"
+	// DocumentStatusUpdated means that the document content changed.
+	DocumentStatusUpdated DocumentStatus = "updated"
+	// DocumentStatusUnchanged means that the document content was the same, so nothing was written.
+	DocumentStatusUnchanged DocumentStatus = "unchanged"
+)
+
+type DocumentsGetRequest struct {
+	// Prefix limits the results to documents with URLs that start with the prefix.
+	Prefix string
+	// UpdatedSince limits the results to documents updated at or after the time.
+	UpdatedSince time.Time
+	// Cursor is the Next value of the previous page.
+	Cursor string
+	// Limit is the maximum number of documents to return, defaults to 100.
+	Limit int
+}
+
+type DocumentsGetResponse struct {
+	Documents []DocumentMetadata `json:"documents"`
+	// Next is the cursor of the next page, empty if there are no more documents.
+	Next string `json:"next,omitempty"`
+}
+
+type DocumentMetadata struct {
+	URL           string     `json:"url"`
+	Title         string     `json:"title"`
+	Summary       string     `json:"summary"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	LastUpdatedAt time.Time  `json:"lastUpdatedAt"`
+	PublishedAt   *time.Time `json:"publishedAt,omitempty"`
+}
+
+type DocumentGetResponse struct {
+	Document      Document  `json:"document"`
+	CreatedAt     time.Time `json:"createdAt"`
+	LastUpdatedAt time.Time `json:"lastUpdatedAt"`
 }
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type QueryPostRequest struct {
 	// Text of the query.
 	Text string `json:"text"`
@@ -7,4 +9,82 @@ type QueryPostRequest struct {
 	// NoContext indicates context should not be used to populate
 	// chat models.
 	NoContext bool `json:"no-context"`
+
+	// Mode of context retrieval, defaults to vector.
+	Mode RetrievalMode `json:"mode,omitempty"`
+
+	// Filter limits the context to documents with matching metadata,
+	// e.g. `source = "runbooks" and language in ("en", "fr") and tags has "ops"`.
+	Filter string `json:"filter,omitempty"`
+
+	// MMR selects a diverse set of context chunks, rather than the nearest.
+	MMR *MMR `json:"mmr,omitempty"`
+
+	// MaxChunksPerURL limits the number of context chunks from each document, if set.
+	MaxChunksPerURL int `json:"maxChunksPerUrl,omitempty"`
+
+	// MaxDistance drops context chunks further than this from the query,
+	// overriding the server default if set. Zero disables the threshold.
+	MaxDistance *float64 `json:"maxDistance,omitempty"`
+
+	// Expand adds this many neighbouring chunks before and after each context
+	// chunk, merging overlapping chunks into passages in reading order.
+	Expand int `json:"expand,omitempty"`
+
+	// Transform rewrites the query before context retrieval, if set.
+	Transform QueryTransform `json:"transform,omitempty"`
+
+	// Collections to search for context, defaults to the user's personal collection.
+	Collections []string `json:"collections,omitempty"`
+
+	// Since limits the context to documents dated at or after the time. Documents
+	// are dated by their PublishedAt time, or the time they were first put.
+	Since *time.Time `json:"since,omitempty"`
+
+	// Until limits the context to documents dated at or before the time.
+	Until *time.Time `json:"until,omitempty"`
+
+	// Recency favours context from recent documents, if set.
+	Recency *Recency `json:"recency,omitempty"`
+
+	// Strict returns a QueryPostNoRelevantDocumentsResponse, instead of
+	// asking the LLM, if no relevant context is found. Relevance is measured
+	// by distance, so keyword matches are not used as context, strict can't
+	// be used with the keyword retrieval mode, and a max distance must be set,
+	// either by MaxDistance or the server default.
+	Strict bool `json:"strict,omitempty"`
+
+	// Sources streams the response as newline delimited QueryPostEvent
+	// JSON, starting with the sources used as context, instead of plain text.
+	Sources bool `json:"sources,omitempty"`
+}
+
+// QueryPostEvent is a line of a query response, when sources are requested.
+// The first event contains the sources, and the following events contain the
+// text of the answer. If the answer fails after the response has started, the
+// last event contains the Error.
+type QueryPostEvent struct {
+	Sources []QuerySource `json:"sources,omitempty"`
+	Text    string        `json:"text,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// QuerySource is a chunk that was used as context. The answer cites it by its number, e.g. [1].
+type QuerySource struct {
+	Number   int     `json:"number"`
+	URL      string  `json:"url"`
+	Title    string  `json:"title"`
+	Index    int64   `json:"idx"`
+	Distance float64 `json:"distance"`
+	// Collection that the document belongs to.
+	Collection string `json:"collection"`
+	// Date of the document, see QueryPostRequest.Since.
+	Date time.Time `json:"date"`
+}
+
+// QueryPostNoRelevantDocumentsResponse is returned as JSON, instead of a
+// streamed answer, when a strict query finds no relevant context.
+type QueryPostNoRelevantDocumentsResponse struct {
+	NoRelevantDocuments bool   `json:"noRelevantDocuments"`
+	Message             string `json:"message"`
 }
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+type DocumentRevisionsGetResponse struct {
+	Revisions []DocumentRevisionMetadata `json:"revisions"`
+}
+
+type DocumentRevisionMetadata struct {
+	Revision  int       `json:"revision"`
+	Title     string    `json:"title"`
+	Summary   string    `json:"summary"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type DocumentRevisionGetResponse struct {
+	Revision  int       `json:"revision"`
+	Document  Document  `json:"document"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type DocumentRevisionDiffResponse struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+	// Diff is a unified diff of the text of the revisions.
+	Diff string `json:"diff"`
+}
+
+type DocumentRevisionRestoreResponse struct {
+	ID int64 `json:"id"`
+	// Restored is the revision that was restored.
+	Restored int `json:"restored"`
+}
@@ -0,0 +1,42 @@
+package models
+
+type SearchPostRequest struct {
+	// Query is a full-text search query. It supports phrases ("death star"),
+	// prefixes (plan*), boolean operators (AND, OR, NOT) and column filters
+	// (title:, text:, summary:).
+	Query string `json:"query"`
+
+	// Limit is the maximum number of results to return, defaults to 10.
+	Limit int `json:"limit,omitempty"`
+
+	// Offset is the number of results to skip.
+	Offset int `json:"offset,omitempty"`
+
+	// Collections to search, defaults to the user's personal collection.
+	Collections []string `json:"collections,omitempty"`
+}
+
+type SearchPostResponse struct {
+	Results []SearchResult `json:"results"`
+}
+
+type SearchResult struct {
+	URL     string `json:"url"`
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+	// BM25 rank of the document, lower is better.
+	BM25 float64 `json:"bm25"`
+	// TitleHighlight, SummaryHighlight and Snippet are HTML. The document text
+	// is escaped, and the matched terms are wrapped in <mark> tags, so they can
+	// be rendered as HTML.
+
+	// TitleHighlight is the title with matched terms highlighted.
+	TitleHighlight string `json:"titleHighlight"`
+	// SummaryHighlight is the summary with matched terms highlighted.
+	SummaryHighlight string `json:"summaryHighlight"`
+	// Snippet is an excerpt of the text around the matched terms, with matched
+	// terms highlighted.
+	Snippet string `json:"snippet"`
+	// Collection that the document belongs to.
+	Collection string `json:"collection"`
+}
@@ -0,0 +1,49 @@
+package rerank
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/a-h/jsonapi"
+)
+
+// NewHTTP creates a reranker that uses a cross-encoder scoring endpoint, such
+// as the /rerank endpoint of Hugging Face text-embeddings-inference.
+func NewHTTP(url string) *HTTP {
+	return &HTTP{
+		url: url,
+	}
+}
+
+type HTTP struct {
+	url string
+}
+
+type httpRequest struct {
+	Query string   `json:"query"`
+	Texts []string `json:"texts"`
+}
+
+// httpResult is the score of the text at Index in the request.
+type httpResult struct {
+	Index int     `json:"index"`
+	Score float64 `json:"score"`
+}
+
+func (h *HTTP) Score(ctx context.Context, query string, texts []string) (scores []float64, err error) {
+	results, err := jsonapi.Post[httpRequest, []httpResult](ctx, h.url, httpRequest{
+		Query: query,
+		Texts: texts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to score texts: %w", err)
+	}
+	scores = make([]float64, len(texts))
+	for _, r := range results {
+		if r.Index < 0 || r.Index >= len(texts) {
+			return nil, fmt.Errorf("failed to score texts: result index %d out of range", r.Index)
+		}
+		scores[r.Index] = r.Score
+	}
+	return scores, nil
+}
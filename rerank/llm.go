@@ -0,0 +1,66 @@
+package rerank
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+const llmPrompt = `Rate how relevant the passage is to the question, on a scale from 0 (irrelevant) to 10 (answers the question). Reply with the number only.
+
+Question: %s
+
+Passage:
+%s`
+
+// NewLLM creates a reranker that asks the model to score each text. Up to
+// concurrency texts are scored at the same time.
+func NewLLM(model llms.Model, concurrency int) *LLM {
+	return &LLM{
+		model:       model,
+		concurrency: max(concurrency, 1),
+	}
+}
+
+type LLM struct {
+	model       llms.Model
+	concurrency int
+}
+
+var llmScoreRegexp = regexp.MustCompile(`\d+(\.\d+)?`)
+
+func (l *LLM) Score(ctx context.Context, query string, texts []string) (scores []float64, err error) {
+	scores = make([]float64, len(texts))
+	errs := make([]error, len(texts))
+	sem := make(chan struct{}, l.concurrency)
+	var wg sync.WaitGroup
+	for i, text := range texts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			scores[i], errs[i] = l.score(ctx, query, text)
+		}()
+	}
+	wg.Wait()
+	return scores, errors.Join(errs...)
+}
+
+func (l *LLM) score(ctx context.Context, query, text string) (float64, error) {
+	resp, err := llms.GenerateFromSinglePrompt(ctx, l.model, fmt.Sprintf(llmPrompt, query, text), llms.WithTemperature(0))
+	if err != nil {
+		return 0, fmt.Errorf("failed to score text: %w", err)
+	}
+	// Models sometimes explain their answer, so use the first number.
+	match := llmScoreRegexp.FindString(resp)
+	if match == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(match, 64)
+}
@@ -0,0 +1,44 @@
+// Package rerank reorders retrieved chunks by their relevance to a query.
+package rerank
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/a-h/ragserver/db"
+)
+
+// Reranker scores the relevance of each chunk to the query.
+type Reranker interface {
+	// Score returns a relevance score for each text, higher is more relevant.
+	Score(ctx context.Context, query string, texts []string) (scores []float64, err error)
+}
+
+// Rerank sets the Score of each doc using the reranker, and sorts the docs by
+// score, highest first. Docs with equal scores keep their retrieval order.
+func Rerank(ctx context.Context, r Reranker, query string, docs []db.DocumentSelectNearestResult) ([]db.DocumentSelectNearestResult, error) {
+	if len(docs) == 0 {
+		return docs, nil
+	}
+	texts := make([]string, len(docs))
+	for i, doc := range docs {
+		texts[i] = doc.Text
+	}
+	scores, err := r.Score(ctx, query, texts)
+	if err != nil {
+		return nil, err
+	}
+	if len(scores) != len(docs) {
+		return nil, fmt.Errorf("reranker returned %d scores for %d texts", len(scores), len(docs))
+	}
+	reranked := slices.Clone(docs)
+	for i := range reranked {
+		reranked[i].Score = scores[i]
+	}
+	slices.SortStableFunc(reranked, func(a, b db.DocumentSelectNearestResult) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	return reranked, nil
+}
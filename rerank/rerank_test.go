@@ -0,0 +1,106 @@
+package rerank
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestRerank(t *testing.T) {
+	docs := []db.DocumentSelectNearestResult{
+		{Text: "near duplicate"},
+		{Text: "another near duplicate"},
+		{Text: "the answer"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req httpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var results []httpResult
+		for i, text := range req.Texts {
+			score := 0.1
+			if text == "the answer" {
+				score = 0.9
+			}
+			results = append(results, httpResult{Index: i, Score: score})
+		}
+		// Cross-encoder endpoints return results sorted by score.
+		results[0], results[2] = results[2], results[0]
+		json.NewEncoder(w).Encode(results)
+	}))
+	defer srv.Close()
+
+	reranked, err := Rerank(context.Background(), NewHTTP(srv.URL), "question", docs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var actual []string
+	for _, doc := range reranked {
+		actual = append(actual, doc.Text)
+	}
+	expected := []string{"the answer", "near duplicate", "another near duplicate"}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error(diff)
+	}
+	if reranked[0].Score != 0.9 {
+		t.Errorf("expected the score to be set, got %v", reranked[0].Score)
+	}
+}
+
+// shortReranker returns one score fewer than the number of texts.
+type shortReranker struct{}
+
+func (shortReranker) Score(ctx context.Context, query string, texts []string) ([]float64, error) {
+	return make([]float64, len(texts)-1), nil
+}
+
+func TestRerankScoreCountMismatch(t *testing.T) {
+	docs := []db.DocumentSelectNearestResult{{Text: "a"}, {Text: "b"}}
+	if _, err := Rerank(context.Background(), shortReranker{}, "question", docs); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+type fakeModel struct {
+	responses map[string]string
+}
+
+func (m fakeModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	prompt := messages[0].Parts[0].(llms.TextContent).Text
+	for text, response := range m.responses {
+		if strings.HasSuffix(prompt, text) {
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: response}}}, nil
+		}
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "I don't know."}}}, nil
+}
+
+func (m fakeModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+func TestLLM(t *testing.T) {
+	model := fakeModel{
+		responses: map[string]string{
+			"a":     "7",
+			"b":     "Score: 2.5, because it mentions the topic.",
+			"other": "unparseable",
+		},
+	}
+	scores, err := NewLLM(model, 2).Score(context.Background(), "question", []string{"a", "b", "other"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]float64{7, 2.5, 0}, scores); diff != "" {
+		t.Error(diff)
+	}
+}
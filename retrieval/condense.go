@@ -0,0 +1,52 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/a-h/ragserver/models"
+	"github.com/tmc/langchaingo/llms"
+)
+
+const condensePrompt = `Given the following conversation and a follow up question, rephrase the follow up question to be a standalone question that can be used to search for relevant documents. Reply with the standalone question only.
+
+Conversation:
+%s
+Follow up question: %s`
+
+// Condense rewrites the latest human message as a standalone question, using
+// the earlier messages of the conversation, so that follow up questions like
+// "and who owns it?" can be used for retrieval. If there's no earlier
+// conversation, the latest human message is returned as it is.
+func Condense(ctx context.Context, model llms.Model, messages []models.ChatMessage) (question string, err error) {
+	latest := -1
+	for i, m := range messages {
+		if m.Type == models.ChatMessageTypeHuman {
+			latest = i
+		}
+	}
+	if latest < 0 {
+		return "", fmt.Errorf("%w: no human message", ErrInvalidArgs)
+	}
+	var history strings.Builder
+	for _, m := range messages[:latest] {
+		switch m.Type {
+		case models.ChatMessageTypeHuman:
+			fmt.Fprintf(&history, "Human: %s\n", m.Content)
+		case models.ChatMessageTypeAI:
+			fmt.Fprintf(&history, "AI: %s\n", m.Content)
+		}
+	}
+	if history.Len() == 0 {
+		return messages[latest].Content, nil
+	}
+	resp, err := llms.GenerateFromSinglePrompt(ctx, model, fmt.Sprintf(condensePrompt, history.String(), messages[latest].Content), llms.WithTemperature(0))
+	if err != nil {
+		return "", fmt.Errorf("failed to condense question: %w", err)
+	}
+	if question = strings.Trim(strings.TrimSpace(resp), `"`); question == "" {
+		return messages[latest].Content, nil
+	}
+	return question, nil
+}
@@ -0,0 +1,80 @@
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/a-h/ragserver/models"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// promptModel records the prompt, and replies with a fixed response.
+type promptModel struct {
+	prompt   *string
+	response string
+}
+
+func (m promptModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	*m.prompt = messages[0].Parts[0].(llms.TextContent).Text
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: m.response}}}, nil
+}
+
+func (m promptModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+func TestCondense(t *testing.T) {
+	ctx := context.Background()
+	system := models.ChatMessage{Type: models.ChatMessageTypeSystem, Content: "You are a chatbot."}
+
+	t.Run("The first question is used as it is", func(t *testing.T) {
+		var prompt string
+		question, err := Condense(ctx, promptModel{prompt: &prompt}, []models.ChatMessage{
+			system,
+			{Type: models.ChatMessageTypeHuman, Content: "What is the billing service?"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if question != "What is the billing service?" {
+			t.Errorf("unexpected question: %q", question)
+		}
+		if prompt != "" {
+			t.Errorf("expected the model not to be called, got prompt %q", prompt)
+		}
+	})
+	t.Run("Follow up questions are condensed with the conversation", func(t *testing.T) {
+		var prompt string
+		model := promptModel{prompt: &prompt, response: ` "Who owns the billing service?" `}
+		question, err := Condense(ctx, model, []models.ChatMessage{
+			system,
+			{Type: models.ChatMessageTypeHuman, Content: "What is the billing service?"},
+			{Type: models.ChatMessageTypeAI, Content: "It sends invoices."},
+			{Type: models.ChatMessageTypeHuman, Content: "and who owns it?"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if question != "Who owns the billing service?" {
+			t.Errorf("unexpected question: %q", question)
+		}
+		expected := `Given the following conversation and a follow up question, rephrase the follow up question to be a standalone question that can be used to search for relevant documents. Reply with the standalone question only.
+
+Conversation:
+Human: What is the billing service?
+AI: It sends invoices.
+
+Follow up question: and who owns it?`
+		if prompt != expected {
+			t.Errorf("unexpected prompt: %q", prompt)
+		}
+	})
+	t.Run("A human message is required", func(t *testing.T) {
+		var prompt string
+		_, err := Condense(ctx, promptModel{prompt: &prompt}, []models.ChatMessage{system})
+		if !errors.Is(err, ErrInvalidArgs) {
+			t.Errorf("expected ErrInvalidArgs, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package retrieval
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/a-h/ragserver/db"
+)
+
+// maxExpand is the largest number of neighbouring chunks that can be added
+// either side of a retrieved chunk.
+const maxExpand = 10
+
+// window is a range of a document's chunks, built around one or more retrieved chunks.
+type window struct {
+	// doc is the highest ranked chunk in the window.
+	doc db.DocumentSelectNearestResult
+	// rank is the position of doc in the retrieved chunks.
+	rank     int
+	from, to int64
+}
+
+// mergeWindows returns a window of n chunks either side of each retrieved
+// chunk, merging windows that overlap or touch, ordered by the rank of their
+// best chunk. Keyword matches have no chunk index, so they're returned as
+// windows of their own, with from and to set to -1.
+func mergeWindows(docs []db.DocumentSelectNearestResult, n int) (windows []window) {
+	byRowID := map[int64][]window{}
+	for i, doc := range docs {
+		if doc.Index < 0 {
+			windows = append(windows, window{doc: doc, rank: i, from: -1, to: -1})
+			continue
+		}
+		byRowID[doc.RowID] = append(byRowID[doc.RowID], window{
+			doc:  doc,
+			rank: i,
+			from: max(doc.Index-int64(n), 0),
+			to:   doc.Index + int64(n),
+		})
+	}
+	for _, ws := range byRowID {
+		slices.SortFunc(ws, func(a, b window) int {
+			return cmp.Compare(a.from, b.from)
+		})
+		merged := ws[:1]
+		for _, w := range ws[1:] {
+			last := &merged[len(merged)-1]
+			if w.from > last.to+1 {
+				merged = append(merged, w)
+				continue
+			}
+			last.to = max(last.to, w.to)
+			if w.rank < last.rank {
+				last.doc, last.rank = w.doc, w.rank
+			}
+		}
+		windows = append(windows, merged...)
+	}
+	slices.SortFunc(windows, func(a, b window) int {
+		return cmp.Compare(a.rank, b.rank)
+	})
+	return windows
+}
+
+// expandDocs replaces each retrieved chunk with the passage made from its
+// window of neighbouring chunks, in reading order. Chunks whose windows overlap
+// are merged into a single passage, in the position of the best ranked chunk.
+func (r *Retriever) expandDocs(ctx context.Context, docs []db.DocumentSelectNearestResult, n int) (expanded []db.DocumentSelectNearestResult, err error) {
+	windows := mergeWindows(docs, n)
+	expanded = make([]db.DocumentSelectNearestResult, len(windows))
+	for i, w := range windows {
+		expanded[i] = w.doc
+		if w.from < 0 {
+			continue
+		}
+		texts, err := r.store.DocumentChunkRange(ctx, db.DocumentChunkRangeArgs{
+			Partition: w.doc.Partition,
+			RowID:     w.doc.RowID,
+			From:      w.from,
+			To:        w.to,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get chunk range: %w", err)
+		}
+		if len(texts) > 0 {
+			expanded[i].Text = strings.Join(texts, "\n")
+		}
+	}
+	return expanded, nil
+}
@@ -0,0 +1,77 @@
+package retrieval
+
+import (
+	"context"
+	"testing"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/db/memory"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMergeWindows(t *testing.T) {
+	docs := []db.DocumentSelectNearestResult{
+		{RowID: 1, Index: 5},
+		{RowID: 2, Index: 0},
+		{RowID: 1, Index: 1},
+		{RowID: 1, Index: 3},
+		{RowID: 3, Index: -1},
+		{RowID: 1, Index: 10},
+	}
+	type span struct {
+		RowID, Index, From, To int64
+	}
+	var actual []span
+	for _, w := range mergeWindows(docs, 1) {
+		actual = append(actual, span{w.doc.RowID, w.doc.Index, w.from, w.to})
+	}
+	expected := []span{
+		// 0-2, 2-4 and 4-6 touch, so they merge, and take the place of chunk 5.
+		{RowID: 1, Index: 5, From: 0, To: 6},
+		{RowID: 2, Index: 0, From: 0, To: 1},
+		{RowID: 3, Index: -1, From: -1, To: -1},
+		{RowID: 1, Index: 10, From: 9, To: 11},
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestRetrieveExpand(t *testing.T) {
+	ctx := context.Background()
+	store, err := memory.New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.DocumentPut(ctx, db.DocumentPutArgs{
+		Document: db.Document{
+			DocumentID: db.DocumentID{Partition: "partition", URL: "https://example.com"},
+		},
+		Chunks: []db.Chunk{
+			{Text: "# Heading", Embedding: []float32{0, 1}},
+			{Text: "| a | b |", Embedding: []float32{1, 0}},
+			{Text: "| 1 | 2 |", Embedding: []float32{0, 1}},
+			{Text: "Unrelated.", Embedding: []float32{0, 1}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to put document: %v", err)
+	}
+	retriever := New(queryEmbedder{1, 0}, store, db.HybridWeights{})
+
+	docs, err := retriever.Retrieve(ctx, Args{
+		Partitions: []string{"partition"},
+		Text:       "query",
+		Limit:      1,
+		Expand:     1,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("expected 1 passage, got %d", len(docs))
+	}
+	if diff := cmp.Diff("# Heading\n| a | b |\n| 1 | 2 |", docs[0].Text); diff != "" {
+		t.Error(diff)
+	}
+}
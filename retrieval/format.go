@@ -0,0 +1,39 @@
+package retrieval
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/models"
+)
+
+// FormatContext formats the retrieved chunks as context for a prompt. The
+// chunks are numbered from 1, so that answers can cite them, e.g. [1].
+func FormatContext(docs []db.DocumentSelectNearestResult) string {
+	var sb strings.Builder
+	for i, doc := range docs {
+		sb.WriteString(formatContextDoc(i+1, doc))
+	}
+	return sb.String()
+}
+
+func formatContextDoc(number int, doc db.DocumentSelectNearestResult) string {
+	return fmt.Sprintf("## [%d] Context from URL: %q, title: %q\n\n%s\n", number, doc.URL, doc.Title, doc.Text)
+}
+
+// Sources returns the chunks that are used as context, numbered to match FormatContext.
+func Sources(docs []db.DocumentSelectNearestResult) []models.QuerySource {
+	sources := make([]models.QuerySource, len(docs))
+	for i, doc := range docs {
+		sources[i] = models.QuerySource{
+			Number:   i + 1,
+			URL:      doc.URL,
+			Title:    doc.Title,
+			Index:    doc.Index,
+			Distance: doc.Distance,
+			Date:     doc.Date,
+		}
+	}
+	return sources
+}
@@ -0,0 +1,83 @@
+package retrieval
+
+import (
+	"math"
+
+	"github.com/a-h/ragserver/db"
+)
+
+// diversifyDocs selects up to limit docs, which are assumed to be ordered by
+// relevance.
+//
+// If mmr is set, docs are selected by maximal marginal relevance: each step
+// picks the doc with the highest lambda*relevance - (1-lambda)*similarity,
+// where similarity is the highest cosine similarity between the doc's
+// embedding and the embeddings of the docs already selected. A lambda of 1
+// selects by relevance only, and 0 by diversity only. See relevances for how
+// the relevance of each doc is measured.
+//
+// If maxPerURL is greater than zero, at most maxPerURL docs are selected from
+// each URL.
+func diversifyDocs(docs []db.DocumentSelectNearestResult, scored, mmr bool, lambda float64, maxPerURL, limit int) (selected []db.DocumentSelectNearestResult) {
+	relevance := relevances(docs, scored)
+	// similarity[i] is the highest similarity of docs[i] to a selected doc.
+	similarity := make([]float64, len(docs))
+	used := make([]bool, len(docs))
+	perURL := map[string]int{}
+	for len(selected) < limit {
+		best := -1
+		var bestScore float64
+		for i, doc := range docs {
+			if used[i] || (maxPerURL > 0 && perURL[doc.URL] >= maxPerURL) {
+				continue
+			}
+			score := relevance[i]
+			if mmr {
+				score = lambda*relevance[i] - (1-lambda)*similarity[i]
+			}
+			if best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+		used[best] = true
+		perURL[docs[best].URL]++
+		selected = append(selected, docs[best])
+		for i := range docs {
+			if !used[i] {
+				similarity[i] = math.Max(similarity[i], db.CosineSimilarity(docs[i].Embedding, docs[best].Embedding))
+			}
+		}
+	}
+	return selected
+}
+
+// relevances returns the relevance of each doc to the query, normalized to
+// between 0 and 1, so that it can be traded off against similarity whatever
+// the distance metric or score scale. The relevance of vector results is
+// measured by their distance, closest first, and scored docs, e.g. because
+// they were reranked or fused, by their score, highest first.
+func relevances(docs []db.DocumentSelectNearestResult, scored bool) (relevance []float64) {
+	values := make([]float64, len(docs))
+	for i, doc := range docs {
+		values[i] = -doc.Distance
+		if scored {
+			values[i] = doc.Score
+		}
+	}
+	least, most := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		least = min(least, v)
+		most = max(most, v)
+	}
+	relevance = make([]float64, len(docs))
+	for i, v := range values {
+		relevance[i] = 1
+		if most > least {
+			relevance[i] = (v - least) / (most - least)
+		}
+	}
+	return relevance
+}
@@ -0,0 +1,107 @@
+package retrieval
+
+import (
+	"testing"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiversifyDocs(t *testing.T) {
+	// The first three chunks are near-duplicates from the same document.
+	docs := []db.DocumentSelectNearestResult{
+		{URL: "a", Text: "a0", Embedding: []float32{1, 0, 0}, Distance: 0},
+		{URL: "a", Text: "a1", Embedding: []float32{1, 0.01, 0}, Distance: 0.01},
+		{URL: "a", Text: "a2", Embedding: []float32{1, 0.02, 0}, Distance: 0.02},
+		{URL: "b", Text: "b0", Embedding: []float32{0, 1, 0}, Distance: 0.3},
+		{URL: "c", Text: "c0", Embedding: []float32{0, 0, 1}, Distance: 0.4},
+	}
+	tests := []struct {
+		name      string
+		mmr       bool
+		lambda    float64
+		maxPerURL int
+		expected  []string
+	}{
+		{
+			name:     "lambda of 1 keeps the relevance order",
+			mmr:      true,
+			lambda:   1,
+			expected: []string{"a0", "a1", "a2"},
+		},
+		{
+			name:     "lower lambda avoids near-duplicates",
+			mmr:      true,
+			lambda:   0.5,
+			expected: []string{"a0", "b0", "c0"},
+		},
+		{
+			name:      "chunks per URL can be capped without MMR",
+			maxPerURL: 2,
+			expected:  []string{"a0", "a1", "b0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var actual []string
+			for _, doc := range diversifyDocs(docs, false, test.mmr, test.lambda, test.maxPerURL, 3) {
+				actual = append(actual, doc.Text)
+			}
+			if diff := cmp.Diff(test.expected, actual); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestDiversifyDocsRelevance(t *testing.T) {
+	newDocs := func(distances, scores [3]float64) []db.DocumentSelectNearestResult {
+		return []db.DocumentSelectNearestResult{
+			{URL: "a", Text: "a0", Embedding: []float32{1, 0, 0}, Distance: distances[0], Score: scores[0]},
+			{URL: "a", Text: "a1", Embedding: []float32{1, 0.2, 0}, Distance: distances[1], Score: scores[1]},
+			{URL: "b", Text: "b0", Embedding: []float32{0, 1, 0}, Distance: distances[2], Score: scores[2]},
+		}
+	}
+	tests := []struct {
+		name     string
+		docs     []db.DocumentSelectNearestResult
+		scored   bool
+		expected []string
+	}{
+		{
+			// b0 is much further from the query than a0 and a1, so it isn't
+			// relevant enough to be worth the diversity, even though it's ranked
+			// close to a1.
+			name:     "vector results are relevant by their normalized distance",
+			docs:     newDocs([3]float64{0, 0.02, 0.9}, [3]float64{}),
+			expected: []string{"a0", "a1"},
+		},
+		{
+			name:     "distances of any scale are normalized",
+			docs:     newDocs([3]float64{12, 12.4, 30}, [3]float64{}),
+			expected: []string{"a0", "a1"},
+		},
+		{
+			name:     "scored results are relevant by their normalized score",
+			docs:     newDocs([3]float64{}, [3]float64{10, 9.8, 1}),
+			scored:   true,
+			expected: []string{"a0", "a1"},
+		},
+		{
+			name:     "less relevant docs are selected when they're diverse enough",
+			docs:     newDocs([3]float64{0, 0.02, 0.03}, [3]float64{}),
+			expected: []string{"a0", "b0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var actual []string
+			for _, doc := range diversifyDocs(test.docs, test.scored, true, 0.7, 0, 2) {
+				actual = append(actual, doc.Text)
+			}
+			if diff := cmp.Diff(test.expected, actual); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package retrieval
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/tokens"
+)
+
+// minTruncatedTokens is the fewest tokens of a chunk's text that are worth
+// including when the chunk is truncated to fit the budget.
+const minTruncatedTokens = 32
+
+// TokenCounts are the number of tokens in a prompt.
+type TokenCounts struct {
+	// Prompt is the number of tokens in the prompt, excluding the context.
+	Prompt int
+	// Context is the number of tokens in the context.
+	Context int
+	// Budget is the maximum number of tokens in the prompt, or zero if there's no limit.
+	Budget int
+}
+
+func (tc TokenCounts) Total() int {
+	return tc.Prompt + tc.Context
+}
+
+// PackContext returns as many of the ranked chunks as fit in the budget,
+// after the promptTokens used by the rest of the prompt, e.g. the system
+// prompt, the user prompt template and the query. If the next chunk doesn't
+// fit, it's truncated at a line, sentence or word boundary, and no more chunks
+// are added. If the budget is zero, all of the chunks are returned.
+func PackContext(counter tokens.Counter, budget, promptTokens int, docs []db.DocumentSelectNearestResult) (packed []db.DocumentSelectNearestResult, counts TokenCounts) {
+	counts = TokenCounts{Prompt: promptTokens, Budget: budget}
+	for i, doc := range docs {
+		docTokens := counter.Count(formatContextDoc(i+1, doc))
+		if budget <= 0 || counts.Total()+docTokens <= budget {
+			packed = append(packed, doc)
+			counts.Context += docTokens
+			continue
+		}
+		if doc, docTokens, ok := truncateToFit(counter, i+1, doc, budget-counts.Total()); ok {
+			packed = append(packed, doc)
+			counts.Context += docTokens
+		}
+		break
+	}
+	return packed, counts
+}
+
+// truncateToFit truncates the text of the chunk so that the formatted chunk
+// has no more than available tokens. It returns false if too little of the
+// text would be left to be useful.
+func truncateToFit(counter tokens.Counter, number int, doc db.DocumentSelectNearestResult, available int) (truncated db.DocumentSelectNearestResult, docTokens int, ok bool) {
+	runes := []rune(doc.Text)
+	format := func(n int) (db.DocumentSelectNearestResult, int) {
+		d := doc
+		d.Text = truncateText(string(runes[:n]))
+		return d, counter.Count(formatContextDoc(number, d))
+	}
+	// Find the longest prefix that fits.
+	n := sort.Search(len(runes)+1, func(n int) bool {
+		_, docTokens := format(n)
+		return docTokens > available
+	}) - 1
+	if n <= 0 {
+		return doc, 0, false
+	}
+	truncated, docTokens = format(n)
+	if counter.Count(truncated.Text) < minTruncatedTokens {
+		return doc, 0, false
+	}
+	return truncated, docTokens, true
+}
+
+// truncateText cuts the end of the text back to the last line, sentence or
+// word boundary in its second half, and marks it as truncated.
+func truncateText(s string) string {
+	if s == "" {
+		return ""
+	}
+	for _, boundary := range []string{"\n", ". ", " "} {
+		if i := strings.LastIndex(s, boundary); i > len(s)/2 {
+			s = s[:i+len(boundary)]
+			break
+		}
+	}
+	return strings.TrimRight(s, " \n") + "…"
+}
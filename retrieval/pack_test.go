@@ -0,0 +1,76 @@
+package retrieval
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/tokens"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPackContext(t *testing.T) {
+	// One token per character keeps the arithmetic simple.
+	counter := tokens.Approximate{CharsPerToken: 1}
+	line := strings.Repeat("x", 49)
+	docs := []db.DocumentSelectNearestResult{
+		{URL: "a", Text: line},
+		{URL: "b", Text: line},
+		{URL: "c", Text: strings.Repeat(line+"\n", 4)},
+		{URL: "d", Text: line},
+	}
+	docTokens := counter.Count(formatContextDoc(1, docs[0]))
+
+	t.Run("All chunks are packed without a budget", func(t *testing.T) {
+		packed, counts := PackContext(counter, 0, 100, docs)
+		if len(packed) != len(docs) {
+			t.Errorf("expected %d chunks, got %d", len(docs), len(packed))
+		}
+		if counts.Prompt != 100 || counts.Context != counter.Count(FormatContext(docs)) {
+			t.Errorf("unexpected counts: %+v", counts)
+		}
+	})
+	t.Run("Chunks that fit are packed whole, and the next is truncated at a line", func(t *testing.T) {
+		budget := 100 + docTokens*2 + 150
+		packed, counts := PackContext(counter, budget, 100, docs)
+		var urls []string
+		for _, doc := range packed {
+			urls = append(urls, doc.URL)
+		}
+		if diff := cmp.Diff([]string{"a", "b", "c"}, urls); diff != "" {
+			t.Fatal(diff)
+		}
+		if packed[2].Text != line+"\n"+line+"…" {
+			t.Errorf("unexpected truncated text: %q", packed[2].Text)
+		}
+		if counts.Total() > budget {
+			t.Errorf("expected the total of %d to be within the budget of %d", counts.Total(), budget)
+		}
+		if counts.Context != counter.Count(FormatContext(packed)) {
+			t.Errorf("expected the context count to match the formatted context, got %+v", counts)
+		}
+	})
+	t.Run("Chunks are not truncated to almost nothing", func(t *testing.T) {
+		packed, _ := PackContext(counter, 100+docTokens+10, 100, docs)
+		if len(packed) != 1 {
+			t.Errorf("expected 1 chunk, got %d", len(packed))
+		}
+	})
+}
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{text: "", expected: ""},
+		{text: "First line.\nSecond li", expected: "First line.…"},
+		{text: "One sentence. Two sentences. Thr", expected: "One sentence. Two sentences.…"},
+		{text: "some words and a partial wo", expected: "some words and a partial…"},
+	}
+	for _, test := range tests {
+		if actual := truncateText(test.text); actual != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.text, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/a-h/ragserver/db"
+)
+
+// parentDocs replaces the text of each retrieved chunk with the text of its
+// parent section. When several chunks are in the same section, the section is
+// only returned once, in the position of the best ranked chunk. Chunks without
+// a section, such as keyword matches, are returned as they are. At most limit
+// docs are returned.
+func (r *Retriever) parentDocs(ctx context.Context, docs []db.DocumentSelectNearestResult, limit int) (parents []db.DocumentSelectNearestResult, err error) {
+	type sectionKey struct {
+		rowID int64
+		from  int
+	}
+	seen := map[sectionKey]bool{}
+	parents = make([]db.DocumentSelectNearestResult, 0, min(len(docs), limit))
+	for _, doc := range docs {
+		if len(parents) >= limit {
+			break
+		}
+		if doc.Index < 0 {
+			parents = append(parents, doc)
+			continue
+		}
+		section, ok, err := r.store.DocumentSection(ctx, db.DocumentSectionArgs{
+			Partition: doc.Partition,
+			RowID:     doc.RowID,
+			Chunk:     doc.Index,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get section: %w", err)
+		}
+		if !ok {
+			parents = append(parents, doc)
+			continue
+		}
+		key := sectionKey{rowID: doc.RowID, from: section.From}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		doc.Text = section.Text
+		parents = append(parents, doc)
+	}
+	return parents, nil
+}
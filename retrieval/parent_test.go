@@ -0,0 +1,56 @@
+package retrieval
+
+import (
+	"context"
+	"testing"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/db/memory"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRetrieveParentSections(t *testing.T) {
+	ctx := context.Background()
+	store, err := memory.New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = store.DocumentPut(ctx, db.DocumentPutArgs{
+		Document: db.Document{
+			DocumentID: db.DocumentID{Partition: "partition", URL: "https://example.com"},
+		},
+		Chunks: []db.Chunk{
+			{Text: "one a", Embedding: []float32{1, 0}},
+			{Text: "one b", Embedding: []float32{1, 0.1}},
+			{Text: "two a", Embedding: []float32{1, 0.2}},
+			{Text: "three a", Embedding: []float32{0, 1}},
+		},
+		Sections: []db.Section{
+			{Text: "# One\none a\none b", From: 0, To: 1},
+			{Text: "# Two\ntwo a", From: 2, To: 2},
+			{Text: "# Three\nthree a", From: 3, To: 3},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to put document: %v", err)
+	}
+	retriever := New(queryEmbedder{1, 0}, store, db.HybridWeights{}).WithParentSections()
+
+	docs, err := retriever.Retrieve(ctx, Args{
+		Partitions: []string{"partition"},
+		Text:       "query",
+		Limit:      3,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var texts []string
+	for _, doc := range docs {
+		texts = append(texts, doc.Text)
+	}
+	// The first two chunks share a section, so it's only returned once, and
+	// the next section fills the limit.
+	if diff := cmp.Diff([]string{"# One\none a\none b", "# Two\ntwo a", "# Three\nthree a"}, texts); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,76 @@
+package retrieval
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/models"
+)
+
+// defaultRecencyWeight is used when the weight of a Recency is zero.
+const defaultRecencyWeight = 0.5
+
+// Recency favours chunks of recently dated documents, see db.Document.Date.
+type Recency struct {
+	// HalfLife is the age at which a document's recency halves.
+	HalfLife time.Duration
+	// Weight is the share of the score given to recency, between 0 and 1.
+	// Zero uses the default weight of 0.5.
+	Weight float64
+}
+
+// NewRecency converts the recency of a request, returning nil if it's not set.
+func NewRecency(r *models.Recency) *Recency {
+	if r == nil {
+		return nil
+	}
+	return &Recency{
+		HalfLife: time.Duration(r.HalfLifeDays * float64(24*time.Hour)),
+		Weight:   r.Weight,
+	}
+}
+
+// weightRecency scores each chunk by blending its relevance with the
+// exponential decay of its document's age, and sorts the chunks by score.
+// Relevance is the score of scored chunks, or the distance of vector matches,
+// scaled to between 0 (the least relevant chunk) and 1 (the most relevant).
+// Undated documents are treated as the oldest.
+func weightRecency(docs []db.DocumentSelectNearestResult, scored bool, recency Recency, now time.Time) []db.DocumentSelectNearestResult {
+	if len(docs) == 0 {
+		return docs
+	}
+	weight := recency.Weight
+	if weight == 0 {
+		weight = defaultRecencyWeight
+	}
+	relevance := func(doc db.DocumentSelectNearestResult) float64 {
+		if scored {
+			return doc.Score
+		}
+		return -doc.Distance
+	}
+	least, most := math.Inf(1), math.Inf(-1)
+	for _, doc := range docs {
+		least = min(least, relevance(doc))
+		most = max(most, relevance(doc))
+	}
+	for i, doc := range docs {
+		normalized := 1.0
+		if most > least {
+			normalized = (relevance(doc) - least) / (most - least)
+		}
+		var decay float64
+		if !doc.Date.IsZero() {
+			age := max(now.Sub(doc.Date), 0)
+			decay = math.Pow(0.5, float64(age)/float64(recency.HalfLife))
+		}
+		docs[i].Score = (1-weight)*normalized + weight*decay
+	}
+	slices.SortStableFunc(docs, func(a, b db.DocumentSelectNearestResult) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	return docs
+}
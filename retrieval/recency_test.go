@@ -0,0 +1,114 @@
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/db/memory"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRetrieveRecency(t *testing.T) {
+	ctx := context.Background()
+	store, err := memory.New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	// The 2022 policy is a slightly better match for the query than the 2026 policy.
+	policies := []struct {
+		url         string
+		publishedAt time.Time
+		embedding   []float32
+	}{
+		{url: "policy-2022", publishedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), embedding: []float32{1, 0}},
+		{url: "policy-2026", publishedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), embedding: []float32{1, 0.2}},
+		{url: "unrelated", publishedAt: time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC), embedding: []float32{0, 1}},
+	}
+	for _, p := range policies {
+		_, err := store.DocumentPut(ctx, db.DocumentPutArgs{
+			Document: db.Document{
+				DocumentID:  db.DocumentID{Partition: "partition", URL: p.url},
+				CreatedAt:   now,
+				PublishedAt: p.publishedAt,
+			},
+			Chunks: []db.Chunk{{Text: p.url, Embedding: p.embedding}},
+		})
+		if err != nil {
+			t.Fatalf("failed to put document: %v", err)
+		}
+	}
+	retriever := New(queryEmbedder{1, 0}, store, db.HybridWeights{})
+	retriever.now = func() time.Time { return now }
+
+	retrieve := func(t *testing.T, args Args) (urls []string) {
+		args.Partitions = []string{"partition"}
+		args.Text = "what's the current policy?"
+		docs, err := retriever.Retrieve(ctx, args)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, doc := range docs {
+			urls = append(urls, doc.URL)
+		}
+		return urls
+	}
+
+	t.Run("Without recency, the nearest chunk is first", func(t *testing.T) {
+		if diff := cmp.Diff([]string{"policy-2022", "policy-2026"}, retrieve(t, Args{Limit: 2})); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("Recency favours the recent document", func(t *testing.T) {
+		urls := retrieve(t, Args{Limit: 2, Recency: &Recency{HalfLife: 365 * 24 * time.Hour}})
+		if diff := cmp.Diff([]string{"policy-2026", "policy-2022"}, urls); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("Dates limit the documents", func(t *testing.T) {
+		urls := retrieve(t, Args{Limit: 3, Dates: db.DateRange{
+			Since: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			Until: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		}})
+		if diff := cmp.Diff([]string{"policy-2026"}, urls); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("The half-life must be positive", func(t *testing.T) {
+		_, err := retriever.Retrieve(ctx, Args{
+			Partitions: []string{"partition"},
+			Text:       "query",
+			Limit:      1,
+			Recency:    &Recency{},
+		})
+		if !errors.Is(err, ErrInvalidArgs) {
+			t.Errorf("expected ErrInvalidArgs, got %v", err)
+		}
+	})
+}
+
+func TestWeightRecency(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	docs := []db.DocumentSelectNearestResult{
+		{URL: "relevant-undated", Score: 10},
+		{URL: "irrelevant-new", Score: 0, Date: now},
+		{URL: "fairly-relevant-year-old", Score: 6, Date: now.AddDate(-1, 0, 0)},
+	}
+	weighted := weightRecency(docs, true, Recency{HalfLife: 365 * 24 * time.Hour, Weight: 0.5}, now)
+	expected := map[string]float64{
+		"fairly-relevant-year-old": 0.5*0.6 + 0.5*0.5,
+		"relevant-undated":         0.5 * 1,
+		"irrelevant-new":           0.5 * 1,
+	}
+	for _, doc := range weighted {
+		if diff := expected[doc.URL] - doc.Score; diff > 0.001 || diff < -0.001 {
+			t.Errorf("%s: expected score %v, got %v", doc.URL, expected[doc.URL], doc.Score)
+		}
+	}
+	if weighted[0].URL != "fairly-relevant-year-old" {
+		t.Errorf("expected the year old document first, got %q", weighted[0].URL)
+	}
+}
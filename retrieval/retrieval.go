@@ -0,0 +1,292 @@
+package retrieval
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/models"
+	"github.com/a-h/ragserver/rerank"
+	"github.com/tmc/langchaingo/embeddings"
+)
+
+var ErrInvalidArgs = errors.New("invalid retrieval arguments")
+
+func New(embedder embeddings.Embedder, store db.Store, weights db.HybridWeights) *Retriever {
+	return &Retriever{
+		embedder: embedder,
+		store:    store,
+		weights:  weights,
+		now:      time.Now,
+	}
+}
+
+// Retriever finds the chunks that are relevant to a piece of text.
+type Retriever struct {
+	embedder embeddings.Embedder
+	store    db.Store
+	weights  db.HybridWeights
+	// reranker reorders candidate chunks, if set.
+	reranker   rerank.Reranker
+	candidates int
+	// maxDistance drops vector matches that are further away, if greater than zero.
+	maxDistance float64
+	// parents returns the parent section of each chunk, if set.
+	parents bool
+	// transformer rewrites queries, if set.
+	transformer *transformer
+	// now is used to find the age of documents.
+	now func() time.Time
+}
+
+// WithReranker returns a retriever that fetches at least candidates chunks,
+// and reranks them before returning the requested number of chunks.
+func (r *Retriever) WithReranker(reranker rerank.Reranker, candidates int) *Retriever {
+	rr := *r
+	rr.reranker = reranker
+	rr.candidates = candidates
+	return &rr
+}
+
+// WithMaxDistance returns a retriever that drops vector matches further than
+// maxDistance from the query, unless overridden by Args.MaxDistance.
+// Zero disables the threshold.
+func (r *Retriever) WithMaxDistance(maxDistance float64) *Retriever {
+	rr := *r
+	rr.maxDistance = maxDistance
+	return &rr
+}
+
+// WithParentSections returns a retriever that matches chunks, but returns the
+// text of the parent sections that contain them. Documents must be ingested
+// with sections, see ingest.Ingester.WithParentSections.
+func (r *Retriever) WithParentSections() *Retriever {
+	rr := *r
+	rr.parents = true
+	return &rr
+}
+
+type Args struct {
+	// Partitions to search. Results from each partition are merged.
+	Partitions []string
+	Text       string
+	Mode       models.RetrievalMode
+	Limit      int
+	// Filter is a metadata filter expression, see db.ParseFilter.
+	Filter string
+	// MMR selects diverse chunks by maximal marginal relevance.
+	MMR bool
+	// MMRLambda trades relevance (1) against diversity (0).
+	MMRLambda float64
+	// MaxChunksPerURL limits the number of chunks from each document, if greater than zero.
+	MaxChunksPerURL int
+	// MaxDistance overrides the retriever's maximum distance, if set. Zero disables the threshold.
+	MaxDistance *float64
+	// Strict only keeps matches that are known to be relevant. Keyword
+	// matches have no distance, so only vector matches are kept, and keyword
+	// mode can't be used. Relevance is measured by the maximum distance, so
+	// it must be set.
+	Strict bool
+	// Expand adds this many neighbouring chunks before and after each chunk,
+	// merging chunks from the same part of a document into one passage.
+	Expand int
+	// Transform rewrites the text before retrieval, if set. The retriever
+	// must be configured with WithQueryTransforms.
+	Transform models.QueryTransform
+	// Dates limits the results to chunks of documents dated within the range.
+	Dates db.DateRange
+	// Recency favours chunks of recent documents, if set.
+	Recency *Recency
+}
+
+// diversityCandidatesFactor is the number of candidates fetched per requested
+// chunk when diversifying, weighting by recency, or merging chunks into parent
+// sections, so that there are alternatives to select from.
+const diversityCandidatesFactor = 4
+
+func (r *Retriever) Retrieve(ctx context.Context, args Args) (docs []db.DocumentSelectNearestResult, err error) {
+	filter, err := db.ParseFilter(args.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArgs, err)
+	}
+	if args.MMR && (args.MMRLambda < 0 || args.MMRLambda > 1) {
+		return nil, fmt.Errorf("%w: MMR lambda must be between 0 and 1", ErrInvalidArgs)
+	}
+	if args.MaxChunksPerURL < 0 {
+		return nil, fmt.Errorf("%w: max chunks per URL must not be negative", ErrInvalidArgs)
+	}
+	maxDistance := r.maxDistance
+	if args.MaxDistance != nil {
+		maxDistance = *args.MaxDistance
+	}
+	if maxDistance < 0 {
+		return nil, fmt.Errorf("%w: max distance must not be negative", ErrInvalidArgs)
+	}
+	if args.Strict && args.Mode == models.RetrievalModeKeyword {
+		return nil, fmt.Errorf("%w: strict mode can't be used with keyword retrieval, because keyword matches have no distance", ErrInvalidArgs)
+	}
+	if args.Strict && maxDistance == 0 {
+		return nil, fmt.Errorf("%w: strict mode requires a max distance, because every vector match is within an unlimited distance", ErrInvalidArgs)
+	}
+	if args.Expand < 0 || args.Expand > maxExpand {
+		return nil, fmt.Errorf("%w: expand must be between 0 and %d", ErrInvalidArgs, maxExpand)
+	}
+	if args.Expand > 0 && r.parents {
+		return nil, fmt.Errorf("%w: expand can't be used with parent sections", ErrInvalidArgs)
+	}
+	if args.Recency != nil && args.Recency.HalfLife <= 0 {
+		return nil, fmt.Errorf("%w: recency half-life must be positive", ErrInvalidArgs)
+	}
+	if args.Recency != nil && (args.Recency.Weight < 0 || args.Recency.Weight > 1) {
+		return nil, fmt.Errorf("%w: recency weight must be between 0 and 1", ErrInvalidArgs)
+	}
+	if !args.Dates.Since.IsZero() && !args.Dates.Until.IsZero() && args.Dates.Until.Before(args.Dates.Since) {
+		return nil, fmt.Errorf("%w: until must not be before since", ErrInvalidArgs)
+	}
+	if len(args.Partitions) == 0 {
+		return nil, fmt.Errorf("%w: at least one partition is required", ErrInvalidArgs)
+	}
+	if args.Transform != "" && r.transformer == nil {
+		return nil, fmt.Errorf("%w: query transforms are not enabled", ErrInvalidArgs)
+	}
+	diversify := args.MMR || args.MaxChunksPerURL > 0
+
+	// Over-fetch candidates for reranking and diversification.
+	limit := args.Limit
+	if r.reranker != nil {
+		args.Limit = max(args.Limit, r.candidates)
+	}
+	if diversify || args.Recency != nil || r.parents {
+		args.Limit = max(args.Limit, limit*diversityCandidatesFactor)
+	}
+	switch args.Transform {
+	case "":
+		docs, err = r.retrieve(ctx, args, filter, args.Text)
+	case models.QueryTransformMultiQuery:
+		docs, err = r.retrieveMultiQuery(ctx, args, filter)
+	case models.QueryTransformHyDE:
+		docs, err = r.retrieveHyDE(ctx, args, filter)
+	default:
+		err = fmt.Errorf("%w: unknown transform %q", ErrInvalidArgs, args.Transform)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if maxDistance > 0 || args.Strict {
+		docs = withinDistance(docs, maxDistance, args.Strict)
+	}
+	if r.reranker != nil {
+		docs, err = rerank.Rerank(ctx, r.reranker, args.Text, docs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rerank: %w", err)
+		}
+	}
+	scored := r.reranker != nil || args.Transform == models.QueryTransformMultiQuery ||
+		args.Mode == models.RetrievalModeKeyword || args.Mode == models.RetrievalModeHybrid
+	if args.Recency != nil {
+		docs = weightRecency(docs, scored, *args.Recency, r.now())
+		scored = true
+	}
+	// Chunks in the same parent section are merged, so the candidates are
+	// only cut to the limit after merging.
+	selectLimit := limit
+	if r.parents {
+		selectLimit = len(docs)
+	}
+	if diversify {
+		docs = diversifyDocs(docs, scored, args.MMR, args.MMRLambda, args.MaxChunksPerURL, selectLimit)
+	} else {
+		docs = docs[:min(len(docs), selectLimit)]
+	}
+	if r.parents {
+		return r.parentDocs(ctx, docs, limit)
+	}
+	if args.Expand > 0 {
+		return r.expandDocs(ctx, docs, args.Expand)
+	}
+	return docs, nil
+}
+
+// retrieve finds chunks in each partition with the retrieval mode, and merges
+// them. Vector search uses the embedding of embedText, and keyword search uses
+// the text of the args.
+func (r *Retriever) retrieve(ctx context.Context, args Args, filter db.Filter, embedText string) (docs []db.DocumentSelectNearestResult, err error) {
+	var embedding []float32
+	if args.Mode != models.RetrievalModeKeyword {
+		if embedding, err = r.embedder.EmbedQuery(ctx, embedText); err != nil {
+			return nil, fmt.Errorf("failed to embed query: %w", err)
+		}
+	}
+	for _, partition := range args.Partitions {
+		partitionDocs, err := r.retrievePartition(ctx, args, partition, filter, embedding)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, partitionDocs...)
+	}
+	if len(args.Partitions) > 1 {
+		docs = mergePartitions(docs, args.Mode, args.Limit)
+	}
+	return docs, nil
+}
+
+func (r *Retriever) retrievePartition(ctx context.Context, args Args, partition string, filter db.Filter, embedding []float32) (docs []db.DocumentSelectNearestResult, err error) {
+	switch args.Mode {
+	case "", models.RetrievalModeVector:
+		return r.store.DocumentNearest(ctx, db.DocumentSelectNearestArgs{
+			Partition: partition,
+			Embedding: embedding,
+			Limit:     args.Limit,
+			Filter:    filter,
+			Dates:     args.Dates,
+		})
+	case models.RetrievalModeKeyword:
+		return r.store.DocumentKeyword(ctx, db.DocumentKeywordArgs{
+			Partition: partition,
+			Query:     db.KeywordQuery(args.Text),
+			Limit:     args.Limit,
+			Filter:    filter,
+			Dates:     args.Dates,
+		})
+	case models.RetrievalModeHybrid:
+		return db.DocumentHybrid(ctx, r.store, db.DocumentHybridArgs{
+			Partition: partition,
+			Text:      args.Text,
+			Embedding: embedding,
+			Limit:     args.Limit,
+			Weights:   r.weights,
+			Filter:    filter,
+			Dates:     args.Dates,
+		})
+	}
+	return nil, fmt.Errorf("%w: unknown mode %q", ErrInvalidArgs, args.Mode)
+}
+
+// mergePartitions orders the chunks found in several partitions, nearest
+// first for vector search, or by score for keyword and hybrid search, and
+// returns the top limit chunks.
+func mergePartitions(docs []db.DocumentSelectNearestResult, mode models.RetrievalMode, limit int) []db.DocumentSelectNearestResult {
+	slices.SortStableFunc(docs, func(a, b db.DocumentSelectNearestResult) int {
+		if mode == "" || mode == models.RetrievalModeVector {
+			return cmp.Compare(a.Distance, b.Distance)
+		}
+		return cmp.Compare(b.Score, a.Score)
+	})
+	return docs[:min(len(docs), limit)]
+}
+
+// withinDistance drops vector matches that are further than maxDistance from
+// the query, unless maxDistance is zero. Keyword matches have no distance, so
+// they're kept, unless strict is set.
+func withinDistance(docs []db.DocumentSelectNearestResult, maxDistance float64, strict bool) []db.DocumentSelectNearestResult {
+	return slices.DeleteFunc(docs, func(doc db.DocumentSelectNearestResult) bool {
+		if doc.Index < 0 {
+			return strict
+		}
+		return maxDistance > 0 && doc.Distance > maxDistance
+	})
+}
@@ -0,0 +1,217 @@
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/db/memory"
+	"github.com/a-h/ragserver/models"
+	"github.com/google/go-cmp/cmp"
+)
+
+// queryEmbedder embeds every query as the same vector.
+type queryEmbedder []float32
+
+func (e queryEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (e queryEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	return e, nil
+}
+
+func TestRetrieveMaxDistance(t *testing.T) {
+	ctx := context.Background()
+	store, err := memory.New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	// The memory store uses cosine distance, so "near" is 0, "orthogonal" is 1
+	// and "opposite" is 2 from the query.
+	chunks := map[string][]float32{
+		"near":       {1, 0},
+		"orthogonal": {0, 1},
+		"opposite":   {-1, 0},
+	}
+	for url, embedding := range chunks {
+		_, err := store.DocumentPut(ctx, db.DocumentPutArgs{
+			Document: db.Document{
+				DocumentID: db.DocumentID{Partition: "partition", URL: url},
+				Text:       url,
+			},
+			Chunks: []db.Chunk{{Text: url, Embedding: embedding}},
+		})
+		if err != nil {
+			t.Fatalf("failed to put document: %v", err)
+		}
+	}
+	retriever := New(queryEmbedder{1, 0}, store, db.HybridWeights{}).WithMaxDistance(1.5)
+
+	retrieve := func(t *testing.T, maxDistance *float64) (urls []string) {
+		docs, err := retriever.Retrieve(ctx, Args{
+			Partitions:  []string{"partition"},
+			Text:        "query",
+			Limit:       10,
+			MaxDistance: maxDistance,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, doc := range docs {
+			urls = append(urls, doc.URL)
+		}
+		return urls
+	}
+	ptr := func(f float64) *float64 { return &f }
+
+	t.Run("The server default drops distant chunks", func(t *testing.T) {
+		if diff := cmp.Diff([]string{"near", "orthogonal"}, retrieve(t, nil)); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("The request can tighten the threshold", func(t *testing.T) {
+		if diff := cmp.Diff([]string{"near"}, retrieve(t, ptr(0.5))); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("The request can disable the threshold", func(t *testing.T) {
+		if diff := cmp.Diff([]string{"near", "orthogonal", "opposite"}, retrieve(t, ptr(0))); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("A negative threshold is invalid", func(t *testing.T) {
+		_, err := retriever.Retrieve(ctx, Args{
+			Partitions:  []string{"partition"},
+			Text:        "query",
+			Limit:       10,
+			MaxDistance: ptr(-1),
+		})
+		if !errors.Is(err, ErrInvalidArgs) {
+			t.Errorf("expected ErrInvalidArgs, got %v", err)
+		}
+	})
+}
+
+func TestRetrieveStrict(t *testing.T) {
+	ctx := context.Background()
+	store, err := memory.New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	// The "far" chunk is opposite to the query. The "keyword" document has no
+	// chunks, so it can only be found by keyword search.
+	docs := []db.DocumentPutArgs{
+		{
+			Document: db.Document{DocumentID: db.DocumentID{Partition: "partition", URL: "far"}, Text: "boats"},
+			Chunks:   []db.Chunk{{Text: "boats", Embedding: []float32{-1, 0}}},
+		},
+		{
+			Document: db.Document{DocumentID: db.DocumentID{Partition: "partition", URL: "keyword"}, Text: "rockets"},
+		},
+	}
+	for _, doc := range docs {
+		if _, err := store.DocumentPut(ctx, doc); err != nil {
+			t.Fatalf("failed to put document: %v", err)
+		}
+	}
+	retriever := New(queryEmbedder{1, 0}, store, db.HybridWeights{Vector: 1, Keyword: 1, K: 60}).WithMaxDistance(0.5)
+
+	retrieve := func(mode models.RetrievalMode, strict bool) (urls []string, err error) {
+		docs, err := retriever.Retrieve(ctx, Args{
+			Partitions: []string{"partition"},
+			Text:       "rockets",
+			Mode:       mode,
+			Limit:      10,
+			Strict:     strict,
+		})
+		for _, doc := range docs {
+			urls = append(urls, doc.URL)
+		}
+		return urls, err
+	}
+
+	t.Run("Hybrid retrieval includes keyword matches", func(t *testing.T) {
+		urls, err := retrieve(models.RetrievalModeHybrid, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff([]string{"keyword"}, urls); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("Strict hybrid retrieval drops keyword matches, so nothing is relevant", func(t *testing.T) {
+		urls, err := retrieve(models.RetrievalModeHybrid, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(urls) != 0 {
+			t.Errorf("expected no results, got %v", urls)
+		}
+	})
+	t.Run("Strict keyword retrieval is invalid", func(t *testing.T) {
+		if _, err := retrieve(models.RetrievalModeKeyword, true); !errors.Is(err, ErrInvalidArgs) {
+			t.Errorf("expected ErrInvalidArgs, got %v", err)
+		}
+	})
+	t.Run("Strict retrieval without a max distance is invalid", func(t *testing.T) {
+		unlimited := 0.0
+		_, err := retriever.Retrieve(ctx, Args{
+			Partitions:  []string{"partition"},
+			Text:        "rockets",
+			Mode:        models.RetrievalModeVector,
+			Limit:       10,
+			MaxDistance: &unlimited,
+			Strict:      true,
+		})
+		if !errors.Is(err, ErrInvalidArgs) {
+			t.Errorf("expected ErrInvalidArgs, got %v", err)
+		}
+	})
+}
+
+func TestRetrievePartitions(t *testing.T) {
+	ctx := context.Background()
+	store, err := memory.New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	chunks := []struct {
+		partition string
+		url       string
+		embedding []float32
+	}{
+		{partition: "user", url: "near", embedding: []float32{1, 0}},
+		{partition: "user", url: "far", embedding: []float32{-1, 0}},
+		{partition: "team", url: "nearer", embedding: []float32{1, 0.01}},
+		{partition: "team", url: "orthogonal", embedding: []float32{0, 1}},
+		{partition: "other", url: "nearest", embedding: []float32{1, 0}},
+	}
+	for _, c := range chunks {
+		_, err := store.DocumentPut(ctx, db.DocumentPutArgs{
+			Document: db.Document{DocumentID: db.DocumentID{Partition: c.partition, URL: c.url}},
+			Chunks:   []db.Chunk{{Text: c.url, Embedding: c.embedding}},
+		})
+		if err != nil {
+			t.Fatalf("failed to put document: %v", err)
+		}
+	}
+	docs, err := New(queryEmbedder{1, 0}, store, db.HybridWeights{}).Retrieve(ctx, Args{
+		Partitions: []string{"user", "team"},
+		Text:       "query",
+		Limit:      3,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, doc := range docs {
+		got = append(got, doc.Partition+"/"+doc.URL)
+	}
+	// Results are merged by distance, and other partitions aren't searched.
+	expected := []string{"user/near", "team/nearer", "team/orthogonal"}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,132 @@
+package retrieval
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/tmc/langchaingo/llms"
+)
+
+const multiQueryPrompt = `Write %d different versions of the following question, to find relevant documents with a search engine. Each version should use different words. Reply with one question per line, without numbering.
+
+Question: %s`
+
+const hydePrompt = `Write a short passage from a document that answers the following question. Reply with the passage only.
+
+Question: %s`
+
+// multiQueryRRFK dampens the effect of the top ranks when the results of
+// multiple queries are fused.
+const multiQueryRRFK = 60
+
+type transformer struct {
+	log     *slog.Logger
+	model   llms.Model
+	queries int
+}
+
+// WithQueryTransforms returns a retriever that uses the model to rewrite
+// queries when Args.Transform is set. Multi-query retrieval generates up to
+// queries paraphrases of the query. The generated text is logged at debug level.
+func (r *Retriever) WithQueryTransforms(log *slog.Logger, model llms.Model, queries int) *Retriever {
+	rr := *r
+	rr.transformer = &transformer{
+		log:     log,
+		model:   model,
+		queries: max(queries, 1),
+	}
+	return &rr
+}
+
+// retrieveMultiQuery retrieves chunks for the query, and for paraphrases of
+// it, and fuses the results with reciprocal rank fusion.
+func (r *Retriever) retrieveMultiQuery(ctx context.Context, args Args, filter db.Filter) (docs []db.DocumentSelectNearestResult, err error) {
+	t := r.transformer
+	resp, err := llms.GenerateFromSinglePrompt(ctx, t.model, fmt.Sprintf(multiQueryPrompt, t.queries, args.Text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate queries: %w", err)
+	}
+	queries := append([]string{args.Text}, parseQueries(resp, args.Text, t.queries)...)
+	t.log.Debug("generated queries", slog.String("query", args.Text), slog.Any("queries", queries[1:]))
+
+	rankings := make([][]db.DocumentSelectNearestResult, len(queries))
+	for i, query := range queries {
+		queryArgs := args
+		queryArgs.Text = query
+		if rankings[i], err = r.retrieve(ctx, queryArgs, filter, query); err != nil {
+			return nil, err
+		}
+	}
+	return fuseRankings(rankings, args.Limit), nil
+}
+
+// retrieveHyDE embeds a hypothetical answer to the query, instead of the
+// query. Keyword search still uses the query.
+func (r *Retriever) retrieveHyDE(ctx context.Context, args Args, filter db.Filter) (docs []db.DocumentSelectNearestResult, err error) {
+	t := r.transformer
+	passage, err := llms.GenerateFromSinglePrompt(ctx, t.model, fmt.Sprintf(hydePrompt, args.Text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate hypothetical answer: %w", err)
+	}
+	passage = strings.TrimSpace(passage)
+	t.log.Debug("generated hypothetical answer", slog.String("query", args.Text), slog.String("answer", passage))
+	if passage == "" {
+		passage = args.Text
+	}
+	return r.retrieve(ctx, args, filter, passage)
+}
+
+// parseQueries returns up to n of the queries in the model's response, one
+// per line, without numbering, bullets or duplicates of the original query.
+func parseQueries(resp, original string, n int) (queries []string) {
+	seen := map[string]bool{strings.ToLower(original): true}
+	for _, line := range strings.Split(resp, "\n") {
+		query := strings.TrimLeft(strings.TrimSpace(line), "0123456789.)-*• ")
+		query = strings.Trim(strings.TrimSpace(query), `"`)
+		if query == "" || seen[strings.ToLower(query)] {
+			continue
+		}
+		seen[strings.ToLower(query)] = true
+		queries = append(queries, query)
+		if len(queries) == n {
+			break
+		}
+	}
+	return queries
+}
+
+// fuseRankings merges ranked lists of chunks with reciprocal rank fusion. A
+// chunk that's found by several queries keeps its smallest distance.
+func fuseRankings(rankings [][]db.DocumentSelectNearestResult, limit int) (docs []db.DocumentSelectNearestResult) {
+	type chunkKey struct {
+		rowID, index int64
+	}
+	positions := map[chunkKey]int{}
+	for _, ranking := range rankings {
+		for rank, doc := range ranking {
+			key := chunkKey{rowID: doc.RowID, index: doc.Index}
+			score := 1 / float64(multiQueryRRFK+rank+1)
+			i, ok := positions[key]
+			if !ok {
+				positions[key] = len(docs)
+				doc.Score = score
+				docs = append(docs, doc)
+				continue
+			}
+			docs[i].Score += score
+			docs[i].Distance = min(docs[i].Distance, doc.Distance)
+		}
+	}
+	slices.SortStableFunc(docs, func(a, b db.DocumentSelectNearestResult) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	if limit > 0 && len(docs) > limit {
+		docs = docs[:limit]
+	}
+	return docs
+}
@@ -0,0 +1,202 @@
+package retrieval
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/a-h/ragserver/db"
+	"github.com/a-h/ragserver/db/memory"
+	"github.com/a-h/ragserver/models"
+	"github.com/google/go-cmp/cmp"
+)
+
+// mapEmbedder embeds queries with a fixed embedding for each text, and records
+// the queries that it embeds.
+type mapEmbedder struct {
+	embeddings map[string][]float32
+	queries    *[]string
+}
+
+func (e mapEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (e mapEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	*e.queries = append(*e.queries, text)
+	if embedding, ok := e.embeddings[text]; ok {
+		return embedding, nil
+	}
+	return nil, errors.New("unexpected text: " + text)
+}
+
+func TestParseQueries(t *testing.T) {
+	tests := []struct {
+		name     string
+		resp     string
+		n        int
+		expected []string
+	}{
+		{
+			name: "numbering, bullets, quotes and duplicates of the original are removed",
+			resp: `1. Who owns the billing service?
+- "Which team is responsible for billing?"
+
+what owns billing
+* Who owns the billing service?
+Who maintains invoicing?`,
+			n: 3,
+			expected: []string{
+				"Who owns the billing service?",
+				"Which team is responsible for billing?",
+				"Who maintains invoicing?",
+			},
+		},
+		{
+			name:     "at most n queries are returned",
+			resp:     "1) One?\n2) Two?\n3) Three?",
+			n:        2,
+			expected: []string{"One?", "Two?"},
+		},
+		{
+			name: "responses without queries return nothing",
+			resp: "\n- \n\"What owns billing\"\n",
+			n:    3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.expected, parseQueries(test.resp, "What owns billing", test.n)); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestFuseRankings(t *testing.T) {
+	a := db.DocumentSelectNearestResult{RowID: 1, Index: 0, Distance: 0.4}
+	b := db.DocumentSelectNearestResult{RowID: 1, Index: 1, Distance: 0.2}
+	c := db.DocumentSelectNearestResult{RowID: 2, Index: 0, Distance: 0.3}
+	fused := fuseRankings([][]db.DocumentSelectNearestResult{
+		{a, b},
+		{c, withDistance(a, 0.1)},
+	}, 2)
+	if len(fused) != 2 {
+		t.Fatalf("expected 2 chunks, got %d", len(fused))
+	}
+	// a is found by both queries, so it's first, with its smallest distance.
+	if fused[0].RowID != 1 || fused[0].Index != 0 || fused[0].Distance != 0.1 {
+		t.Errorf("unexpected first chunk: %+v", fused[0])
+	}
+	// c and b are ranked first and second by one query each.
+	if fused[1].RowID != 2 {
+		t.Errorf("unexpected second chunk: %+v", fused[1])
+	}
+}
+
+func withDistance(doc db.DocumentSelectNearestResult, distance float64) db.DocumentSelectNearestResult {
+	doc.Distance = distance
+	return doc
+}
+
+func TestRetrieveTransforms(t *testing.T) {
+	ctx := context.Background()
+	store, err := memory.New("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for url, embedding := range map[string][]float32{"owners": {1, 0}, "invoices": {0, 1}} {
+		_, err := store.DocumentPut(ctx, db.DocumentPutArgs{
+			Document: db.Document{DocumentID: db.DocumentID{Partition: "partition", URL: url}},
+			Chunks:   []db.Chunk{{Text: url, Embedding: embedding}},
+		})
+		if err != nil {
+			t.Fatalf("failed to put document: %v", err)
+		}
+	}
+	embeddings := map[string][]float32{
+		"billing?":                   {0.1, 1},
+		"The payments team owns it.": {1, 0.1},
+		"Who owns billing?":          {1, 0},
+	}
+	retrieve := func(t *testing.T, response string, transform models.QueryTransform, limit int) (docs []db.DocumentSelectNearestResult, queries []string, prompt string) {
+		embedder := mapEmbedder{embeddings: embeddings, queries: &queries}
+		retriever := New(embedder, store, db.HybridWeights{}).
+			WithQueryTransforms(slog.Default(), promptModel{prompt: &prompt, response: response}, 3)
+		docs, err := retriever.Retrieve(ctx, Args{
+			Partitions: []string{"partition"},
+			Text:       "billing?",
+			Limit:      limit,
+			Transform:  transform,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return docs, queries, prompt
+	}
+	urls := func(docs []db.DocumentSelectNearestResult) (urls []string) {
+		for _, doc := range docs {
+			urls = append(urls, doc.URL)
+		}
+		return urls
+	}
+
+	t.Run("HyDE embeds the hypothetical answer", func(t *testing.T) {
+		docs, queries, prompt := retrieve(t, "The payments team owns it.", models.QueryTransformHyDE, 1)
+		if !strings.Contains(prompt, "billing?") {
+			t.Errorf("expected the prompt to contain the query, got %q", prompt)
+		}
+		if diff := cmp.Diff([]string{"The payments team owns it."}, queries); diff != "" {
+			t.Errorf("expected the answer to be embedded instead of the query: %v", diff)
+		}
+		if diff := cmp.Diff([]string{"owners"}, urls(docs)); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("Multi-query fuses the results of each query", func(t *testing.T) {
+		// The original query finds invoices, and the paraphrase finds owners,
+		// so they're tied, and the first ranking wins.
+		docs, queries, _ := retrieve(t, "Who owns billing?", models.QueryTransformMultiQuery, 1)
+		if diff := cmp.Diff([]string{"billing?", "Who owns billing?"}, queries); diff != "" {
+			t.Errorf("expected the original query and the generated query to be embedded: %v", diff)
+		}
+		if diff := cmp.Diff([]string{"invoices"}, urls(docs)); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("Multi-query parses the generated queries, and fuses chunks found by several queries", func(t *testing.T) {
+		resp := "1. Who owns billing?\n2. billing?\n3. \"Who owns billing?\""
+		docs, queries, _ := retrieve(t, resp, models.QueryTransformMultiQuery, 2)
+		// Duplicates of the original query and of each other aren't embedded.
+		if diff := cmp.Diff([]string{"billing?", "Who owns billing?"}, queries); diff != "" {
+			t.Errorf("unexpected embedded queries: %v", diff)
+		}
+		// Both queries find both chunks, which are only returned once each,
+		// scored by their rank in both queries.
+		if diff := cmp.Diff([]string{"invoices", "owners"}, urls(docs)); diff != "" {
+			t.Error(diff)
+		}
+		expectedScore := 1/float64(multiQueryRRFK+1) + 1/float64(multiQueryRRFK+2)
+		for _, doc := range docs {
+			if math.Abs(doc.Score-expectedScore) > 1e-9 {
+				t.Errorf("expected %q to have a fused score of %v, got %v", doc.URL, expectedScore, doc.Score)
+			}
+		}
+	})
+	t.Run("Transforms must be enabled", func(t *testing.T) {
+		var queries []string
+		embedder := mapEmbedder{embeddings: embeddings, queries: &queries}
+		_, err := New(embedder, store, db.HybridWeights{}).Retrieve(ctx, Args{
+			Partitions: []string{"partition"},
+			Text:       "billing?",
+			Limit:      1,
+			Transform:  models.QueryTransformHyDE,
+		})
+		if !errors.Is(err, ErrInvalidArgs) {
+			t.Errorf("expected ErrInvalidArgs, got %v", err)
+		}
+	})
+}
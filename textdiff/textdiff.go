@@ -0,0 +1,236 @@
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type OpType int
+
+const (
+	OpEqual OpType = iota
+	OpDelete
+	OpInsert
+)
+
+// Op is a line that is equal in both texts, deleted from a, or inserted from b.
+type Op struct {
+	Type OpType
+	Line string
+}
+
+// Lines returns the line edits required to turn a into b, using the linear
+// space variant of the Myers diff algorithm, which divides the texts at the
+// middle of the shortest edit script, and diffs each half.
+func Lines(a, b []string) (ops []Op) {
+	return appendLines(nil, a, b)
+}
+
+func appendLines(ops []Op, a, b []string) []Op {
+	// Lines that are common to the start or end of both texts are unchanged.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	ops = appendOps(ops, OpEqual, a[:prefix])
+	a, b = a[prefix:], b[prefix:]
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	if x, y, ok := middleSnake(a, b); ok {
+		ops = appendLines(ops, a[:x], b[:y])
+		ops = appendLines(ops, a[x:], b[y:])
+	} else {
+		ops = appendOps(ops, OpDelete, a)
+		ops = appendOps(ops, OpInsert, b)
+	}
+	return appendOps(ops, OpEqual, common)
+}
+
+func appendOps(ops []Op, t OpType, lines []string) []Op {
+	for _, line := range lines {
+		ops = append(ops, Op{Type: t, Line: line})
+	}
+	return ops
+}
+
+// middleSnake runs the Myers algorithm forwards from the start and backwards
+// from the end of the texts until the paths overlap, and returns the point
+// where they meet. If the texts have no lines in common, ok is false. The
+// texts must not start or end with the same line.
+func middleSnake(a, b []string) (x, y int, ok bool) {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return 0, 0, false
+	}
+	maxD := (n + m + 1) / 2
+	// forward[k+offset] is the furthest x reached on diagonal k from the
+	// start, and backward[k+offset] is the furthest distance from the end.
+	offset := maxD
+	forward := make([]int, 2*maxD+2)
+	backward := make([]int, 2*maxD+2)
+	for i := range forward {
+		forward[i] = -1
+		backward[i] = -1
+	}
+	forward[offset+1] = 0
+	backward[offset+1] = 0
+	delta := n - m
+	// If the difference in length is odd, the forward path overlaps the
+	// backward path, otherwise the backward path overlaps the forward path.
+	checkForward := delta%2 != 0
+	// Diagonals that run off the edges of the texts are trimmed.
+	var forwardStart, forwardEnd, backwardStart, backwardEnd int
+	for d := 0; d < maxD; d++ {
+		for k := -d + forwardStart; k <= d-forwardEnd; k += 2 {
+			i := offset + k
+			var fx int
+			if k == -d || (k != d && forward[i-1] < forward[i+1]) {
+				fx = forward[i+1]
+			} else {
+				fx = forward[i-1] + 1
+			}
+			fy := fx - k
+			for fx < n && fy < m && a[fx] == b[fy] {
+				fx++
+				fy++
+			}
+			forward[i] = fx
+			switch {
+			case fx > n:
+				forwardEnd += 2
+			case fy > m:
+				forwardStart += 2
+			case checkForward:
+				j := offset + delta - k
+				if j >= 0 && j < len(backward) && backward[j] != -1 && fx >= n-backward[j] {
+					return fx, fy, true
+				}
+			}
+		}
+		for k := -d + backwardStart; k <= d-backwardEnd; k += 2 {
+			i := offset + k
+			var bx int
+			if k == -d || (k != d && backward[i-1] < backward[i+1]) {
+				bx = backward[i+1]
+			} else {
+				bx = backward[i-1] + 1
+			}
+			by := bx - k
+			for bx < n && by < m && a[n-bx-1] == b[m-by-1] {
+				bx++
+				by++
+			}
+			backward[i] = bx
+			switch {
+			case bx > n:
+				backwardEnd += 2
+			case by > m:
+				backwardStart += 2
+			case !checkForward:
+				j := offset + delta - k
+				if j >= 0 && j < len(forward) && forward[j] != -1 {
+					fx := forward[j]
+					fy := fx - (j - offset)
+					if fx >= n-bx {
+						return fx, fy, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// Unified returns a unified diff of a and b, with the given number of lines of
+// context around each change. If the texts are equal, an empty string is returned.
+func Unified(aName, bName, a, b string, context int) string {
+	ops := Lines(splitLines(a), splitLines(b))
+
+	var sb strings.Builder
+	// Line numbers of the next op in a and b.
+	aLine, bLine := 1, 1
+	for i := 0; i < len(ops); {
+		if ops[i].Type == OpEqual {
+			aLine++
+			bLine++
+			i++
+			continue
+		}
+		// Start a hunk, including up to context lines before the change.
+		start := max(i-context, 0)
+		for j := start; j < i; j++ {
+			aLine--
+			bLine--
+		}
+		// Extend the hunk until there are more than 2*context equal lines.
+		end := i
+		for end < len(ops) {
+			if ops[end].Type != OpEqual {
+				end++
+				continue
+			}
+			equal := 0
+			for end+equal < len(ops) && ops[end+equal].Type == OpEqual {
+				equal++
+			}
+			if end+equal == len(ops) || equal > 2*context {
+				end += min(equal, context)
+				break
+			}
+			end += equal
+		}
+
+		var aCount, bCount int
+		for _, op := range ops[start:end] {
+			if op.Type != OpInsert {
+				aCount++
+			}
+			if op.Type != OpDelete {
+				bCount++
+			}
+		}
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aName, bName)
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(aLine, aCount), hunkRange(bLine, bCount))
+		for _, op := range ops[start:end] {
+			switch op.Type {
+			case OpEqual:
+				sb.WriteString(" ")
+			case OpDelete:
+				sb.WriteString("-")
+			case OpInsert:
+				sb.WriteString("+")
+			}
+			sb.WriteString(op.Line)
+			sb.WriteString("\n")
+		}
+		aLine += aCount
+		bLine += bCount
+		i = end
+	}
+	return sb.String()
+}
+
+func hunkRange(line, count int) string {
+	if count == 0 {
+		// Empty ranges refer to the line before the change.
+		return fmt.Sprintf("%d,0", line-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,123 @@
+package textdiff
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        string
+		b        string
+		expected string
+	}{
+		{
+			name:     "equal texts have no diff",
+			a:        "a\nb\n",
+			b:        "a\nb\n",
+			expected: "",
+		},
+		{
+			name:     "changed lines are shown with context",
+			a:        "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:        "1\n2\n3\nfour\n5\n6\n7\n8\n",
+			expected: "--- a\n+++ b\n@@ -2,5 +2,5 @@\n 2\n 3\n-4\n+four\n 5\n 6\n",
+		},
+		{
+			name:     "insertion into an empty text",
+			a:        "",
+			b:        "new\n",
+			expected: "--- a\n+++ b\n@@ -0,0 +1 @@\n+new\n",
+		},
+		{
+			name:     "distant changes are split into hunks",
+			a:        "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:        "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			expected: "--- a\n+++ b\n@@ -1,3 +1,3 @@\n-1\n+one\n 2\n 3\n@@ -8,3 +8,3 @@\n 8\n 9\n-10\n+ten\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := Unified("a", "b", test.a, test.b, 2)
+			if actual != test.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLines(t *testing.T) {
+	// The edits must turn a into b, and be as few as the longest common
+	// subsequence allows.
+	r := rand.New(rand.NewPCG(1, 2))
+	randomLines := func() (lines []string) {
+		for range r.IntN(30) {
+			lines = append(lines, string(rune('a'+r.IntN(4))))
+		}
+		return lines
+	}
+	for i := range 1000 {
+		a, b := randomLines(), randomLines()
+		ops := Lines(a, b)
+		var actualA, actualB []string
+		var edits int
+		for _, op := range ops {
+			if op.Type != OpInsert {
+				actualA = append(actualA, op.Line)
+			}
+			if op.Type != OpDelete {
+				actualB = append(actualB, op.Line)
+			}
+			if op.Type != OpEqual {
+				edits++
+			}
+		}
+		if !slices.Equal(a, actualA) || !slices.Equal(b, actualB) {
+			t.Fatalf("%d: edits of %q to %q are incorrect: %v", i, a, b, ops)
+		}
+		if expected := len(a) + len(b) - 2*lcs(a, b); edits != expected {
+			t.Fatalf("%d: expected %d edits of %q to %q, got %d: %v", i, expected, a, b, edits, ops)
+		}
+	}
+}
+
+func lcs(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+	return lengths[0][0]
+}
+
+func TestLinesMemory(t *testing.T) {
+	// Rewriting every line is the worst case for the edit distance.
+	a := make([]string, 4000)
+	b := make([]string, 4000)
+	for i := range a {
+		a[i] = fmt.Sprintf("a %d", i)
+		b[i] = fmt.Sprintf("b %d", i)
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	ops := Lines(a, b)
+	runtime.ReadMemStats(&after)
+	if len(ops) != 8000 {
+		t.Errorf("expected 8000 edits, got %d", len(ops))
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 10<<20 {
+		t.Errorf("expected less than 10MiB to be allocated, got %d bytes", allocated)
+	}
+}
@@ -0,0 +1,52 @@
+// Package tokens counts the tokens in text, so that prompts can be kept within
+// a model's context window.
+package tokens
+
+import (
+	"fmt"
+	"math"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// Counter counts the tokens in text.
+type Counter interface {
+	Count(text string) int
+}
+
+// Approximate estimates the number of tokens from the number of characters.
+// It doesn't depend on the model, so it's fast, but it's only an estimate.
+type Approximate struct {
+	// CharsPerToken is the average number of characters in a token, e.g. 4 for English text.
+	CharsPerToken float64
+}
+
+func (a Approximate) Count(text string) int {
+	return int(math.Ceil(float64(utf8.RuneCountInString(text)) / a.CharsPerToken))
+}
+
+// Tiktoken counts tokens with a tiktoken encoding. Create it with NewTiktoken,
+// so that the encoding is only loaded once.
+type Tiktoken struct {
+	encoding *tiktoken.Tiktoken
+}
+
+// NewTiktoken loads the tiktoken encoding of the model. Models that tiktoken
+// doesn't recognise use the gpt2 encoding, as langchaingo's llms.CountTokens
+// does. The encoding is downloaded if it isn't cached, and an error is
+// returned if it can't be loaded.
+func NewTiktoken(model string) (t Tiktoken, err error) {
+	encoding, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		encoding, err = tiktoken.GetEncoding("gpt2")
+	}
+	if err != nil {
+		return t, fmt.Errorf("failed to load tiktoken encoding for model %q: %w", model, err)
+	}
+	return Tiktoken{encoding: encoding}, nil
+}
+
+func (t Tiktoken) Count(text string) int {
+	return len(t.encoding.Encode(text, nil, nil))
+}
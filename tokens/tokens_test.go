@@ -0,0 +1,22 @@
+package tokens
+
+import "testing"
+
+func TestApproximate(t *testing.T) {
+	counter := Approximate{CharsPerToken: 4}
+	tests := []struct {
+		text     string
+		expected int
+	}{
+		{text: "", expected: 0},
+		{text: "abc", expected: 1},
+		{text: "abcd", expected: 1},
+		{text: "abcde", expected: 2},
+		{text: "ééééé", expected: 2},
+	}
+	for _, test := range tests {
+		if actual := counter.Count(test.text); actual != test.expected {
+			t.Errorf("%q: expected %d, got %d", test.text, test.expected, actual)
+		}
+	}
+}